@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type CreateServiceAccountInput struct {
+	Username string `json:"username" binding:"required,min=3,max=64"`
+	Role     string `json:"role"`
+}
+
+type CreateAPIKeyInput struct {
+	UserID    int        `json:"user_id" binding:"required"`
+	Name      string     `json:"name" binding:"required,max=64"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func CreateServiceAccount(c *gin.Context) {
+	var input CreateServiceAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username (3-64 chars) is required"})
+		return
+	}
+
+	role := models.RoleViewer
+	if input.Role != "" {
+		role = models.Role(input.Role)
+	}
+	if !role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	user, err := models.CreateServiceAccount(auditContext(c), input.Username, role)
+	if err != nil {
+		if models.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+			return
+		}
+		logrus.Error("Failed to create service account:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Service account created successfully", "user": user})
+}
+
+func CreateAPIKey(c *gin.Context) {
+	var input CreateAPIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id, name and at least one scope are required"})
+		return
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	key, raw, err := models.CreateAPIKey(auditContext(c), input.UserID, input.Name, input.Scopes, input.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		case errors.Is(err, models.ErrNotServiceAccount):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "API keys can only be issued to service accounts"})
+		case errors.Is(err, models.ErrScopeNotAllowed):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scopes must be permissions of the service account role"})
+		default:
+			logrus.Error("Failed to create API key:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		}
+		return
+	}
+
+	// the raw key is only returned once
+	c.JSON(http.StatusCreated, gin.H{"message": "API key created successfully", "key": raw, "api_key": key})
+}
+
+func GetAPIKeys(c *gin.Context) {
+	userID := 0
+	if param := c.Query("user_id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		userID = id
+	}
+
+	keys, err := models.ListAPIKeys(userID)
+	if err != nil {
+		logrus.Error("Failed to retrieve API keys:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := models.RevokeAPIKey(auditContext(c), id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		logrus.Error("Failed to revoke API key:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type AttributeInput struct {
+	Code    string               `json:"code"`
+	Name    string               `json:"name"`
+	Type    models.AttributeType `json:"type"`
+	Options models.StringList    `json:"options"`
+}
+
+// UpdateAttributeInput is the changeable part of an attribute, the code and
+// the type are fixed
+type UpdateAttributeInput struct {
+	Name    string            `json:"name"`
+	Options models.StringList `json:"options"`
+}
+
+// GetAttributes returns the attribute definitions
+func GetAttributes(c *gin.Context) {
+	attributes, err := models.GetAttributes()
+	if err != nil {
+		logrus.Error("Failed to retrieve attributes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve attributes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, attributes)
+}
+
+func CreateAttribute(c *gin.Context) {
+	var input AttributeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	attribute := models.Attribute{Code: input.Code, Name: input.Name, Type: input.Type, Options: input.Options}
+	id, err := models.CreateAttribute(auditContext(c), &attribute)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			respondValidationErrors(c, "Invalid attribute", validationErrs)
+			return
+		}
+		if models.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Attribute code already exists"})
+			return
+		}
+		logrus.Error("Failed to create attribute:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create attribute"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Attribute created successfully", "id": id})
+}
+
+// UpdateAttribute renames the attribute or adds options to it
+func UpdateAttribute(c *gin.Context) {
+	var input UpdateAttributeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	attribute, err := models.UpdateAttribute(auditContext(c), c.Param("code"), input.Name, input.Options)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			respondValidationErrors(c, "Invalid attribute", validationErrs)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attribute not found"})
+		default:
+			logrus.Error("Failed to update attribute:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update attribute"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attribute updated successfully", "attribute": attribute})
+}
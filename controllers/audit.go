@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const maxAuditPageSize = 200
+
+// auditContext carries the user and the request into the audited writes
+func auditContext(c *gin.Context) context.Context {
+	return models.WithActor(c.Request.Context(), models.Actor{
+		Username:  c.GetString("username"),
+		RequestID: c.GetString("request_id"),
+		ClientIP:  c.ClientIP(),
+	})
+}
+
+// GetAuditEntries pages through the audit trail, newest entries first
+func GetAuditEntries(c *gin.Context) {
+	q := models.AuditQuery{Entity: c.Query("entity"), Actor: c.Query("actor"), Limit: 50}
+	errs := models.ValidationErrors{}
+	if param := c.Query("id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil || id < 1 {
+			errs["id"] = "must be a positive integer"
+		} else if q.Entity == "" {
+			errs["id"] = "requires entity"
+		}
+		q.EntityID = id
+	}
+	if param := c.Query("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			errs["limit"] = fmt.Sprintf("must be between 1 and %d", maxAuditPageSize)
+		}
+		q.Limit = limit
+	}
+	if param := c.Query("cursor"); param != "" {
+		cursor, err := strconv.Atoi(param)
+		if err != nil || cursor < 1 {
+			errs["cursor"] = "is invalid"
+		}
+		q.BeforeID = cursor
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, "Invalid query parameters", errs)
+		return
+	}
+
+	page, err := models.GetAuditEntries(q)
+	if err != nil {
+		logrus.Error("Failed to retrieve audit entries:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit entries"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
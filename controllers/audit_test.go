@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+const auditInsert = "INSERT INTO `audit_entries` (`actor`,`action`,`entity`,`entity_id`,`changes`,`request_id`,`client_ip`,`created_at`) VALUES (?,?,?,?,?,?,?,?)"
+
+// expectAudit expects the audit entry of a change made without a signed in
+// user, the tests don't run the authentication middleware
+func expectAudit(mock sqlmock.Sqlmock, action, entity string, id int) {
+	mock.ExpectExec(regexp.QuoteMeta(auditInsert)).
+		WithArgs(models.SystemActor, action, entity, id, sqlmock.AnyArg(), "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestAuditRecordsActor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).AddRow(1, "APP-001", "APPLE", "simple", "10", "USD", 3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `deleted_at`=? WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(auditInsert)).
+		WithArgs("alice", "delete", "product", 1, sqlmock.AnyArg(), "req-1", "10.0.0.1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	// what the authentication and request ID middlewares set
+	r.Use(func(c *gin.Context) {
+		c.Set("username", "alice")
+		c.Set("request_id", "req-1")
+	})
+	r.DELETE("/products/:id", DeleteProduct)
+
+	req, _ := http.NewRequest("DELETE", "/products/1", nil)
+	req.Header.Set("If-Match", "*")
+	req.RemoteAddr = "10.0.0.1:41234"
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestGetAuditEntries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `audit_entries` WHERE entity = ? AND entity_id = ? ORDER BY id DESC LIMIT ?")).
+		WithArgs("product", 1, 51).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "action", "entity", "entity_id", "changes", "request_id", "client_ip"}).
+			AddRow(7, "alice", "update", "product", 1, `{"price":{"from":10,"to":12}}`, "req-1", "10.0.0.1"))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/audit", GetAuditEntries)
+
+	req, _ := http.NewRequest("GET", "/audit?entity=product&id=1", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	expectedBody := `{"data":[{"id":7,"actor":"alice","action":"update","entity":"product","entity_id":1,
+		"changes":{"price":{"from":10,"to":12}},"request_id":"req-1","client_ip":"10.0.0.1","created_at":"0001-01-01T00:00:00Z"}],
+		"limit":50,"next_cursor":null}`
+	assert.JSONEq(t, expectedBody, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestGetAuditEntriesWithInvalidQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/audit", GetAuditEntries)
+
+	req, _ := http.NewRequest("GET", "/audit?id=1&limit=500&cursor=abc", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"id":"requires entity","limit":"must be between 1 and 200","cursor":"is invalid"}}`, resp.Body.String())
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"myapp/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type LoginInput struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func Login(c *gin.Context) {
+	var input LoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username and password are required"})
+		return
+	}
+
+	user, err := models.AuthenticateUser(input.Username, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		case errors.Is(err, models.ErrUserDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"error": "User is disabled"})
+		default:
+			logrus.Error("Failed to authenticate user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not authenticate user"})
+		}
+		return
+	}
+
+	// every login starts a new refresh token family
+	familyID, err := utils.NewTokenID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+	refreshToken, err := models.IssueRefreshToken(models.DB, user.ID, familyID)
+	if err != nil {
+		logrus.Error("Failed to store refresh token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	respondWithTokens(c, user, familyID, refreshToken)
+}
+
+func RefreshToken(c *gin.Context) {
+	var input RefreshInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token is required"})
+		return
+	}
+
+	user, familyID, refreshToken, err := models.RotateRefreshToken(input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidRefreshToken), errors.Is(err, models.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		case errors.Is(err, models.ErrUserDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"error": "User is disabled"})
+		default:
+			logrus.Error("Failed to rotate refresh token:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not refresh token"})
+		}
+		return
+	}
+
+	respondWithTokens(c, user, familyID, refreshToken)
+}
+
+// Logout revokes the refresh token family of the session and the access token itself
+func Logout(c *gin.Context) {
+	if familyID := c.GetString("token_family"); familyID != "" {
+		if err := models.RevokeTokenFamily(familyID); err != nil {
+			logrus.Error("Failed to revoke token family:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+			return
+		}
+	}
+
+	expiresAt := c.GetTime("token_expires_at")
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(utils.AccessTokenTTL)
+	}
+	if err := models.RevokeToken(c.GetString("jti"), expiresAt); err != nil {
+		logrus.Error("Failed to revoke token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func respondWithTokens(c *gin.Context, user *models.User, familyID, refreshToken string) {
+	token, err := utils.GenerateJWT(user.ID, user.Username, string(user.Role), familyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
+	})
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"myapp/models"
+	"myapp/utils"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// Mock GenerateJWT
+func MockGenerateJWT(userID int, user, role, familyID string) (string, error) {
+	if user == "validUser" {
+		return "mockedToken", nil
+	}
+	return "", fmt.Errorf("mocked error")
+}
+
+func TestLogin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	expectedQuery := "SELECT * FROM `users` WHERE username = ? ORDER BY `users`.`id` LIMIT ?"
+	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
+		WithArgs("validUser", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role", "disabled"}).
+			AddRow(1, "validUser", string(hash), "clerk", false))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `refresh_tokens`").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1, sqlmock.AnyArg(), nil, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Replace GenerateJWT to Mock GenerateJWT
+	utils.GenerateJWT = MockGenerateJWT
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/login", Login)
+
+	body := `{"username": "validUser", "password": "secret-password"}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, `"token":"mockedToken"`, w.Body.String())
+	assert.Regexp(t, `"refresh_token":"[A-Za-z0-9_-]{43}"`, w.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestLoginWithWrongPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	expectedQuery := "SELECT * FROM `users` WHERE username = ? ORDER BY `users`.`id` LIMIT ?"
+	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
+		WithArgs("validUser", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role", "disabled"}).
+			AddRow(1, "validUser", string(hash), "clerk", false))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/login", Login)
+
+	body := `{"username": "validUser", "password": "wrong-password"}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error": "Invalid username or password"}`, w.Body.String())
+}
+
+func TestLoginWithoutCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/login", Login)
+
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(`{"username": "validUser"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error": "Username and password are required"}`, w.Body.String())
+}
+
+func TestRefreshTokenWithUnknownToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `refresh_tokens` WHERE token_hash = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/token/refresh", RefreshToken)
+
+	req, _ := http.NewRequest("POST", "/token/refresh", bytes.NewBufferString(`{"refresh_token": "unknown"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error": "Invalid refresh token"}`, w.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type BarcodeInput struct {
+	Code string `json:"code"`
+}
+
+const (
+	defaultBarcodeScale  = 2
+	maxBarcodeScale      = 10
+	defaultBarcodeHeight = 80
+	maxBarcodeHeight     = 1000
+)
+
+// AddBarcode registers another barcode of the product
+func AddBarcode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var input BarcodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	product, err := models.AddBarcode(auditContext(c), id, input.Code)
+	if err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+
+	c.Header("ETag", versionETag(product.Version))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Barcode added successfully",
+		"product": product,
+	})
+}
+
+// RemoveBarcode takes a barcode from the product
+func RemoveBarcode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := models.RemoveBarcode(auditContext(c), id, c.Param("code"))
+	if err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+
+	c.Header("ETag", versionETag(product.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Barcode removed successfully",
+		"product": product,
+	})
+}
+
+func respondBarcodeError(c *gin.Context, err error) {
+	var validationErrs models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		respondValidationErrors(c, "Invalid barcode", validationErrs)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	case errors.Is(err, models.ErrBarcodeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Barcode not found"})
+	case errors.Is(err, models.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Product was changed while the barcode was saved, retry"})
+	case models.IsDuplicateKeyError(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "Barcode already belongs to a product"})
+	default:
+		logrus.Error("Failed to save barcode:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save barcode"})
+	}
+}
+
+// LookupProduct finds the product by a scanned barcode and answers like
+// GetProductByID
+func LookupProduct(c *gin.Context) {
+	validationErrs := models.ValidationErrors{}
+	barcode := c.Query("barcode")
+	if barcode == "" {
+		validationErrs["barcode"] = "is required"
+	}
+	selection := parsePriceSelection(c, validationErrs)
+	if len(validationErrs) > 0 {
+		respondValidationErrors(c, "Invalid query parameters", validationErrs)
+		return
+	}
+
+	product, err := models.LookupBarcode(barcode)
+	if err != nil {
+		if errors.As(err, &validationErrs) {
+			respondValidationErrors(c, "Invalid query parameters", validationErrs)
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No product has the barcode"})
+			return
+		}
+		logrus.Error("Failed to look up barcode:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+	respondProduct(c, product, selection)
+}
+
+// GetBarcodeImage draws a barcode of the product as PNG or SVG for label
+// printing
+func GetBarcodeImage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	validationErrs := models.ValidationErrors{}
+	format := c.DefaultQuery("format", "png")
+	if format != "png" && format != "svg" {
+		validationErrs["format"] = "must be png or svg"
+	}
+	scale := parseIntParam(c, "scale", defaultBarcodeScale, 1, maxBarcodeScale, validationErrs)
+	height := parseIntParam(c, "height", defaultBarcodeHeight, 10, maxBarcodeHeight, validationErrs)
+	if len(validationErrs) > 0 {
+		respondValidationErrors(c, "Invalid query parameters", validationErrs)
+		return
+	}
+
+	barcode, err := models.GetProductBarcode(id, c.Param("code"))
+	if err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+	image, err := barcode.Render()
+	if err != nil {
+		logrus.Error("Failed to render barcode:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render barcode"})
+		return
+	}
+
+	c.Header("Cache-Control", "max-age=86400")
+	if format == "svg" {
+		c.Header("Content-Type", "image/svg+xml")
+		c.Status(http.StatusOK)
+		err = image.SVG(c.Writer, scale, height)
+	} else {
+		c.Header("Content-Type", "image/png")
+		c.Status(http.StatusOK)
+		err = image.PNG(c.Writer, scale, height)
+	}
+	if err != nil {
+		logrus.Error("Failed to write barcode image:", err)
+	}
+}
+
+// parseIntParam reads an optional integer query parameter within min and max
+func parseIntParam(c *gin.Context, name string, value, min, max int, errs models.ValidationErrors) int {
+	if param := c.Query(name); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < min || parsed > max {
+			errs[name] = fmt.Sprintf("must be between %d and %d", min, max)
+			return value
+		}
+		return parsed
+	}
+	return value
+}
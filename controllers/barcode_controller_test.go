@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestLookupProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE gtin = ?")).
+		WithArgs("04006381333931", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}).AddRow("04006381333931", "4006381333931", "ean13", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "APPLE", "simple", "99", "USD", 3))
+	expectDuePrices(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels`")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE product_id IN (?)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}).AddRow("04006381333931", "4006381333931", "ean13", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE gtin = ?")).
+		WithArgs("00036000291452", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products/lookup", LookupProduct)
+
+	req, _ := http.NewRequest("GET", "/products/lookup?barcode=4006381333931", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Regexp(t, `^"3-[0-9a-f]{16}"$`, resp.Header().Get("ETag"))
+	assert.Contains(t, resp.Body.String(), `"sku":"APP-001"`)
+	assert.Contains(t, resp.Body.String(), `"barcodes":[{"gtin":"04006381333931","code":"4006381333931","symbology":"ean13"}]`)
+
+	req, _ = http.NewRequest("GET", "/products/lookup?barcode=036000291452", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	req, _ = http.NewRequest("GET", "/products/lookup?barcode=4006381333932", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"barcode":"has an invalid check digit, expected 1"}}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestGetBarcodeImage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE gtin = ? AND product_id = ?")).
+		WithArgs("10012345000017", 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}).AddRow("10012345000017", "10012345000017", "gtin14", 1))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products/:id/barcodes/:code/image", GetBarcodeImage)
+
+	req, _ := http.NewRequest("GET", "/products/1/barcodes/10012345000017/image?format=svg&height=40", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "image/svg+xml", resp.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(resp.Body.String(), "<svg"))
+	assert.Contains(t, resp.Body.String(), ">10012345000017</text>")
+
+	req, _ = http.NewRequest("GET", "/products/1/barcodes/10012345000017/image?format=gif&scale=20", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"format":"must be png or svg","scale":"must be between 1 and 10"}}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
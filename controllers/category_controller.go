@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type CategoryInput struct {
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
+
+// MoveCategoryInput is the new place of a category, a null parent_id makes it
+// a root and a missing position appends it to its new siblings
+type MoveCategoryInput struct {
+	ParentID *int `json:"parent_id"`
+	Position *int `json:"position"`
+}
+
+// GetCategories returns the category tree
+func GetCategories(c *gin.Context) {
+	categories, err := models.GetCategoryTree()
+	if err != nil {
+		logrus.Error("Failed to retrieve categories:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, categories)
+}
+
+// GetCategory returns the category with its subcategories
+func GetCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	category, err := models.GetCategory(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		logrus.Error("Failed to retrieve category:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category})
+}
+
+func CreateCategory(c *gin.Context) {
+	var input CategoryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	category := models.Category{Name: input.Name, ParentID: input.ParentID}
+	id, err := models.CreateCategory(auditContext(c), &category)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			respondValidationErrors(c, "Invalid category", validationErrs)
+			return
+		}
+		logrus.Error("Failed to create category:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Category created successfully", "id": id})
+}
+
+// UpdateCategory renames the category, it is moved by MoveCategory
+func UpdateCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+	var input CategoryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	category, err := models.RenameCategory(auditContext(c), id, input.Name)
+	if err != nil {
+		respondCategoryWriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category updated successfully", "category": category})
+}
+
+// MoveCategory changes the parent or the position of the category
+func MoveCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+	var input MoveCategoryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	category, err := models.MoveCategory(auditContext(c), id, input.ParentID, input.Position)
+	if err != nil {
+		respondCategoryWriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category moved successfully", "category": category})
+}
+
+// DeleteCategory deletes a category without subcategories and products
+func DeleteCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	if err := models.DeleteCategory(auditContext(c), id); err != nil {
+		respondCategoryWriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
+
+func respondCategoryWriteError(c *gin.Context, err error) {
+	var validationErrs models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		respondValidationErrors(c, "Invalid category", validationErrs)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+	case errors.Is(err, models.ErrCategoryNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"error": "Category still has subcategories or products"})
+	default:
+		logrus.Error("Failed to update category:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+	}
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"bytes"
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestCreateProductInUnknownCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `categories` WHERE `categories`.`id` = ? ORDER BY `categories`.`id` LIMIT ? FOR SHARE")).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products", CreateProduct)
+
+	productJSON := `{"sku": "APP-001", "name": "APPLE", "price": 10, "category_id": 7}`
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(productJSON))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid product","fields":{"category_id":"unknown category"}}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestDeleteCategoryWithProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id FOR UPDATE")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name", "position"}).AddRow(1, nil, "Fruit", 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `products` WHERE category_id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.DELETE("/categories/:id", DeleteCategory)
+
+	req, _ := http.NewRequest("DELETE", "/categories/1", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.JSONEq(t, `{"error":"Category still has subcategories or products"}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,12 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondValidationErrors reports the models.ValidationErrors per field
+func respondValidationErrors(c *gin.Context, message string, err error) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "fields": err})
+}
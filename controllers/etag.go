@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionETag is the strong ETag of a resource at the version
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// representationETag is the strong ETag of a response body of a resource at
+// the version, as in "3-1f0c9a2b7d4e6a80". The body may change without a new
+// version, If-Match only looks at the version before the dash.
+func representationETag(version int, body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + strconv.Itoa(version) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// noneMatch reports whether the If-None-Match header value contains the
+// ETag, using the weak comparison of RFC 9110
+func noneMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch returns the version named by the If-Match header, 0 for "*".
+// It accepts a version ETag and a representation ETag. It responds 428 when
+// the header is missing and 412 when it doesn't name a version.
+func requireIfMatch(c *gin.Context) (int, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required"})
+		return 0, false
+	}
+	if header == "*" {
+		return 0, true
+	}
+	tag, _, _ := strings.Cut(strings.Trim(header, `"`), "-")
+	version, err := strconv.Atoi(tag)
+	if err != nil || version < 1 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		respondPreconditionFailed(c)
+		return 0, false
+	}
+	return version, true
+}
+
+const preconditionFailedMessage = "Resource has been modified, fetch it again and retry"
+
+func respondPreconditionFailed(c *gin.Context) {
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": preconditionFailedMessage})
+}
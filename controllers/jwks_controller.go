@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"myapp/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys so that other services can verify our tokens
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": utils.Keys.JWKS()})
+}
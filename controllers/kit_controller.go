@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type KitComponentsInput struct {
+	Components []models.KitComponent `json:"components"`
+}
+
+// SetKitComponents replaces the components of a kit
+func SetKitComponents(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logrus.Error("Invalid product ID:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var input KitComponentsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	product, err := models.SetKitComponents(auditContext(c), id, input.Components, version)
+	if err != nil {
+		if errors.Is(err, models.ErrNotAKit) {
+			respondValidationErrors(c, "Invalid product", models.ValidationErrors{"type": "must be kit to have components"})
+			return
+		}
+		if errors.Is(err, models.ErrKitChangedConcurrently) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another kit was changed at the same time, please retry"})
+			return
+		}
+		respondProductWriteError(c, err)
+		return
+	}
+
+	c.Header("ETag", versionETag(product.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Kit components updated successfully",
+		"product": product,
+	})
+}
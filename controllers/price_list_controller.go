@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"myapp/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type CreatePriceListInput struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+}
+
+type ListPriceInput struct {
+	Price *models.Decimal `json:"price"`
+}
+
+type ExchangeRateInput struct {
+	Rate *models.Rate `json:"rate"`
+}
+
+func GetPriceLists(c *gin.Context) {
+	lists, err := models.GetPriceLists()
+	if err != nil {
+		logrus.Error("Failed to retrieve price lists:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price lists"})
+		return
+	}
+
+	c.JSON(http.StatusOK, lists)
+}
+
+// GetPriceList returns the price list with its prices
+func GetPriceList(c *gin.Context) {
+	list, err := models.GetPriceList(c.Param("code"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Price list not found"})
+			return
+		}
+		logrus.Error("Failed to retrieve price list:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price list"})
+		return
+	}
+	items, err := models.GetPriceListItems(list.ID)
+	if err != nil {
+		logrus.Error("Failed to retrieve price list:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price list"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"price_list": list, "prices": items})
+}
+
+func CreatePriceList(c *gin.Context) {
+	var input CreatePriceListInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	list := models.PriceList{Code: input.Code, Name: input.Name, Currency: input.Currency}
+	id, err := models.CreatePriceList(auditContext(c), &list)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			respondValidationErrors(c, "Invalid price list", validationErrs)
+		case models.IsDuplicateKeyError(err):
+			c.JSON(http.StatusConflict, gin.H{"error": "Price list code already exists"})
+		default:
+			logrus.Error("Failed to create price list:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create price list"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Price list created successfully", "id": id})
+}
+
+// SetListPrice sets the price of a product in a price list
+func SetListPrice(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var input ListPriceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		if errs, ok := models.DecimalErrors(err, "price"); ok {
+			respondValidationErrors(c, "Invalid price", errs)
+			return
+		}
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if input.Price == nil {
+		respondValidationErrors(c, "Invalid price", models.ValidationErrors{"price": "is required"})
+		return
+	}
+
+	item, err := models.SetListPrice(auditContext(c), c.Param("code"), productID, *input.Price)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			respondValidationErrors(c, "Invalid price", validationErrs)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Price list or product not found"})
+		default:
+			logrus.Error("Failed to set list price:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set list price"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price set successfully", "price": item})
+}
+
+// DeleteListPrice removes the price of a product from a price list
+func DeleteListPrice(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	err = models.DeleteListPrice(auditContext(c), c.Param("code"), productID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+		return
+	case err != nil:
+		logrus.Error("Failed to delete list price:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete list price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price deleted successfully"})
+}
+
+func GetExchangeRates(c *gin.Context) {
+	rates, err := models.GetExchangeRates()
+	if err != nil {
+		logrus.Error("Failed to retrieve exchange rates:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// SetExchangeRate adds or replaces the rate of a currency pair
+func SetExchangeRate(c *gin.Context) {
+	var input ExchangeRateInput
+	if err := c.ShouldBindJSON(&input); err != nil || input.Rate == nil {
+		respondValidationErrors(c, "Invalid exchange rate", models.ValidationErrors{"rate": fmt.Sprintf("must be a positive number with at most %d decimal places", models.RatePlaces)})
+		return
+	}
+
+	from, to := strings.ToUpper(c.Param("from")), strings.ToUpper(c.Param("to"))
+	rate, err := models.SetExchangeRate(auditContext(c), from, to, *input.Rate)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			respondValidationErrors(c, "Invalid exchange rate", validationErrs)
+			return
+		}
+		logrus.Error("Failed to set exchange rate:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set exchange rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rate set successfully", "exchange_rate": rate})
+}
+
+// parsePriceSelection reads ?price_list= and ?currency= of product reads
+func parsePriceSelection(c *gin.Context, errs models.ValidationErrors) models.PriceSelection {
+	selection := models.PriceSelection{
+		PriceList: strings.TrimSpace(c.Query("price_list")),
+		Currency:  strings.ToUpper(strings.TrimSpace(c.Query("currency"))),
+	}
+	if selection.Currency != "" {
+		if _, ok := models.CurrencyPlaces(selection.Currency); !ok {
+			errs["currency"] = "must be an ISO 4217 currency code"
+		}
+	}
+	return selection
+}
+
+// attachListPrices answers the errors of models.AttachListPrices, it returns
+// false when a response was sent
+func attachListPrices(c *gin.Context, products []models.Product, selection models.PriceSelection) bool {
+	err := models.AttachListPrices(products, selection)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		respondValidationErrors(c, "Invalid query parameters", models.ValidationErrors{"price_list": "unknown price list"})
+	case errors.Is(err, models.ErrNoExchangeRate):
+		respondValidationErrors(c, "Invalid query parameters", models.ValidationErrors{"currency": err.Error()})
+	default:
+		logrus.Error("Failed to retrieve list prices:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve list prices"})
+	}
+	return false
+}
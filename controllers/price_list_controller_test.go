@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"bytes"
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestGetProductByIDInCurrency(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "APPLE", "10.0000", "USD", 3))
+	expectDuePrices(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels`")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE product_id IN (?)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `exchange_rates`")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_currency", "to_currency", "rate"}).AddRow(1, "USD", "TWD", "31.5"))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products/:id", GetProductByID)
+
+	// the version didn't change but the rate may have
+	req, _ := http.NewRequest("GET", "/products/1?currency=twd", nil)
+	req.Header.Set("If-None-Match", `"3"`)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"price":10,"currency":"USD"`)
+	assert.Contains(t, resp.Body.String(), `"list_price":{"price_list":"default","price":315,"currency":"TWD"}`)
+
+	req, _ = http.NewRequest("GET", "/products/1?currency=taiwan", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"currency":"must be an ISO 4217 currency code"}}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestSetExchangeRateWithInvalidInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.PUT("/exchange-rates/:from/:to", SetExchangeRate)
+
+	req, _ := http.NewRequest("PUT", "/exchange-rates/usd/usd", bytes.NewBufferString(`{"rate": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid exchange rate","fields":{"to_currency":"must differ from from_currency"}}`, resp.Body.String())
+
+	req, _ = http.NewRequest("PUT", "/exchange-rates/usd/twd", bytes.NewBufferString(`{"rate": -31.5}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid exchange rate","fields":{"rate":"must be a positive number with at most 8 decimal places"}}`, resp.Body.String())
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// upper limit of the size of a batch request
+const maxBatchSize = 4 << 20
+
+type batchRequest struct {
+	Atomic     *bool                   `json:"atomic"` // default true
+	Operations []models.BatchOperation `json:"operations"`
+}
+
+type batchItemResult struct {
+	Index   int                     `json:"index"`
+	Status  int                     `json:"status"`
+	Product *models.Product         `json:"product,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+	Fields  models.ValidationErrors `json:"fields,omitempty"`
+}
+
+// BatchProducts runs create, update, patch and delete operations in one
+// request and reports the status of every operation
+func BatchProducts(c *gin.Context) {
+	var req batchRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchSize)
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Batch is too large"})
+			return
+		}
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if len(req.Operations) == 0 {
+		respondValidationErrors(c, "Invalid batch", models.ValidationErrors{"operations": "is required"})
+		return
+	}
+	if len(req.Operations) > models.MaxBatchOperations {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Batch has more than " + strconv.Itoa(models.MaxBatchOperations) + " operations"})
+		return
+	}
+	// deleting needs the permission of DELETE /products/:id
+	for _, op := range req.Operations {
+		if op.Op == models.BatchDelete && !mayDeleteProducts(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied", "permission": models.PermProductsDelete})
+			return
+		}
+	}
+	atomic := req.Atomic == nil || *req.Atomic
+
+	results, committed, err := models.RunProductBatch(auditContext(c), req.Operations, atomic)
+	if err != nil {
+		logrus.Error("Failed to run batch:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run batch"})
+		return
+	}
+
+	// a failed atomic batch answers with the status of the operation which
+	// failed it
+	failedStatus := http.StatusOK
+	items := make([]batchItemResult, len(results))
+	for i, result := range results {
+		items[i] = batchItemResult{Index: i, Product: result.Product}
+		switch {
+		case result.Err == nil:
+			items[i].Status = http.StatusOK
+			switch req.Operations[i].Op {
+			case models.BatchCreate:
+				items[i].Status = http.StatusCreated
+			case models.BatchDelete:
+				items[i].Status = http.StatusNoContent
+			}
+		case errors.Is(result.Err, models.ErrBatchNotApplied):
+			items[i].Status, items[i].Error = http.StatusFailedDependency, "Not applied because another operation failed"
+		default:
+			status, body := productWriteError(result.Err)
+			if status == http.StatusInternalServerError {
+				logrus.Error("Failed to run batch operation:", result.Err)
+			}
+			items[i].Status, items[i].Error = status, body["error"].(string)
+			items[i].Fields, _ = body["fields"].(models.ValidationErrors)
+			if failedStatus == http.StatusOK {
+				failedStatus = status
+			}
+		}
+	}
+
+	if !committed {
+		c.JSON(failedStatus, gin.H{"error": "Batch failed, no operation was applied", "atomic": atomic, "committed": false, "results": items})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"atomic": atomic, "committed": true, "results": items})
+}
+
+// mayDeleteProducts checks products:delete like RequirePermission does for
+// DELETE /products/:id
+func mayDeleteProducts(c *gin.Context) bool {
+	var apiKeyScopes *models.StringList
+	if scopes, ok := c.Get("scopes"); ok {
+		list := models.StringList(scopes.([]string))
+		apiKeyScopes = &list
+	}
+	return models.Permits(models.Role(c.GetString("role")), apiKeyScopes, models.PermProductsDelete)
+}
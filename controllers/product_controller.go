@@ -1,37 +1,183 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"myapp/models"
 	"myapp/utils"
 	"net/http"
+	"strings"
 
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
-func Login(c *gin.Context) {
-	token, err := utils.GenerateJWT(c.Param("user"))
+func HomeHandler(c *gin.Context) {
+	c.String(http.StatusOK, "Welcome to the Product API")
+}
+
+const (
+	defaultProductPageSize = 50
+	maxProductPageSize     = 200
+)
+
+// parseProductQuery reads the paging, filter and sort parameters and collects
+// every malformed parameter
+func parseProductQuery(c *gin.Context) (models.ProductQuery, models.ValidationErrors) {
+	q := models.ProductQuery{
+		Limit:        defaultProductPageSize,
+		Cursor:       c.Query("cursor"),
+		NameContains: strings.TrimSpace(c.Query("name")),
+	}
+	errs := models.ValidationErrors{}
+
+	if param := c.Query("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > maxProductPageSize {
+			errs["limit"] = fmt.Sprintf("must be between 1 and %d", maxProductPageSize)
+		}
+		q.Limit = limit
+	}
+	if param := c.Query("offset"); param != "" {
+		offset, err := strconv.Atoi(param)
+		if err != nil || offset < 0 {
+			errs["offset"] = "must be a non-negative integer"
+		}
+		q.Offset = offset
+	}
+	if q.Cursor != "" && q.Offset > 0 {
+		errs["cursor"] = "can't be combined with offset"
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value **models.Decimal
+	}{{"min_price", &q.MinPrice}, {"max_price", &q.MaxPrice}} {
+		param := c.Query(bound.name)
+		if param == "" {
+			continue
+		}
+		price, err := models.ParseDecimal(param)
+		if err != nil || price.Sign() < 0 {
+			errs[bound.name] = "must be a non-negative number"
+			continue
+		}
+		*bound.value = &price
+	}
+	if q.MinPrice != nil && q.MaxPrice != nil && q.MinPrice.Cmp(*q.MaxPrice) > 0 {
+		errs["max_price"] = "must not be less than min_price"
+	}
+
+	if param := c.Query("status"); param != "" {
+		for _, status := range strings.Split(param, ",") {
+			status := models.ProductStatus(strings.TrimSpace(status))
+			if !status.Valid() {
+				errs["status"] = "must be one of draft, active, discontinued"
+				break
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+
+	if param := c.Query("category"); param != "" {
+		category, err := strconv.Atoi(param)
+		if err != nil || category < 1 {
+			errs["category"] = "must be a category id"
+		}
+		q.CategoryID = &category
+	}
+	if param := c.Query("include_subcategories"); param != "" {
+		include, err := strconv.ParseBool(param)
+		if err != nil {
+			errs["include_subcategories"] = "must be true or false"
+		}
+		q.IncludeSubcategories = include
+	}
+
+	if param := c.Query("parent"); param != "" {
+		parent, err := strconv.Atoi(param)
+		if err != nil || parent < 1 {
+			errs["parent"] = "must be a product id"
+		}
+		q.ParentID = &parent
+	}
+	if param := c.Query("variants"); param != "" {
+		variants, err := strconv.ParseBool(param)
+		if err != nil {
+			errs["variants"] = "must be true or false"
+		}
+		q.ExcludeVariants = !variants
+	}
+	// attribute=color:red, repeated for several attributes
+	for _, param := range c.QueryArray("attribute") {
+		code, value, ok := strings.Cut(param, ":")
+		if !ok || !models.ValidAttributeCode(code) {
+			errs["attribute"] = "must be code:value"
+			break
+		}
+		q.Attributes = append(q.Attributes, models.AttributeFilter{Code: code, Value: value})
+	}
+
+	if param := c.Query("include_deleted"); param != "" {
+		includeDeleted, err := strconv.ParseBool(param)
+		if err != nil {
+			errs["include_deleted"] = "must be true or false"
+		}
+		q.IncludeDeleted = includeDeleted
+	}
+
+	sort, err := models.ParseSort(c.Query("sort"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
-		return
+		errs["sort"] = err.Error()
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token})
-}
+	q.Sort = sort
 
-func HomeHandler(c *gin.Context) {
-	c.String(http.StatusOK, "Welcome to the Product API")
+	if len(errs) > 0 {
+		return q, errs
+	}
+	return q, nil
 }
 
 func GetAllProducts(c *gin.Context) {
-	products, err := models.GetAllProducts()
+	query, validationErrs := parseProductQuery(c)
+	if validationErrs == nil {
+		validationErrs = models.ValidationErrors{}
+	}
+	selection := parsePriceSelection(c, validationErrs)
+	if len(validationErrs) > 0 {
+		respondValidationErrors(c, "Invalid query parameters", validationErrs)
+		return
+	}
+
+	page, err := models.ListProducts(query)
 	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			respondValidationErrors(c, "Invalid query parameters", models.ValidationErrors{"cursor": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrUnknownCategory) {
+			respondValidationErrors(c, "Invalid query parameters", models.ValidationErrors{"category": err.Error()})
+			return
+		}
+		logrus.Error("Failed to retrieve products:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		return
+	}
+	if err := models.AttachStock(page.Data); err != nil {
+		logrus.Error("Failed to retrieve stock:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
 		return
 	}
+	if !attachListPrices(c, page.Data, selection) {
+		return
+	}
 
-	c.JSON(http.StatusOK, products)
+	c.JSON(http.StatusOK, page)
 }
 
 func GetProductByID(c *gin.Context) {
@@ -40,33 +186,103 @@ func GetProductByID(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
 		return
 	}
+	validationErrs := models.ValidationErrors{}
+	selection := parsePriceSelection(c, validationErrs)
+	if len(validationErrs) > 0 {
+		respondValidationErrors(c, "Invalid query parameters", validationErrs)
+		return
+	}
 
 	product, err := models.GetProductByID(int(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
+	respondProduct(c, product, selection)
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"product": product,
-	})
+// respondProduct answers with the product, its stock, variants, components,
+// barcodes and list prices. Those change without a new version, so the ETag
+// covers the body and If-None-Match gets 304 only while the body is the same.
+func respondProduct(c *gin.Context, product *models.Product, selection models.PriceSelection) {
+	products := []models.Product{*product}
+	if err := models.AttachStock(products); err != nil {
+		logrus.Error("Failed to retrieve stock:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+	if err := models.AttachVariants(products); err != nil {
+		logrus.Error("Failed to retrieve variants:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+	if err := models.AttachComponents(products); err != nil {
+		logrus.Error("Failed to retrieve kit components:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+	if err := models.AttachBarcodes(products); err != nil {
+		logrus.Error("Failed to retrieve barcodes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+	if !attachListPrices(c, products, selection) {
+		return
+	}
+	if len(products[0].Variants) > 0 && !attachListPrices(c, products[0].Variants, selection) {
+		return
+	}
+	product = &products[0]
+
+	body, err := json.Marshal(gin.H{"product": product})
+	if err != nil {
+		logrus.Error("Failed to encode product:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+	etag := representationETag(product.Version, body)
+	c.Header("ETag", etag)
+	if header := c.GetHeader("If-None-Match"); header != "" && noneMatch(header, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
 }
 
 func CreateProduct(c *gin.Context) {
 	var product models.Product
 	if err := c.ShouldBindJSON(&product); err != nil {
+		if errs, ok := models.DecimalErrors(err, "price"); ok {
+			respondValidationErrors(c, "Invalid product", errs)
+			return
+		}
 		logrus.Error("Invalid input:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
 
-	if product.Name == "" || product.Price <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and Price are required"})
+	product.Normalize()
+	if err := product.Validate(); err != nil {
+		respondValidationErrors(c, "Invalid product", err)
 		return
 	}
 
-	id, err := models.CreateProduct(&product)
+	id, err := models.CreateProduct(auditContext(c), &product)
 	if err != nil {
+		var validationErrs models.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			respondValidationErrors(c, "Invalid product", validationErrs)
+			return
+		}
+		var trashedErr *models.TrashedSKUError
+		if errors.As(err, &trashedErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": trashedErr.Error(), "product_id": trashedErr.ProductID})
+			return
+		}
+		if models.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+			return
+		}
 		logrus.Error("Failed to create product:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
@@ -83,18 +299,96 @@ func DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	rowsAffected, err := models.DeleteProduct(int(id))
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	rowsAffected, err := models.DeleteProduct(auditContext(c), id, version)
+	if errors.Is(err, models.ErrVersionConflict) {
+		respondPreconditionFailed(c)
+		return
+	}
 	if err != nil {
 		logrus.Error("Failed to delete product:", err, "delete:", rowsAffected)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Product deleted successfully",
 	})
 }
 
+// RestoreProduct takes a deleted product out of the trash
+func RestoreProduct(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logrus.Error("Invalid product ID:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := models.RestoreProduct(auditContext(c), id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	case errors.Is(err, models.ErrProductNotDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Product is not deleted"})
+		return
+	case errors.Is(err, models.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Product was changed while it was restored, retry"})
+		return
+	case err != nil:
+		logrus.Error("Failed to restore product:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore product"})
+		return
+	}
+
+	c.Header("ETag", versionETag(product.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Product restored successfully",
+		"product": product,
+	})
+}
+
+// PurgeProduct deletes a product in the trash for good
+func PurgeProduct(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logrus.Error("Invalid product ID:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	err = models.PurgeProduct(auditContext(c), id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	case errors.Is(err, models.ErrProductNotDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only deleted products can be purged"})
+		return
+	case errors.Is(err, models.ErrProductReferenced):
+		c.JSON(http.StatusConflict, gin.H{"error": "Product has stock movements, reservations, variants or is part of a kit and can't be purged"})
+		return
+	case err != nil:
+		logrus.Error("Failed to purge product:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Product purged successfully",
+	})
+}
+
+// UpdateProduct replaces the product with the request body
 func UpdateProduct(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -103,20 +397,101 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
 	var input models.Product
 	if err := c.ShouldBindJSON(&input); err != nil {
+		if errs, ok := models.DecimalErrors(err, "price"); ok {
+			respondValidationErrors(c, "Invalid product", errs)
+			return
+		}
 		logrus.Error("Invalid input:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := models.UpdateProduct(uint(id), &input); err != nil {
-		logrus.Error("Failed to update product:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+	product, err := models.UpdateProduct(auditContext(c), uint(id), &input, version)
+	if err != nil {
+		respondProductWriteError(c, err)
 		return
 	}
 
+	c.Header("ETag", versionETag(product.Version))
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Product updated successfully",
+		"product": product,
 	})
 }
+
+// PatchProduct changes only the fields present in a JSON Merge Patch body
+func PatchProduct(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logrus.Error("Invalid product ID:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	switch c.ContentType() {
+	case "application/merge-patch+json", "application/json":
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/merge-patch+json"})
+		return
+	}
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	product, err := models.PatchProduct(auditContext(c), uint(id), patch, version)
+	if err != nil {
+		respondProductWriteError(c, err)
+		return
+	}
+
+	c.Header("ETag", versionETag(product.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Product updated successfully",
+		"product": product,
+	})
+}
+
+func respondProductWriteError(c *gin.Context, err error) {
+	status, body := productWriteError(err)
+	if status == http.StatusInternalServerError {
+		logrus.Error("Failed to update product:", err)
+	}
+	c.JSON(status, body)
+}
+
+// productWriteError maps an error of a product write to its status and body
+func productWriteError(err error) (int, gin.H) {
+	var validationErrs models.ValidationErrors
+	var trashedErr *models.TrashedSKUError
+	switch {
+	case errors.As(err, &validationErrs):
+		return http.StatusBadRequest, gin.H{"error": "Invalid product", "fields": validationErrs}
+	case errors.As(err, &trashedErr):
+		return http.StatusConflict, gin.H{"error": trashedErr.Error(), "product_id": trashedErr.ProductID}
+	case errors.Is(err, utils.ErrInvalidMergePatch):
+		return http.StatusBadRequest, gin.H{"error": "Body must be a JSON object"}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound, gin.H{"error": "Product not found"}
+	case errors.Is(err, models.ErrVersionConflict):
+		return http.StatusPreconditionFailed, gin.H{"error": preconditionFailedMessage}
+	case models.IsDuplicateKeyError(err):
+		return http.StatusConflict, gin.H{"error": "SKU already exists"}
+	default:
+		return http.StatusInternalServerError, gin.H{"error": "Failed to update product"}
+	}
+}
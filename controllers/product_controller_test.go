@@ -3,56 +3,22 @@ package controllers
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
 	"myapp/models"
-	"myapp/utils"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	mysqlDriver "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
-// Mock GenerateJWT
-func MockGenerateJWT(user string) (string, error) {
-	if user == "validUser" {
-		return "mockedToken", nil
-	}
-	return "", fmt.Errorf("mocked error")
-}
-
-func TestLogin(t *testing.T) {
-	gin.SetMode(gin.TestMode)
-	r := gin.Default()
-
-	// Replace GenerateJWT to Mock GenerateJWT
-	utils.GenerateJWT = MockGenerateJWT
-
-	r.GET("/login/:user", Login)
-
-	// test valid user
-	req, _ := http.NewRequest("GET", "/login/validUser", nil)
-	w := httptest.NewRecorder()
-	r.ServeHTTP(w, req)
-
-	assert.Equal(t, http.StatusOK, w.Code)
-	assert.JSONEq(t, `{"token": "mockedToken"}`, w.Body.String())
-
-	// test invalid user
-	req, _ = http.NewRequest("GET", "/login/invalidUser", nil)
-	w = httptest.NewRecorder()
-	r.ServeHTTP(w, req)
-
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
-	assert.JSONEq(t, `{"error": "Could not generate token"}`, w.Body.String())
-}
-
 func TestHomeHandler(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	r := gin.Default()
@@ -85,8 +51,10 @@ func TestCreateProduct(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `products`").
-		WithArgs("APPLE", 99.0).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "99", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPrice(mock, 1, "99")
+	expectAudit(mock, "create", "product", 1)
 	mock.ExpectCommit()
 
 	gin.SetMode(gin.TestMode)
@@ -94,7 +62,7 @@ func TestCreateProduct(t *testing.T) {
 
 	r.POST("/products", CreateProduct)
 
-	productJSON := `{"name": "APPLE", "price": 99.0}`
+	productJSON := `{"sku": "APP-001", "name": "APPLE", "price": 99.0}`
 	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(productJSON))
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
@@ -118,9 +86,13 @@ func TestDeleteProduct(t *testing.T) {
 	models.DB = gormDB
 
 	mock.ExpectBegin()
-	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `products` WHERE `products`.`id` = ?")).
-		WithArgs(1).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).AddRow(1, "APP-001", "APPLE", "simple", "10", "USD", 3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `deleted_at`=? WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(sqlmock.AnyArg(), 1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAudit(mock, "delete", "product", 1)
 	mock.ExpectCommit()
 
 	gin.SetMode(gin.TestMode)
@@ -132,6 +104,7 @@ func TestDeleteProduct(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create request: %v", err)
 	}
+	req.Header.Set("If-Match", "*")
 
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, req)
@@ -141,6 +114,71 @@ func TestDeleteProduct(t *testing.T) {
 	assert.JSONEq(t, expectedBody, resp.Body.String())
 }
 
+func TestDeleteProductNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(999, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.DELETE("/products/:id", DeleteProduct)
+
+	req, _ := http.NewRequest("DELETE", "/products/999", nil)
+	req.Header.Set("If-Match", "*")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.JSONEq(t, `{"error":"Product not found"}`, resp.Body.String())
+}
+
+func TestPurgeProductNotDeleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "version"}).AddRow(1, "APP-001", 2))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/:id/purge", PurgeProduct)
+
+	req, _ := http.NewRequest("POST", "/products/1/purge", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.JSONEq(t, `{"error":"Only deleted products can be purged"}`, resp.Body.String())
+}
+
 func TestUpdateProduct(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
@@ -154,17 +192,19 @@ func TestUpdateProduct(t *testing.T) {
 
 	models.DB = gormDB
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
+	mock.ExpectBegin()
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 22.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", 22.0))
 
-	expectedUpdate := "UPDATE `products` SET `name`=?,`price`=? WHERE `id` = ?"
-	mock.ExpectBegin()
+	expectedUpdate := "UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?"
 	mock.ExpectExec(regexp.QuoteMeta(expectedUpdate)).
-		WithArgs("APPLE", 100.0, 1).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "100", "USD", nil, nil, "", "", 1, sqlmock.AnyArg(), 0, 1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPrice(mock, 1, "100")
+	expectAudit(mock, "update", "product", 1)
 	mock.ExpectCommit()
 
 	gin.SetMode(gin.TestMode)
@@ -172,8 +212,9 @@ func TestUpdateProduct(t *testing.T) {
 
 	r.PUT("/products/:id", UpdateProduct)
 
-	productJSON := `{"name": "APPLE", "price": 100.0}`
+	productJSON := `{"sku": "APP-001", "name": "APPLE", "price": 100.0}`
 	req, err := http.NewRequest("PUT", "/products/1", bytes.NewBufferString(productJSON))
+	req.Header.Set("If-Match", "*")
 	req.Header.Set("Content-Type", "application/json")
 	assert.NoError(t, err)
 
@@ -181,8 +222,8 @@ func TestUpdateProduct(t *testing.T) {
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
-	expectedBody := `{"message":"Product updated successfully"}`
-	assert.JSONEq(t, expectedBody, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), `"message":"Product updated successfully"`)
+	assert.Contains(t, resp.Body.String(), `"price":100`)
 }
 
 func TestGetAllProducts(t *testing.T) {
@@ -196,10 +237,18 @@ func TestGetAllProducts(t *testing.T) {
 	}), &gorm.Config{})
 	assert.NoError(t, err)
 
-	mock.ExpectQuery(`^SELECT \* FROM ` + "`products`").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 99.0).
-			AddRow(2, "BANANA", 50.0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `products`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`deleted_at` IS NULL ORDER BY id LIMIT ?")).
+		WithArgs(51).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", "99.0000", "USD").
+			AddRow(2, "BAN-002", "BANANA", "", "pcs", "active", "simple", "0.4500", "EUR"))
+	expectDuePrices(mock, 1, 2)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels` WHERE product_id IN (?,?) GROUP BY `product_id`")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}).
+			AddRow(1, 10, 4))
 
 	models.DB = gormDB
 
@@ -213,7 +262,22 @@ func TestGetAllProducts(t *testing.T) {
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
-	expectedBody := `[{"id":1,"name":"APPLE","price":99.0},{"id":2,"name":"BANANA","price":50.00}]`
+	expectedBody := `{"data":[{"id":1,"sku":"APP-001","name":"APPLE","description":"","unit":"pcs","status":"active","type":"simple","price":99,"currency":"USD","category_id":null,"parent_id":null,"created_at":"0001-01-01T00:00:00Z","version":0,"updated_at":"0001-01-01T00:00:00Z","deleted_at":null,"stock":{"on_hand":10,"reserved":4,"available":6}},{"id":2,"sku":"BAN-002","name":"BANANA","description":"","unit":"pcs","status":"active","type":"simple","price":0.45,"currency":"EUR","category_id":null,"parent_id":null,"created_at":"0001-01-01T00:00:00Z","version":0,"updated_at":"0001-01-01T00:00:00Z","deleted_at":null,"stock":{"on_hand":0,"reserved":0,"available":0}}],"total":2,"limit":50,"offset":0,"next_cursor":null}`
+	assert.JSONEq(t, expectedBody, resp.Body.String())
+}
+
+func TestGetAllProductsWithInvalidQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products", GetAllProducts)
+
+	req, _ := http.NewRequest("GET", "/products?limit=0&min_price=abc&status=sold&sort=-password&cursor=x&offset=5&include_deleted=yes", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	expectedBody := `{"error":"Invalid query parameters","fields":{"limit":"must be between 1 and 200","min_price":"must be a non-negative number","status":"must be one of draft, active, discontinued","sort":"unknown sort field \"password\"","cursor":"can't be combined with offset","include_deleted":"must be true or false"}}`
 	assert.JSONEq(t, expectedBody, resp.Body.String())
 }
 
@@ -228,11 +292,20 @@ func TestGetProductByID(t *testing.T) {
 	}), &gorm.Config{})
 	assert.NoError(t, err)
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 99.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", "99", "USD", 3))
+	expectDuePrices(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels` WHERE product_id IN (?) GROUP BY `product_id`")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}).
+			AddRow(1, 10, 4))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE product_id IN (?) ORDER BY gtin")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}).
+			AddRow("04006381333931", "4006381333931", "ean13", 1))
 
 	models.DB = gormDB
 
@@ -246,7 +319,8 @@ func TestGetProductByID(t *testing.T) {
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
-	expectedBody := `{"product":{"id":1,"name":"APPLE","price":99}}`
+	assert.Regexp(t, `^"3-[0-9a-f]{16}"$`, resp.Header().Get("ETag"))
+	expectedBody := `{"product":{"id":1,"sku":"APP-001","name":"APPLE","description":"","unit":"pcs","status":"active","type":"simple","price":99,"currency":"USD","category_id":null,"parent_id":null,"version":3,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","deleted_at":null,"stock":{"on_hand":10,"reserved":4,"available":6},"barcodes":[{"gtin":"04006381333931","code":"4006381333931","symbology":"ean13"}]}}`
 	assert.JSONEq(t, expectedBody, resp.Body.String())
 }
 
@@ -266,7 +340,7 @@ func TestCreateProductWithInvalidData(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `products`").
-		WithArgs("APPLE", 10.0).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "10", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
 		WillReturnError(errors.New("Validation error"))
 	mock.ExpectRollback()
 
@@ -282,7 +356,7 @@ func TestCreateProductWithInvalidData(t *testing.T) {
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusBadRequest, resp.Code)
-	expectedBody := `{"error":"Invalid input"}`
+	expectedBody := `{"error":"Invalid product","fields":{"price":"must be a number"}}`
 	assert.JSONEq(t, expectedBody, resp.Body.String())
 }
 
@@ -301,7 +375,7 @@ func TestCreateProductWithLackData(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `products`").
-		WithArgs("", 10.0). // Invalid data
+		WithArgs("APP-001", "", "", "pcs", "active", "simple", "10", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil). // Invalid data
 		WillReturnError(errors.New("Validation error"))
 	mock.ExpectRollback()
 
@@ -310,14 +384,14 @@ func TestCreateProductWithLackData(t *testing.T) {
 
 	r.POST("/products", CreateProduct)
 
-	productJSON := `{"name": "", "price": 10.0}`
+	productJSON := `{"sku": "APP-001", "name": "", "price": 10.0}`
 	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(productJSON))
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusBadRequest, resp.Code)
-	expectedBody := `{"error":"Name and Price are required"}`
+	expectedBody := `{"error":"Invalid product","fields":{"name":"is required"}}`
 	assert.JSONEq(t, expectedBody, resp.Body.String())
 }
 
@@ -336,7 +410,7 @@ func TestCreateProductFailure(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `products`").
-		WithArgs("APPLE", 99.0).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "99", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
 		WillReturnError(errors.New("Failed to insert into database"))
 	mock.ExpectRollback()
 
@@ -344,7 +418,7 @@ func TestCreateProductFailure(t *testing.T) {
 	r := gin.Default()
 	r.POST("/products", CreateProduct)
 
-	validProductJSON := `{"name": "APPLE", "price": 99.0}`
+	validProductJSON := `{"sku": "APP-001", "name": "APPLE", "price": 99.0}`
 	req, err := http.NewRequest("POST", "/products", bytes.NewBufferString(validProductJSON))
 	if err != nil {
 		t.Fatalf("failed to create request: %v", err)
@@ -372,8 +446,8 @@ func TestDeleteProductFailure(t *testing.T) {
 
 	models.DB = gormDB
 	// Mock Error Delete Product
-	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `products` WHERE `products`.`id` = ?")).
-		WithArgs(999).
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `deleted_at`=? WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(sqlmock.AnyArg(), 999).
 		WillReturnError(errors.New("Failed to delete product"))
 	mock.ExpectRollback()
 
@@ -384,6 +458,7 @@ func TestDeleteProductFailure(t *testing.T) {
 
 	req, err := http.NewRequest("DELETE", "/products/99", nil)
 	assert.NoError(t, err)
+	req.Header.Set("If-Match", "*")
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, req)
 
@@ -404,15 +479,15 @@ func TestUpdateProductFailure(t *testing.T) {
 
 	models.DB = gormDB
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
 		WithArgs(1, 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 50.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", 50.0))
 
 	// Mock Error Update Product
 	mock.ExpectBegin()
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `name`=?,`price`=? WHERE `id` = ?")).
-		WithArgs("APPLE_UPDATED", 200.0, 1).
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?")).
+		WithArgs("APP-001", "APPLE_UPDATED", "", "pcs", "active", "simple", "200", "USD", nil, nil, "", "", 1, sqlmock.AnyArg(), 0, 1).
 		WillReturnError(errors.New("update failed"))
 	mock.ExpectRollback()
 
@@ -421,8 +496,9 @@ func TestUpdateProductFailure(t *testing.T) {
 
 	r.PUT("/products/:id", UpdateProduct)
 
-	productJSON := `{"name": "APPLE_UPDATED", "price": 200.0}`
+	productJSON := `{"sku": "APP-001", "name": "APPLE_UPDATED", "price": 200.0}`
 	req, _ := http.NewRequest("PUT", "/products/1", bytes.NewBufferString(productJSON))
+	req.Header.Set("If-Match", "*")
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, req)
@@ -477,7 +553,7 @@ func TestGetProductByIDNotFound(t *testing.T) {
 
 	models.DB = gormDB
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
 		WillReturnError(errors.New("Product not found"))
@@ -496,3 +572,618 @@ func TestGetProductByIDNotFound(t *testing.T) {
 	expectedBody := `{"error":"Product not found"}`
 	assert.JSONEq(t, expectedBody, resp.Body.String())
 }
+
+func TestCreateProductWithDuplicateSKU(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `products`").
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "99", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnError(&mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry 'APP-001' for key 'idx_products_sku'"})
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `products` WHERE sku = ? AND deleted_at IS NOT NULL LIMIT ?")).
+		WithArgs("APP-001", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/products", CreateProduct)
+
+	productJSON := `{"sku": "APP-001", "name": "APPLE", "price": 99.0}`
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(productJSON))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	expectedBody := `{"error":"SKU already exists"}`
+	assert.JSONEq(t, expectedBody, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+// a product in the trash keeps its SKU
+func TestCreateProductWithTrashedSKU(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `products`").
+		WillReturnError(&mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry 'APP-001' for key 'idx_products_sku'"})
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `products` WHERE sku = ? AND deleted_at IS NOT NULL LIMIT ?")).
+		WithArgs("APP-001", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/products", CreateProduct)
+
+	productJSON := `{"sku": "APP-001", "name": "APPLE", "price": 99.0}`
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(productJSON))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	expectedBody := `{"error":"SKU belongs to product 4 in the trash, restore it with POST /products/4/restore","product_id":4}`
+	assert.JSONEq(t, expectedBody, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestCreateProductWithInvalidFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/products", CreateProduct)
+
+	productJSON := `{"sku": "APP 001", "name": "APPLE", "price": -99.0, "unit": "kg2", "status": "sold"}`
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(productJSON))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	expectedBody := `{"error":"Invalid product","fields":{
+		"sku":"may only contain letters, digits, '.', '_' and '-'",
+		"price":"must not be negative",
+		"unit":"must be 1-16 letters",
+		"status":"must be one of draft, active, discontinued"}}`
+	assert.JSONEq(t, expectedBody, resp.Body.String())
+}
+
+func TestUpdateProductNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(99, 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.PUT("/products/:id", UpdateProduct)
+
+	productJSON := `{"sku": "APP-001", "name": "APPLE", "price": 100.0}`
+	req, _ := http.NewRequest("PUT", "/products/99", bytes.NewBufferString(productJSON))
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.JSONEq(t, `{"error":"Product not found"}`, resp.Body.String())
+}
+
+func TestPatchProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price"}).
+			AddRow(1, "APP-001", "APPLE", "Red apples", "kg", "active", "simple", 50.0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?")).
+		WithArgs("APP-001", "APPLE", "Red apples", "kg", "active", "simple", "0", "USD", nil, nil, "", "", 1, sqlmock.AnyArg(), 0, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPrice(mock, 1, "0")
+	expectAudit(mock, "update", "product", 1)
+	mock.ExpectCommit()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.PATCH("/products/:id", PatchProduct)
+
+	req, _ := http.NewRequest("PATCH", "/products/1", bytes.NewBufferString(`{"price": 0}`))
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"price":0`)
+	assert.Contains(t, resp.Body.String(), `"description":"Red apples"`)
+}
+
+func TestPatchProductWithInvalidBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.PATCH("/products/:id", PatchProduct)
+
+	req, _ := http.NewRequest("PATCH", "/products/1", bytes.NewBufferString(`{"price": 0}`))
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("Content-Type", "text/plain")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+
+	req, _ = http.NewRequest("PATCH", "/products/1", bytes.NewBufferString(`{"id": 5}`))
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid product","fields":{"id":"is read-only"}}`, resp.Body.String())
+
+	req, _ = http.NewRequest("PATCH", "/products/1", bytes.NewBufferString(`["price"]`))
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Body must be a JSON object"}`, resp.Body.String())
+}
+
+// the embedded stock changes without a new version, the ETag follows it
+func TestGetProductByIDNotModified(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	expectRead := func(onHand int) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+			WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).
+				AddRow(1, "APP-001", "APPLE", "simple", "99", "USD", 3))
+		expectDuePrices(mock, 1)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels`")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}).AddRow(1, onHand, 0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE product_id IN (?) ORDER BY gtin")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}))
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products/:id", GetProductByID)
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/products/1", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	expectRead(7)
+	resp := get("")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	etag := resp.Header().Get("ETag")
+	assert.Regexp(t, `^"3-[0-9a-f]{16}"$`, etag)
+
+	expectRead(7)
+	resp = get(`"2", W/` + etag)
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	assert.Equal(t, etag, resp.Header().Get("ETag"))
+	assert.Empty(t, resp.Body.String())
+
+	// the stock changed, the version didn't
+	expectRead(5)
+	resp = get(etag)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEqual(t, etag, resp.Header().Get("ETag"))
+	assert.Regexp(t, `^"3-`, resp.Header().Get("ETag"))
+	assert.Contains(t, resp.Body.String(), `"stock":{"on_hand":5,"reserved":0,"available":5}`)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestUpdateProductRequiresIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.PUT("/products/:id", UpdateProduct)
+	r.PATCH("/products/:id", PatchProduct)
+	r.DELETE("/products/:id", DeleteProduct)
+
+	for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+		req, _ := http.NewRequest(method, "/products/1", bytes.NewBufferString(`{"price": 1}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusPreconditionRequired, resp.Code, method)
+		assert.JSONEq(t, `{"error":"If-Match header is required"}`, resp.Body.String())
+	}
+}
+
+func TestUpdateProductWithStaleVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the product is already at version 4
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "version"}).
+			AddRow(1, "APP-001", "APPLE", 99.0, 4))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.PATCH("/products/:id", PatchProduct)
+
+	req, _ := http.NewRequest("PATCH", "/products/1", bytes.NewBufferString(`{"price": 0}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"3"`)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	assert.JSONEq(t, `{"error":"Resource has been modified, fetch it again and retry"}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestUpdateProductWithCurrentVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", "99", "USD", 3))
+
+	// a concurrent update has bumped the version after the product was read
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?")).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "120", "USD", nil, nil, "", "", 4, sqlmock.AnyArg(), 3, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.PUT("/products/:id", UpdateProduct)
+
+	req, _ := http.NewRequest("PUT", "/products/1", bytes.NewBufferString(`{"sku": "APP-001", "name": "APPLE", "price": 120}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"3-f71ae3b32812a1b3"`) // the ETag of a read
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestImportProductsDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE sku IN (?)")).
+		WithArgs("APP-001").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku"}))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/import", ImportProducts)
+
+	csvFile := "sku,name,price\nAPP-001,APPLE,10\n"
+	req, _ := http.NewRequest("POST", "/products/import?dry_run=true", bytes.NewBufferString(csvFile))
+	req.Header.Set("Content-Type", "text/csv")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	expectedBody := `{"dry_run":true,"atomic":true,"committed":false,"created":1,"updated":0,"failed":0,"rows":[{"line":2,"sku":"APP-001","action":"created"}]}`
+	assert.JSONEq(t, expectedBody, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestImportProductsWithInvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/import", ImportProducts)
+
+	req, _ := http.NewRequest("POST", "/products/import", bytes.NewBufferString("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+
+	req, _ = http.NewRequest("POST", "/products/import?format=ndjson&mode=some&upsert=maybe", bytes.NewBufferString("{}"))
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"mode":"must be atomic or best_effort","upsert":"must be true or false"}}`, resp.Body.String())
+}
+
+func TestExportProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(500).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency", "tags", "attributes", "version"}).
+			AddRow(1, "APP-001", "APPLE", "red, sweet", "pcs", "active", "simple", "10.5000", "USD", "organic,summer", `{"weight":180}`, 2))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products/export", ExportProducts)
+
+	req, _ := http.NewRequest("GET", "/products/export?format=csv", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header().Get("Content-Type"))
+	expectedBody := "id,sku,name,description,unit,status,type,price,currency,category_id,tags,attributes,version,created_at,updated_at\n" +
+		"1,APP-001,APPLE,\"red, sweet\",pcs,active,simple,10.5,USD,,\"[\"\"organic\"\",\"\"summer\"\"]\",\"{\"\"weight\"\":180}\",2,0001-01-01T00:00:00Z,0001-01-01T00:00:00Z\n"
+	assert.Equal(t, expectedBody, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestBatchProductsIndependent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `products`").
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "10", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	expectPrice(mock, 5, "10")
+	expectAudit(mock, "create", "product", 5)
+	mock.ExpectCommit()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/batch", func(c *gin.Context) {
+		c.Set("role", string(models.RoleManager))
+	}, BatchProducts)
+
+	body := `{"atomic":false,"operations":[
+		{"op":"create","product":{"sku":"APP-001","name":"APPLE","price":10}},
+		{"op":"delete","id":2}
+	]}`
+	req, _ := http.NewRequest("POST", "/products/batch", bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"atomic":false,"committed":true`)
+	assert.Contains(t, resp.Body.String(), `{"index":0,"status":201,"product":{"id":5,`)
+	assert.Contains(t, resp.Body.String(), `{"index":1,"status":400,"error":"Invalid product","fields":{"version":"is required"}}`)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+// a failed atomic batch answers with the status of the failed operation
+func TestBatchProductsAtomicFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `products`").
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "10", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	expectPrice(mock, 5, "10")
+	expectAudit(mock, "create", "product", 5)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "version"}).
+			AddRow(2, "BAN-002", "BANANA", 3.0, 4))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/batch", BatchProducts)
+
+	body := `{"operations":[
+		{"op":"create","product":{"sku":"APP-001","name":"APPLE","price":10}},
+		{"op":"patch","id":2,"version":3,"patch":{"price":4}}
+	]}`
+	req, _ := http.NewRequest("POST", "/products/batch", bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"atomic":true,"committed":false`)
+	assert.Contains(t, resp.Body.String(), `{"index":0,"status":424,`)
+	assert.Contains(t, resp.Body.String(), `{"index":1,"status":412,`)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+// a caller who may write but not delete products can't delete them in a batch
+func TestBatchProductsDeleteRequiresPermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/batch", func(c *gin.Context) {
+		c.Set("role", c.GetHeader("X-Role"))
+		if scopes := c.GetHeader("X-Scopes"); scopes != "" {
+			c.Set("scopes", strings.Split(scopes, ","))
+		}
+	}, BatchProducts)
+
+	body := `{"operations":[{"op":"create","product":{"sku":"APP-001","name":"APPLE","price":10}},{"op":"delete","id":2,"version":1}]}`
+	for _, caller := range []struct{ role, scopes string }{
+		{role: "clerk"},
+		{role: "manager", scopes: "products:read,products:write"},
+	} {
+		req, _ := http.NewRequest("POST", "/products/batch", bytes.NewBufferString(body))
+		req.Header.Set("X-Role", caller.role)
+		req.Header.Set("X-Scopes", caller.scopes)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusForbidden, resp.Code, caller.role)
+		assert.JSONEq(t, `{"error":"Permission denied","permission":"products:delete"}`, resp.Body.String())
+	}
+}
+
+func TestBatchProductsWithInvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/batch", BatchProducts)
+
+	req, _ := http.NewRequest("POST", "/products/batch", bytes.NewBufferString(`{"operations":[]}`))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid batch","fields":{"operations":"is required"}}`, resp.Body.String())
+
+	defer func(limit int) { models.MaxBatchOperations = limit }(models.MaxBatchOperations)
+	models.MaxBatchOperations = 1
+	req, _ = http.NewRequest("POST", "/products/batch", bytes.NewBufferString(`{"operations":[{"op":"delete"},{"op":"delete"}]}`))
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// upper limit of the size of an import file
+const maxImportSize = 32 << 20
+
+// products loaded per query while exporting
+const exportBatchSize = 500
+
+// importFormat picks the format from the format parameter or the Content-Type
+func importFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+	switch c.ContentType() {
+	case "text/csv":
+		return models.ImportFormatCSV
+	case "application/x-ndjson", "application/jsonl":
+		return models.ImportFormatNDJSON
+	}
+	return ""
+}
+
+func ImportProducts(c *gin.Context) {
+	format := importFormat(c)
+	if format != models.ImportFormatCSV && format != models.ImportFormatNDJSON {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Send text/csv or application/x-ndjson, or set format=csv|ndjson"})
+		return
+	}
+
+	opts := models.ImportOptions{Atomic: true}
+	errs := models.ValidationErrors{}
+	for name, value := range map[string]*bool{"dry_run": &opts.DryRun, "upsert": &opts.Upsert} {
+		if param := c.Query(name); param != "" {
+			parsed, err := strconv.ParseBool(param)
+			if err != nil {
+				errs[name] = "must be true or false"
+			}
+			*value = parsed
+		}
+	}
+	switch c.DefaultQuery("mode", "atomic") {
+	case "atomic":
+	case "best_effort":
+		opts.Atomic = false
+	default:
+		errs["mode"] = "must be atomic or best_effort"
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, "Invalid query parameters", errs)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	rows, err := models.ReadImportRows(c.Request.Body, format)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file is too large"})
+		case errors.Is(err, models.ErrTooManyImportRows):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file has more than " + strconv.Itoa(models.MaxImportRows) + " rows"})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import file", "detail": err.Error()})
+		}
+		return
+	}
+
+	report, err := models.ImportProducts(auditContext(c), rows, opts)
+	if err != nil {
+		logrus.Error("Failed to import products:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import products"})
+		return
+	}
+
+	if opts.Atomic && !opts.DryRun && !report.Committed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import failed, no product was imported", "report": report})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ExportProducts streams every product as CSV or one JSON object per line
+func ExportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", models.ImportFormatCSV)
+	var write func(*models.Product) error
+	var flush func() error
+
+	switch format {
+	case models.ImportFormatCSV:
+		writer := csv.NewWriter(c.Writer)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		if err := writer.Write(models.ProductCSVColumns); err != nil {
+			return
+		}
+		write = func(p *models.Product) error {
+			category := ""
+			if p.CategoryID != nil {
+				category = strconv.Itoa(*p.CategoryID)
+			}
+			tags, attributes := "", ""
+			if len(p.Tags) > 0 {
+				encoded, err := json.Marshal(p.Tags)
+				if err != nil {
+					return err
+				}
+				tags = string(encoded)
+			}
+			if len(p.Attributes) > 0 {
+				encoded, err := json.Marshal(p.Attributes)
+				if err != nil {
+					return err
+				}
+				attributes = string(encoded)
+			}
+			return writer.Write([]string{
+				strconv.Itoa(p.ID), p.SKU, p.Name, p.Description, p.Unit, string(p.Status), string(p.Type),
+				p.Price.String(), p.Currency, category, tags, attributes, strconv.Itoa(p.Version),
+				p.CreatedAt.UTC().Format(time.RFC3339), p.UpdatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	case models.ImportFormatNDJSON:
+		encoder := json.NewEncoder(c.Writer)
+		c.Header("Content-Type", "application/x-ndjson")
+		write = func(p *models.Product) error { return encoder.Encode(p) }
+		flush = func() error { return nil }
+	default:
+		respondValidationErrors(c, "Invalid query parameters", models.ValidationErrors{"format": "must be csv or ndjson"})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="products.`+format+`"`)
+	c.Status(http.StatusOK)
+
+	count := 0
+	err := models.EachProduct(exportBatchSize, func(p *models.Product) error {
+		if err := write(p); err != nil {
+			return err
+		}
+		// push every batch to the client instead of buffering the export
+		if count++; count%exportBatchSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// the status is already sent, the client sees a truncated file
+		logrus.Error("Failed to export products:", err)
+		c.Abort()
+	}
+}
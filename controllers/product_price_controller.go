@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// GetProductPrices lists the price history and the scheduled prices of a
+// product, with ?at= only the price effective at that time
+func GetProductPrices(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var at time.Time
+	if param := c.Query("at"); param != "" {
+		if at, err = time.Parse(time.RFC3339, param); err != nil {
+			respondValidationErrors(c, "Invalid query parameters", models.ValidationErrors{"at": "must be an RFC 3339 time"})
+			return
+		}
+	}
+
+	product, err := models.GetProductByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if !at.IsZero() {
+		price, err := models.GetPriceAt(id, at)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product had no price at this time"})
+			return
+		}
+		if err != nil {
+			logrus.Error("Failed to retrieve price:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"product_id": id, "at": at, "price": price.Price, "currency": price.Currency, "effective_at": price.EffectiveAt})
+		return
+	}
+
+	prices, err := models.GetProductPrices(id)
+	if err != nil {
+		logrus.Error("Failed to retrieve prices:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve prices"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"product_id": id, "price": product.Price, "currency": product.Currency, "prices": prices})
+}
+
+// ScheduleProductPrice schedules a price which becomes effective later
+func ScheduleProductPrice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var input models.PriceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		if errs, ok := models.DecimalErrors(err, "price"); ok {
+			respondValidationErrors(c, "Invalid price", errs)
+			return
+		}
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	price, err := models.SchedulePrice(auditContext(c), id, &input)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			respondValidationErrors(c, "Invalid price", validationErrs)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		default:
+			logrus.Error("Failed to schedule price:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule price"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Price scheduled successfully", "price": price})
+}
+
+// CancelProductPrice removes a scheduled price before it becomes effective
+func CancelProductPrice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	priceID, err := strconv.Atoi(c.Param("price_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price ID"})
+		return
+	}
+
+	err = models.CancelScheduledPrice(auditContext(c), id, priceID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+		return
+	case errors.Is(err, models.ErrPriceApplied):
+		c.JSON(http.StatusConflict, gin.H{"error": "Price is already in effect and can't be cancelled"})
+		return
+	case err != nil:
+		logrus.Error("Failed to cancel price:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price cancelled successfully"})
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// expectPrice expects the price history entry of a price change in USD
+func expectPrice(mock sqlmock.Sqlmock, productID int, price string) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `product_prices`")).
+		WithArgs(productID, price, "USD", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "", models.SystemActor, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectDuePrices expects the check for scheduled prices of the products
+// which are effective but not applied yet, there are none
+func expectDuePrices(mock sqlmock.Sqlmock, productIDs ...int) {
+	args := []driver.Value{}
+	for _, id := range productIDs {
+		args = append(args, id)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT `product_id` FROM `product_prices` WHERE product_id IN (")).
+		WithArgs(append(args, sqlmock.AnyArg())...).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id"}))
+}
+
+func TestGetProductPricesAt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	at := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price"}).AddRow(1, "APP-001", "APPLE", 12.0))
+	expectDuePrices(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE product_id = ? AND effective_at <= ?")).
+		WithArgs(1, at, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at"}).
+			AddRow(2, 1, "9.5000", "USD", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products/:id/prices", GetProductPrices)
+
+	req, _ := http.NewRequest("GET", "/products/1/prices?at=2024-03-15T00:00:00Z", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"product_id":1,"at":"2024-03-15T00:00:00Z","price":9.5,"currency":"USD","effective_at":"2024-03-01T00:00:00Z"}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestScheduleProductPriceWithInvalidInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/:id/prices", ScheduleProductPrice)
+
+	req, _ := http.NewRequest("POST", "/products/1/prices", bytes.NewBufferString(`{"price": 5, "effective_at": "2020-01-01T00:00:00Z"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid price","fields":{"effective_at":"must be in the future"}}`, resp.Body.String())
+
+	req, _ = http.NewRequest("POST", "/products/1/prices", bytes.NewBufferString(`{"price": 5.00001, "effective_at": "2030-01-01T00:00:00Z"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid price","fields":{"price":"must have at most 4 decimal places"}}`, resp.Body.String())
+
+	req, _ = http.NewRequest("GET", "/products/1/prices?at=yesterday", nil)
+	r.GET("/products/:id/prices", GetProductPrices)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"at":"must be an RFC 3339 time"}}`, resp.Body.String())
+}
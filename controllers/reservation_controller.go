@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func CreateReservation(c *gin.Context) {
+	var input models.ReservationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	reservation, err := models.CreateReservation(auditContext(c), &input)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			respondValidationErrors(c, "Invalid reservation", validationErrs)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product or warehouse not found"})
+		case errors.Is(err, models.ErrInsufficientStock):
+			c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock"})
+		default:
+			logrus.Error("Failed to create reservation:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reservation"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Reservation created successfully", "reservation": reservation})
+}
+
+func GetReservations(c *gin.Context) {
+	productID, err := strconv.Atoi(c.DefaultQuery("product_id", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	reservations, err := models.GetReservations(productID, models.ReservationStatus(c.Query("status")), limit)
+	if err != nil {
+		logrus.Error("Failed to retrieve reservations:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reservations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reservations)
+}
+
+func GetReservationByID(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reservation ID"})
+		return
+	}
+
+	reservation, err := models.GetReservationByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reservation": reservation})
+}
+
+func ReleaseReservation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reservation ID"})
+		return
+	}
+
+	if err := models.ReleaseReservation(auditContext(c), id); err != nil {
+		respondReservationError(c, err, "Failed to release reservation")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reservation released successfully"})
+}
+
+func FulfillReservation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reservation ID"})
+		return
+	}
+
+	movements, err := models.FulfillReservation(auditContext(c), id)
+	if err != nil {
+		respondReservationError(c, err, "Failed to fulfill reservation")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reservation fulfilled successfully", "movements": movements})
+}
+
+func respondReservationError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
+	case errors.Is(err, models.ErrReservationNotActive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Reservation is not active"})
+	case errors.Is(err, models.ErrInsufficientStock):
+		c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock", "detail": err.Error()})
+	default:
+		logrus.Error(message+":", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"fmt"
+	"myapp/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// SearchProducts finds products by the words of q, best matches first
+func SearchProducts(c *gin.Context) {
+	validationErrs := models.ValidationErrors{}
+	q := models.ProductSearch{Query: strings.TrimSpace(c.Query("q"))}
+	switch {
+	case q.Query == "":
+		validationErrs["q"] = "is required"
+	case len(q.Query) > models.MaxSearchQueryLength:
+		validationErrs["q"] = fmt.Sprintf("must be at most %d characters", models.MaxSearchQueryLength)
+	}
+	q.Limit = parseIntParam(c, "limit", defaultSearchPageSize, 1, maxSearchPageSize, validationErrs)
+	q.Offset = parseIntParam(c, "offset", 0, 0, models.MaxSearchOffset, validationErrs)
+	if len(validationErrs) > 0 {
+		respondValidationErrors(c, "Invalid query parameters", validationErrs)
+		return
+	}
+
+	page, err := models.SearchProducts(q)
+	if err != nil {
+		logrus.Error("Failed to search products:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+	products := make([]models.Product, len(page.Results))
+	for i := range page.Results {
+		products[i] = page.Results[i].Product
+	}
+	if err := models.AttachStock(products); err != nil {
+		logrus.Error("Failed to retrieve stock:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+	for i := range products {
+		page.Results[i].Product = products[i]
+	}
+
+	c.JSON(http.StatusOK, page)
+}
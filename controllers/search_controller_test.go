@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestSearchProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`sku`,`name`,`description`,`tags`,`deleted_at` FROM `products`")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "tags", "deleted_at"}).
+			AddRow(1, "APP-001", "Apple", "A crisp red apple", "fruit", nil).
+			AddRow(2, "BAN-001", "Banana", "Sweet and yellow", "fruit", nil))
+	_, err = models.BuildSearchIndex()
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE id IN (?) AND `products`.`deleted_at` IS NULL")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "Apple", "simple", "0.5", "USD", 1))
+	expectDuePrices(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels`")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}).AddRow(1, 10, 2))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.GET("/products/search", SearchProducts)
+
+	req, _ := http.NewRequest("GET", "/products/search?q=appel", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"total":1,"next_offset":null`)
+	assert.Contains(t, resp.Body.String(), `"sku":"APP-001"`)
+	assert.Contains(t, resp.Body.String(), `"available":8`)
+	assert.Contains(t, resp.Body.String(), `"highlights":{"description":"A crisp red \u003cem\u003eapple\u003c/em\u003e","name":"\u003cem\u003eApple\u003c/em\u003e"}`)
+
+	req, _ = http.NewRequest("GET", "/products/search?q=+&limit=0&offset=-1", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"q":"is required","limit":"must be between 1 and 100","offset":"must be between 0 and 10000"}}`, resp.Body.String())
+
+	req, _ = http.NewRequest("GET", "/products/search?q="+strings.Repeat("a", models.MaxSearchQueryLength+1), nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid query parameters","fields":{"q":"must be at most 200 characters"}}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type CreateWarehouseInput struct {
+	Code          string `json:"code" binding:"required,max=32"`
+	Name          string `json:"name" binding:"required,max=255"`
+	AllowNegative bool   `json:"allow_negative"`
+}
+
+func GetAllWarehouses(c *gin.Context) {
+	warehouses, err := models.GetAllWarehouses()
+	if err != nil {
+		logrus.Error("Failed to retrieve warehouses:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve warehouses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, warehouses)
+}
+
+func CreateWarehouse(c *gin.Context) {
+	var input CreateWarehouseInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code and Name are required"})
+		return
+	}
+
+	warehouse := models.Warehouse{
+		Code:          input.Code,
+		Name:          input.Name,
+		AllowNegative: input.AllowNegative,
+	}
+	id, err := models.CreateWarehouse(auditContext(c), &warehouse)
+	if err != nil {
+		if models.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Warehouse code already exists"})
+			return
+		}
+		logrus.Error("Failed to create warehouse:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create warehouse"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Warehouse created successfully", "id": id})
+}
+
+func GetWarehouseStock(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse ID"})
+		return
+	}
+
+	if _, err := models.GetWarehouseByID(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+			return
+		}
+		logrus.Error("Failed to retrieve warehouse:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock"})
+		return
+	}
+
+	levels, err := models.GetStockByWarehouse(id)
+	if err != nil {
+		logrus.Error("Failed to retrieve stock:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"warehouse_id": id, "stock": levels})
+}
+
+func GetProductStock(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := models.GetProductByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var levels []models.StockLevel
+	if product.Type == models.ProductTypeKit {
+		// computed from the stock of the components
+		levels, err = models.GetKitStock(id)
+	} else {
+		levels, err = models.GetStockByProduct(id)
+	}
+	if err != nil {
+		logrus.Error("Failed to retrieve stock:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock"})
+		return
+	}
+
+	total := 0
+	for _, level := range levels {
+		total += level.OnHand
+	}
+	c.JSON(http.StatusOK, gin.H{"product_id": id, "on_hand": total, "stock": levels})
+}
+
+func PostStockMovement(c *gin.Context) {
+	var input models.MovementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	movements, err := models.PostMovement(auditContext(c), &input)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			respondValidationErrors(c, "Invalid stock movement", validationErrs)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product or warehouse not found"})
+		case errors.Is(err, models.ErrInsufficientStock):
+			c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock", "detail": err.Error()})
+		default:
+			logrus.Error("Failed to post stock movement:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post stock movement"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Stock movement posted successfully", "movements": movements})
+}
+
+func GetStockMovements(c *gin.Context) {
+	productID, err := strconv.Atoi(c.DefaultQuery("product_id", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	warehouseID, err := strconv.Atoi(c.DefaultQuery("warehouse_id", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	movements, err := models.GetMovements(productID, warehouseID, limit)
+	if err != nil {
+		logrus.Error("Failed to retrieve stock movements:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock movements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, movements)
+}
+
+// ReconcileStock compares the stock levels with the ledger, ?fix=true repairs them
+func ReconcileStock(c *gin.Context) {
+	fix := c.Query("fix") == "true"
+	discrepancies, err := models.ReconcileStock(auditContext(c), fix)
+	if err != nil {
+		logrus.Error("Failed to reconcile stock:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"fixed": fix, "discrepancies": discrepancies})
+}
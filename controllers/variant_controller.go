@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type GenerateVariantsInput struct {
+	Axes []models.VariantAxis `json:"axes"`
+}
+
+// GenerateVariants creates the missing variants of a product for every
+// combination of the axis values
+func GenerateVariants(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var input GenerateVariantsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logrus.Error("Invalid input:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	result, err := models.GenerateVariants(auditContext(c), id, input.Axes)
+	if err != nil {
+		var validationErrs models.ValidationErrors
+		switch {
+		case errors.As(err, &validationErrs):
+			respondValidationErrors(c, "Invalid variants", validationErrs)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		case errors.Is(err, models.ErrProductIsVariant):
+			c.JSON(http.StatusConflict, gin.H{"error": "Variants can't have variants"})
+		case errors.Is(err, models.ErrVersionConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "Product was changed while the variants were generated, retry"})
+		case models.IsDuplicateKeyError(err):
+			c.JSON(http.StatusConflict, gin.H{"error": "The SKU of a variant already exists"})
+		default:
+			logrus.Error("Failed to generate variants:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate variants"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Variants generated successfully", "created": result.Created, "existing": result.Existing})
+}
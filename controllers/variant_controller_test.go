@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"bytes"
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestGetProductWithVariants(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "unit", "status", "type", "price", "currency", "variant_axes", "version"}).
+			AddRow(1, "TEE", "T-shirt", "pcs", "active", "simple", "20", "USD", "size", 2))
+	expectDuePrices(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels` WHERE product_id IN (?) GROUP BY `product_id`")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE parent_id IN (?) AND `products`.`deleted_at` IS NULL ORDER BY id")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "sku", "name", "unit", "status", "type", "price", "currency", "attributes", "version"}).
+			AddRow(2, 1, "TEE-S", "T-shirt (S)", "pcs", "active", "simple", "20", "USD", `{"size":"S"}`, 1).
+			AddRow(3, 1, "TEE-M", "T-shirt (M)", "pcs", "active", "simple", "22", "USD", `{"size":"M"}`, 2))
+	expectDuePrices(mock, 2, 3)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved FROM `stock_levels` WHERE product_id IN (?,?) GROUP BY `product_id`")).
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "on_hand", "reserved"}).AddRow(3, 5, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE product_id IN (?)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.GET("/products/:id", GetProductByID)
+
+	// variants change without a new version of the base product
+	req, _ := http.NewRequest("GET", "/products/1", nil)
+	req.Header.Set("If-None-Match", `"2"`)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	expectedBody := `{"product":{"id":1,"sku":"TEE","name":"T-shirt","description":"","unit":"pcs","status":"active","type":"simple","price":20,"currency":"USD","category_id":null,"parent_id":null,"variant_axes":["size"],"version":2,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","deleted_at":null,"stock":{"on_hand":0,"reserved":0,"available":0},"variants":[` +
+		`{"id":2,"sku":"TEE-S","name":"T-shirt (S)","description":"","unit":"pcs","status":"active","type":"simple","price":20,"currency":"USD","category_id":null,"parent_id":1,"attributes":{"size":"S"},"version":1,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","deleted_at":null,"stock":{"on_hand":0,"reserved":0,"available":0}},` +
+		`{"id":3,"sku":"TEE-M","name":"T-shirt (M)","description":"","unit":"pcs","status":"active","type":"simple","price":22,"currency":"USD","category_id":null,"parent_id":1,"attributes":{"size":"M"},"version":2,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","deleted_at":null,"stock":{"on_hand":5,"reserved":1,"available":4}}]}}`
+	assert.JSONEq(t, expectedBody, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestGenerateVariantsOfVariant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "sku", "version"}).AddRow(2, 1, "TEE-S", 1))
+	mock.ExpectRollback()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+
+	r.POST("/products/:id/variants", GenerateVariants)
+
+	body := `{"axes":[{"attribute":"color","values":["red","blue"]}]}`
+	req, _ := http.NewRequest("POST", "/products/2/variants", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.JSONEq(t, `{"error":"Variants can't have variants"}`, resp.Body.String())
+
+	// the axes are checked before the product is read
+	body = `{"axes":[]}`
+	req, _ = http.NewRequest("POST", "/products/1/variants", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.JSONEq(t, `{"error":"Invalid variants","fields":{"axes":"is required"}}`, resp.Body.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
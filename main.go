@@ -4,7 +4,10 @@ import (
 	"log"
 	"myapp/models"
 	"myapp/router"
+	"myapp/utils"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
@@ -23,11 +26,134 @@ func main() {
 		log.Fatal("DATABASE_URL is not set")
 	}
 
+	// the currency of products without one, existing FLOAT prices are
+	// migrated to it
+	if currency := os.Getenv("DEFAULT_CURRENCY"); currency != "" {
+		if _, ok := models.CurrencyPlaces(currency); !ok {
+			log.Fatalf("Invalid DEFAULT_CURRENCY: %s", currency)
+		}
+		models.DefaultCurrency = currency
+	}
+
 	_, err := models.InitDB(dsn)
 	if err != nil {
 		logrus.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// load the JWT signing keys
+	configured, err := utils.LoadKeysFromEnv()
+	if err != nil {
+		logrus.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	if !configured {
+		logrus.Fatal("JWT_KEYS or JWT_SECRET must be set")
+	}
+
+	// create the initial account so that the first login is possible
+	if username := os.Getenv("ADMIN_USERNAME"); username != "" {
+		if err := models.EnsureUser(username, os.Getenv("ADMIN_PASSWORD"), models.RoleAdmin); err != nil {
+			logrus.Fatalf("Failed to create admin user: %v", err)
+		}
+	}
+
+	if ttl := os.Getenv("ACCESS_TOKEN_TTL"); ttl != "" {
+		if utils.AccessTokenTTL, err = time.ParseDuration(ttl); err != nil || utils.AccessTokenTTL <= 0 {
+			logrus.Fatalf("Invalid ACCESS_TOKEN_TTL: %q", ttl)
+		}
+	}
+	if ttl := os.Getenv("REFRESH_TOKEN_TTL"); ttl != "" {
+		if models.RefreshTokenTTL, err = time.ParseDuration(ttl); err != nil || models.RefreshTokenTTL <= 0 {
+			logrus.Fatalf("Invalid REFRESH_TOKEN_TTL: %q", ttl)
+		}
+	}
+
+	if ttl := os.Getenv("IDEMPOTENCY_KEY_TTL"); ttl != "" {
+		if models.IdempotencyKeyTTL, err = time.ParseDuration(ttl); err != nil || models.IdempotencyKeyTTL <= 0 {
+			logrus.Fatalf("Invalid IDEMPOTENCY_KEY_TTL: %q", ttl)
+		}
+	}
+	if timeout := os.Getenv("IDEMPOTENCY_LOCK_TIMEOUT"); timeout != "" {
+		if models.IdempotencyLockTimeout, err = time.ParseDuration(timeout); err != nil || models.IdempotencyLockTimeout <= 0 {
+			logrus.Fatalf("Invalid IDEMPOTENCY_LOCK_TIMEOUT: %q", timeout)
+		}
+	}
+
+	if limit := os.Getenv("BATCH_MAX_OPERATIONS"); limit != "" {
+		if models.MaxBatchOperations, err = strconv.Atoi(limit); err != nil || models.MaxBatchOperations < 1 {
+			logrus.Fatalf("Invalid BATCH_MAX_OPERATIONS: %q", limit)
+		}
+	}
+
+	// drop expired revocations, refresh tokens and idempotency keys once an hour
+	go func() {
+		for ; ; time.Sleep(time.Hour) {
+			if err := models.PurgeExpiredTokens(); err != nil {
+				logrus.Error("Failed to purge expired tokens:", err)
+			}
+			if err := models.PurgeExpiredIdempotencyKeys(); err != nil {
+				logrus.Error("Failed to purge expired idempotency keys:", err)
+			}
+		}
+	}()
+
+	// release the reservations whose time is up
+	sweepInterval := time.Minute
+	if interval := os.Getenv("RESERVATION_SWEEP_INTERVAL"); interval != "" {
+		if sweepInterval, err = time.ParseDuration(interval); err != nil || sweepInterval <= 0 {
+			logrus.Fatalf("Invalid RESERVATION_SWEEP_INTERVAL: %q", interval)
+		}
+	}
+	go func() {
+		for ; ; time.Sleep(sweepInterval) {
+			released, err := models.ReleaseExpiredReservations()
+			if err != nil {
+				logrus.Error("Failed to release expired reservations:", err)
+			} else if released > 0 {
+				logrus.Infof("Released %d expired reservations", released)
+			}
+		}
+	}()
+
+	// apply the scheduled prices which have become effective
+	priceInterval := time.Minute
+	if interval := os.Getenv("PRICE_SCHEDULER_INTERVAL"); interval != "" {
+		if priceInterval, err = time.ParseDuration(interval); err != nil || priceInterval <= 0 {
+			logrus.Fatalf("Invalid PRICE_SCHEDULER_INTERVAL: %q", interval)
+		}
+	}
+	go func() {
+		for ; ; time.Sleep(priceInterval) {
+			applied, err := models.ApplyScheduledPrices()
+			if err != nil {
+				logrus.Error("Failed to apply scheduled prices:", err)
+			} else if applied > 0 {
+				logrus.Infof("Applied %d scheduled prices", applied)
+			}
+		}
+	}()
+
+	// the search index lives in memory, it is rebuilt now and then to pick up
+	// the writes of other instances
+	reindexInterval := 10 * time.Minute
+	if interval := os.Getenv("SEARCH_REINDEX_INTERVAL"); interval != "" {
+		if reindexInterval, err = time.ParseDuration(interval); err != nil || reindexInterval <= 0 {
+			logrus.Fatalf("Invalid SEARCH_REINDEX_INTERVAL: %q", interval)
+		}
+	}
+	indexed, err := models.BuildSearchIndex()
+	if err != nil {
+		logrus.Fatalf("Failed to build the search index: %v", err)
+	}
+	logrus.Infof("Indexed %d products for search", indexed)
+	go func() {
+		for {
+			time.Sleep(reindexInterval)
+			if _, err := models.BuildSearchIndex(); err != nil {
+				logrus.Error("Failed to rebuild the search index:", err)
+			}
+		}
+	}()
+
 	r := router.SetupRouter()
 	r.Run()
 }
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"errors"
+	"myapp/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AuthMiddleware accepts either an api key in the X-API-Key header or a JWT
+// in the Authorization header
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			if !authenticateAPIKey(c, apiKey) {
+				return
+			}
+		} else if !authenticateJWT(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func authenticateAPIKey(c *gin.Context, raw string) bool {
+	key, user, err := models.AuthenticateAPIKey(raw)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidAPIKey) || errors.Is(err, models.ErrUserDisabled) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		} else {
+			logrus.Error("Failed to verify API key:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify API key"})
+		}
+		c.Abort()
+		return false
+	}
+
+	// Save the service account to the context, the scopes limit its role
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("role", string(user.Role))
+	c.Set("api_key_id", key.ID)
+	c.Set("scopes", []string(key.Scopes))
+	return true
+}
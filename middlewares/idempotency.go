@@ -0,0 +1,138 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"myapp/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder keeps a copy of the response body
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// renewLease extends the lease of the key while the request is processed,
+// so that a slow request isn't taken for a crashed one. The returned stop
+// waits until no renewal runs anymore.
+func renewLease(id int) (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(models.IdempotencyLockTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := models.RenewIdempotentRequest(id); err != nil {
+					logrus.Error("Failed to renew idempotency key:", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Idempotency makes a request with an Idempotency-Key header safe to retry.
+// The first request with a key is processed and its response stored, a retry
+// with the same key and body gets the stored response replayed. Requests
+// without the header are passed through.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		record, started, err := models.BeginIdempotentRequest(c.GetInt("user_id"), key, fingerprint)
+		switch {
+		case errors.Is(err, models.ErrIdempotencyKeyMismatch):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+			return
+		case errors.Is(err, models.ErrIdempotencyKeyInProgress):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			return
+		case err != nil:
+			logrus.Error("Failed to check idempotency key:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Could not check Idempotency-Key"})
+			return
+		}
+
+		if !started {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.ResponseCode, record.ContentType, record.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		stopRenewing := renewLease(record.ID)
+		// a panicking handler releases the key before Recovery answers 500
+		defer func() {
+			if r := recover(); r != nil {
+				stopRenewing()
+				if err := models.AbandonIdempotentRequest(record.ID); err != nil {
+					logrus.Error("Failed to release idempotency key:", err)
+				}
+				panic(r)
+			}
+		}()
+		c.Next()
+		stopRenewing()
+
+		// server errors are not stored so that the request can be retried
+		if recorder.Status() >= http.StatusInternalServerError {
+			if err := models.AbandonIdempotentRequest(record.ID); err != nil {
+				logrus.Error("Failed to release idempotency key:", err)
+			}
+			return
+		}
+		err = models.CompleteIdempotentRequest(record.ID, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		if err != nil {
+			logrus.Error("Failed to store idempotent response:", err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package middlewares
+
+import (
+	"bytes"
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+var farFuture = time.Now().Add(time.Hour)
+
+func setupIdempotencyRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock, *int) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+	models.DB = gormDB
+
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.POST("/products", func(c *gin.Context) {
+		c.Set("user_id", 7)
+	}, Idempotency(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"id": 42})
+	})
+	return r, mock, &calls
+}
+
+func TestIdempotencyStoresFirstResponse(t *testing.T) {
+	r, mock, calls := setupIdempotencyRouter(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WithArgs(7, "key-1", sqlmock.AnyArg(), "in_progress", 0, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `idempotency_keys` SET `content_type`=?,`response_body`=?,`response_code`=?,`status`=?,`updated_at`=? WHERE id = ? AND status = ?")).
+		WithArgs("application/json; charset=utf-8", []byte(`{"id":42}`), 201, "completed", sqlmock.AnyArg(), 1, "in_progress").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(`{"sku":"APP-001"}`))
+	req.Header.Set("Idempotency-Key", "key-1")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, 1, *calls)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestIdempotencyReplaysStoredResponse(t *testing.T) {
+	r, mock, calls := setupIdempotencyRouter(t)
+
+	// the fingerprint of POST /products with the same body
+	fingerprint := "7a77bc6f36b7e8af43ff8ea661fbe4250b627d10741d9a988ca25a167eae5ab6"
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(`{"sku":"APP-001"}`))
+	req.Header.Set("Idempotency-Key", "key-1")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WillReturnError(&mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `idempotency_keys` WHERE user_id = ? AND idempotency_key = ? ORDER BY `idempotency_keys`.`id` LIMIT ?")).
+		WithArgs(7, "key-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "idempotency_key", "fingerprint", "status", "response_code", "content_type", "response_body", "expires_at"}).
+			AddRow(1, 7, "key-1", fingerprint, "completed", 201, "application/json", []byte(`{"id":42}`), farFuture))
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, "true", resp.Header().Get("Idempotent-Replayed"))
+	assert.JSONEq(t, `{"id":42}`, resp.Body.String())
+	assert.Equal(t, 0, *calls)
+}
+
+func TestIdempotencyRejectsReusedKey(t *testing.T) {
+	r, mock, calls := setupIdempotencyRouter(t)
+
+	for _, tt := range []struct {
+		fingerprint string
+		status      string
+		code        int
+	}{
+		{"a-different-request", "completed", http.StatusUnprocessableEntity},
+		{"7a77bc6f36b7e8af43ff8ea661fbe4250b627d10741d9a988ca25a167eae5ab6", "in_progress", http.StatusConflict},
+	} {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+			WillReturnError(&mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry"})
+		mock.ExpectRollback()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `idempotency_keys`")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "idempotency_key", "fingerprint", "status", "expires_at", "locked_until"}).
+				AddRow(1, 7, "key-1", tt.fingerprint, tt.status, farFuture, farFuture))
+
+		req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(`{"sku":"APP-001"}`))
+		req.Header.Set("Idempotency-Key", "key-1")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.code, resp.Code, tt.status)
+	}
+	assert.Equal(t, 0, *calls)
+}
+
+func TestIdempotencyWithoutKey(t *testing.T) {
+	r, mock, calls := setupIdempotencyRouter(t)
+
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(`{"sku":"APP-001"}`))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, 1, *calls)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	_, mock, _ := setupIdempotencyRouter(t)
+
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.POST("/products", func(c *gin.Context) {
+		c.Set("user_id", 7)
+	}, Idempotency(), func(c *gin.Context) {
+		panic("boom")
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `idempotency_keys` WHERE id = ?")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(`{"sku":"APP-001"}`))
+	req.Header.Set("Idempotency-Key", "key-1")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -1,39 +1,65 @@
 package middlewares
 
 import (
+	"myapp/models"
 	"myapp/utils"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
 )
 
 // JWT Auth Middleware
 func JWTAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
-
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			c.Abort()
+		if !authenticateJWT(c) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Parse JWT token
-		claims := &utils.Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return utils.JwtKey, nil
-		})
+// authenticateJWT verifies the JWT in the Authorization header and stores the
+// user in the context, it aborts the request and returns false otherwise
+func authenticateJWT(c *gin.Context) bool {
+	tokenString := c.GetHeader("Authorization")
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
+	if tokenString == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
+		c.Abort()
+		return false
+	}
 
-		// Save user information to the context
-		c.Set("username", claims.Username)
+	// Parse JWT token
+	claims, err := utils.ParseJWT(tokenString)
+	if err != nil || claims.ID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return false
+	}
 
-		c.Next()
+	// Reject tokens revoked by logout, with their login or of disabled users
+	revoked, err := models.IsTokenRevoked(claims.ID, claims.FamilyID, claims.UserID)
+	if err != nil {
+		logrus.Error("Failed to check token revocation:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify token"})
+		c.Abort()
+		return false
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		c.Abort()
+		return false
+	}
+
+	// Save user information to the context
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("role", claims.Role)
+	c.Set("jti", claims.ID)
+	c.Set("token_family", claims.FamilyID)
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
 	}
+	return true
 }
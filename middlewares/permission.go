@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"myapp/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission only lets the request through when the role of the
+// authenticated user grants the permission, requests authenticated by an api
+// key also need the permission in the scopes of the key.
+// It must run after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.Permits(models.Role(c.GetString("role")), apiKeyScopes(c), permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":      "Permission denied",
+				"permission": permission,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// apiKeyScopes returns the scopes of the api key which authenticated the
+// request, nil for a user's token
+func apiKeyScopes(c *gin.Context) *models.StringList {
+	scopes, ok := c.Get("scopes")
+	if !ok {
+		return nil
+	}
+	list := models.StringList(scopes.([]string))
+	return &list
+}
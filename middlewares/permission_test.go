@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"myapp/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		role string
+		code int
+	}{
+		{"viewer", http.StatusForbidden},
+		{"clerk", http.StatusForbidden},
+		{"manager", http.StatusOK},
+		{"admin", http.StatusOK},
+		{"", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := gin.New()
+		r.DELETE("/products/:id", func(c *gin.Context) {
+			c.Set("role", tt.role)
+		}, RequirePermission(models.PermProductsDelete), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req, _ := http.NewRequest("DELETE", "/products/1", nil)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.code, resp.Code, "role %q", tt.role)
+		if tt.code == http.StatusForbidden {
+			assert.JSONEq(t, `{"error":"Permission denied","permission":"products:delete"}`, resp.Body.String())
+		}
+	}
+}
+
+func TestRequirePermissionWithAPIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		scopes []string
+		code   int
+	}{
+		{[]string{models.PermProductsRead}, http.StatusOK},
+		{[]string{models.PermProductsWrite}, http.StatusForbidden},
+		{[]string{}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := gin.New()
+		r.GET("/products", func(c *gin.Context) {
+			c.Set("role", "admin")
+			c.Set("scopes", tt.scopes)
+		}, RequirePermission(models.PermProductsRead), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req, _ := http.NewRequest("GET", "/products", nil)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.code, resp.Code, "scopes %v", tt.scopes)
+	}
+}
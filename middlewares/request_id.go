@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// ids sent by clients or proxies are kept when they look sane
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gives every request an id, taken from the X-Request-ID header or
+// generated. The id is echoed in the response and stored as "request_id" in
+// the context, the audit trail records it.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			buf := make([]byte, 16)
+			if _, err := rand.Read(buf); err != nil {
+				panic(err)
+			}
+			id = hex.EncodeToString(buf)
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", RequestID(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("request_id"))
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, "req-42", resp.Body.String())
+	assert.Equal(t, "req-42", resp.Header().Get("X-Request-ID"))
+
+	// a malformed id is replaced
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "<script>")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Len(t, resp.Body.String(), 32)
+	assert.Equal(t, resp.Body.String(), resp.Header().Get("X-Request-ID"))
+}
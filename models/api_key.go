@@ -0,0 +1,172 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidAPIKey     = errors.New("invalid api key")
+	ErrNotServiceAccount = errors.New("user is not a service account")
+	ErrScopeNotAllowed   = errors.New("scope is not granted by the role of the service account")
+)
+
+// the last used time is only written once per interval to keep reads cheap
+const apiKeyTouchInterval = time.Minute
+
+// APIKey authenticates a service account, only the sha256 of the key is stored
+type APIKey struct {
+	ID         int        `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	UserID     int        `json:"user_id" gorm:"column:user_id;index;not null"`
+	Name       string     `json:"name" gorm:"column:name;size:64;not null"`
+	Prefix     string     `json:"prefix" gorm:"column:prefix;size:16;not null"`
+	KeyHash    string     `json:"-" gorm:"column:key_hash;size:64;uniqueIndex;not null"`
+	Scopes     StringList `json:"scopes" gorm:"column:scopes;size:512;not null"`
+	ExpiresAt  *time.Time `json:"expires_at" gorm:"column:expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at" gorm:"column:revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at" gorm:"column:last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"column:created_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// CreateServiceAccount creates a user that can't login and authenticates with api keys
+func CreateServiceAccount(ctx context.Context, username string, role Role) (*User, error) {
+	user := &User{
+		Username: username,
+		Role:     role,
+		Service:  true,
+	}
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditUser, user.ID, nil, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateAPIKey issues a key for the service account and returns the raw key,
+// which is shown once and can't be recovered later. The trail only records
+// the prefix of the key.
+func CreateAPIKey(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !user.Service {
+		return nil, "", ErrNotServiceAccount
+	}
+	for _, scope := range scopes {
+		if !user.Role.HasPermission(scope) {
+			return nil, "", ErrScopeNotAllowed
+		}
+	}
+
+	prefixBytes := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	raw := "pim_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(raw),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditAPIKey, key.ID, nil, key)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return key, raw, nil
+}
+
+// AuthenticateAPIKey returns the key and its service account and records the usage
+func AuthenticateAPIKey(raw string) (*APIKey, *User, error) {
+	var key APIKey
+	if err := DB.Where("key_hash = ?", hashToken(raw)).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := GetUserByID(key.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Disabled {
+		return nil, nil, ErrUserDisabled
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		err := DB.Model(&APIKey{}).Where("id = ?", key.ID).Update("last_used_at", now).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		key.LastUsedAt = &now
+	}
+	return &key, user, nil
+}
+
+// ListAPIKeys returns the keys of the service account, or every key when userID is 0
+func ListAPIKeys(userID int) ([]APIKey, error) {
+	keys := []APIKey{}
+	query := DB.Order("id")
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes the key, it fails with gorm.ErrRecordNotFound when
+// there is no such key or it is revoked already
+func RevokeAPIKey(ctx context.Context, id int) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var key APIKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("revoked_at IS NULL").First(&key, id).Error
+		if err != nil {
+			return err
+		}
+		before := key
+		now := time.Now()
+		key.RevokedAt = &now
+		if err := tx.Model(&key).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "revoke", AuditAPIKey, id, &before, &key)
+	})
+}
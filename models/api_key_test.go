@@ -0,0 +1,137 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `api_keys` WHERE key_hash = ? ORDER BY `api_keys`.`id` LIMIT ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "scopes", "expires_at", "revoked_at", "last_used_at"}).
+			AddRow(3, 9, "scanner", "products:read,products:write", nil, nil, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users` WHERE `users`.`id` = ? ORDER BY `users`.`id` LIMIT ?")).
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "service", "disabled"}).
+			AddRow(9, "dock-scanner", "clerk", true, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `api_keys` SET `last_used_at`=? WHERE id = ?")).
+		WithArgs(sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	key, user, err := models.AuthenticateAPIKey("pim_0a1b2c3d_secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "dock-scanner", user.Username)
+	assert.Equal(t, models.StringList{"products:read", "products:write"}, key.Scopes)
+	assert.NotNil(t, key.LastUsedAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestAuthenticateExpiredAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `api_keys` WHERE key_hash = ? ORDER BY `api_keys`.`id` LIMIT ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes", "expires_at", "revoked_at"}).
+			AddRow(3, 9, "products:read", time.Now().Add(-time.Hour), nil))
+
+	_, _, err = models.AuthenticateAPIKey("pim_0a1b2c3d_secret")
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestAuthenticateRevokedAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `api_keys` WHERE key_hash = ? ORDER BY `api_keys`.`id` LIMIT ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes", "expires_at", "revoked_at"}).
+			AddRow(3, 9, "products:read", nil, time.Now().Add(-time.Minute)))
+
+	_, _, err = models.AuthenticateAPIKey("pim_0a1b2c3d_secret")
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `api_keys` WHERE revoked_at IS NULL AND `api_keys`.`id` = ? ORDER BY `api_keys`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "key_hash", "scopes"}).
+			AddRow(4, 2, "erp", "a1b2c3d4", "secret-hash", "products:read"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `api_keys` SET `revoked_at`=? WHERE `id` = ?")).
+		WithArgs(sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `audit_entries`")).
+		WithArgs("alice", "revoke", "api_key", 4, sqlmock.AnyArg(), "req-1", "10.0.0.1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, models.RevokeAPIKey(alice, 4))
+
+	// a revoked or unknown key
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `api_keys` WHERE revoked_at IS NULL")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	assert.ErrorIs(t, models.RevokeAPIKey(alice, 4), gorm.ErrRecordNotFound)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AttributeType string
+
+const (
+	AttributeText    AttributeType = "text"
+	AttributeNumber  AttributeType = "number"
+	AttributeBoolean AttributeType = "boolean"
+	AttributeEnum    AttributeType = "enum"
+)
+
+func (t AttributeType) Valid() bool {
+	switch t {
+	case AttributeText, AttributeNumber, AttributeBoolean, AttributeEnum:
+		return true
+	}
+	return false
+}
+
+var attributeCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ValidAttributeCode reports whether the code is well-formed, it may still be
+// undefined
+func ValidAttributeCode(code string) bool {
+	return len(code) <= 32 && attributeCodePattern.MatchString(code)
+}
+
+// Attribute defines a product attribute and the type of its values, enum
+// attributes only take one of their options
+type Attribute struct {
+	ID        int           `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	Code      string        `json:"code" gorm:"column:code;size:32;uniqueIndex;not null"`
+	Name      string        `json:"name" gorm:"column:name;size:255;not null"`
+	Type      AttributeType `json:"type" gorm:"column:type;size:16;not null"`
+	Options   StringList    `json:"options,omitempty" gorm:"column:options;size:2000"`
+	CreatedAt time.Time     `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time     `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// Normalize trims the name and the options
+func (a *Attribute) Normalize() {
+	a.Code = strings.TrimSpace(a.Code)
+	a.Name = strings.TrimSpace(a.Name)
+	for i := range a.Options {
+		a.Options[i] = strings.TrimSpace(a.Options[i])
+	}
+}
+
+// Validate checks the definition, it returns ValidationErrors
+func (a *Attribute) Validate() error {
+	errs := ValidationErrors{}
+	switch {
+	case a.Code == "":
+		errs["code"] = "is required"
+	case !ValidAttributeCode(a.Code):
+		errs["code"] = "must be 1-32 lowercase letters, digits or '_' starting with a letter"
+	}
+	switch {
+	case a.Name == "":
+		errs["name"] = "is required"
+	case len(a.Name) > 255:
+		errs["name"] = "must be at most 255 characters"
+	}
+	if !a.Type.Valid() {
+		errs["type"] = "must be one of text, number, boolean, enum"
+	}
+
+	if a.Type == AttributeEnum && len(a.Options) == 0 {
+		errs["options"] = "are required for enum attributes"
+	}
+	if a.Type != AttributeEnum && len(a.Options) > 0 {
+		errs["options"] = "are only allowed for enum attributes"
+	}
+	seen := map[string]bool{}
+	for _, option := range a.Options {
+		switch {
+		case option == "":
+			errs["options"] = "must not be empty"
+		case strings.Contains(option, ","):
+			errs["options"] = "must not contain ','"
+		case seen[option]:
+			errs["options"] = fmt.Sprintf("contain %q twice", option)
+		}
+		seen[option] = true
+	}
+	if len(strings.Join(a.Options, ",")) > 2000 {
+		errs["options"] = "must be at most 2000 characters together"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkValue returns why the value doesn't fit the attribute, or ""
+func (a *Attribute) checkValue(value interface{}) string {
+	switch a.Type {
+	case AttributeText:
+		s, ok := value.(string)
+		if !ok {
+			return "must be a string"
+		}
+		if len(s) > 255 {
+			return "must be at most 255 characters"
+		}
+	case AttributeNumber:
+		switch value.(type) {
+		case float64, json.Number:
+		default:
+			return "must be a number"
+		}
+	case AttributeBoolean:
+		if _, ok := value.(bool); !ok {
+			return "must be true or false"
+		}
+	case AttributeEnum:
+		if s, ok := value.(string); !ok || !a.Options.Contains(s) {
+			return "must be one of " + strings.Join(a.Options, ", ")
+		}
+	}
+	return ""
+}
+
+// AttributeValues are the attributes of a product by code, stored as JSON
+type AttributeValues map[string]interface{}
+
+func (v AttributeValues) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (v *AttributeValues) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(data), v)
+	case []byte:
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("cannot scan %T into AttributeValues", value)
+}
+
+// attributeValue formats a value for comparisons and SKUs, numbers read
+// from JSON are float64 and print without a fraction when they have none
+func attributeValue(value interface{}) string {
+	return fmt.Sprint(value)
+}
+
+func GetAttributes() ([]Attribute, error) {
+	attributes := []Attribute{}
+	if err := DB.Order("code").Find(&attributes).Error; err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// allAttributes returns every definition by code
+func allAttributes(db *gorm.DB) (map[string]*Attribute, error) {
+	var attributes []Attribute
+	if err := db.Find(&attributes).Error; err != nil {
+		return nil, err
+	}
+	byCode := make(map[string]*Attribute, len(attributes))
+	for i := range attributes {
+		byCode[attributes[i].Code] = &attributes[i]
+	}
+	return byCode, nil
+}
+
+// loadAttributes returns the definitions of the codes which exist
+func loadAttributes(db *gorm.DB, codes []string) (map[string]*Attribute, error) {
+	var attributes []Attribute
+	if err := db.Where("code IN ?", codes).Find(&attributes).Error; err != nil {
+		return nil, err
+	}
+	byCode := make(map[string]*Attribute, len(attributes))
+	for i := range attributes {
+		byCode[attributes[i].Code] = &attributes[i]
+	}
+	return byCode, nil
+}
+
+func CreateAttribute(ctx context.Context, attribute *Attribute) (int, error) {
+	attribute.Normalize()
+	if err := attribute.Validate(); err != nil {
+		return 0, err
+	}
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(attribute).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditAttribute, attribute.ID, nil, attribute)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return attribute.ID, nil
+}
+
+// UpdateAttribute changes the name and the options of the attribute, its type
+// is fixed. Options can be added but not removed since products may use them.
+func UpdateAttribute(ctx context.Context, code string, name string, options StringList) (*Attribute, error) {
+	var attribute Attribute
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", code).First(&attribute).Error; err != nil {
+			return err
+		}
+		before := attribute
+		attribute.Name, attribute.Options = name, options
+		attribute.Normalize()
+		if err := attribute.Validate(); err != nil {
+			return err
+		}
+		for _, option := range before.Options {
+			if !attribute.Options.Contains(option) {
+				return ValidationErrors{"options": fmt.Sprintf("must keep %q, products may use it", option)}
+			}
+		}
+		if err := tx.Model(&attribute).Select("name", "options", "updated_at").Updates(&attribute).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "update", AuditAttribute, attribute.ID, &before, &attribute)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &attribute, nil
+}
+
+// validateAttributes checks the values against their definitions, the
+// errors are keyed by "attributes.<code>"
+func validateAttributes(values AttributeValues, definitions map[string]*Attribute) ValidationErrors {
+	errs := ValidationErrors{}
+	for code, value := range values {
+		definition, ok := definitions[code]
+		if !ok {
+			errs["attributes."+code] = "is not a defined attribute"
+			continue
+		}
+		if reason := definition.checkValue(value); reason != "" {
+			errs["attributes."+code] = reason
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkProductAttributes validates the attributes of the product against
+// their definitions
+func checkProductAttributes(tx *gorm.DB, product *Product) error {
+	if len(product.Attributes) == 0 {
+		return nil
+	}
+	codes := make([]string, 0, len(product.Attributes))
+	for code := range product.Attributes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	definitions, err := loadAttributes(tx, codes)
+	if err != nil {
+		return err
+	}
+	if errs := validateAttributes(product.Attributes, definitions); errs != nil {
+		return errs
+	}
+	return nil
+}
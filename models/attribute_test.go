@@ -0,0 +1,100 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestAttributeValidate(t *testing.T) {
+	attribute := models.Attribute{Code: "Color", Name: "Color", Type: "enum"}
+	assert.Equal(t, models.ValidationErrors{
+		"code":    "must be 1-32 lowercase letters, digits or '_' starting with a letter",
+		"options": "are required for enum attributes",
+	}, attribute.Validate())
+
+	attribute = models.Attribute{Code: "weight", Name: "Weight", Type: "number", Options: models.StringList{"1"}}
+	assert.Equal(t, models.ValidationErrors{"options": "are only allowed for enum attributes"}, attribute.Validate())
+
+	attribute = models.Attribute{Code: "color", Name: "Color", Type: "enum", Options: models.StringList{"red", "red"}}
+	assert.Equal(t, models.ValidationErrors{"options": `contain "red" twice`}, attribute.Validate())
+}
+
+func TestUpdateAttributeKeepsOptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `attributes` WHERE code = ? ORDER BY `attributes`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs("size", 1).
+		WillReturnRows(sizeAttributeRows())
+	mock.ExpectRollback()
+
+	_, err = models.UpdateAttribute(alice, "size", "Size", models.StringList{"S", "M", "XL"})
+	assert.Equal(t, models.ValidationErrors{"options": `must keep "L", products may use it`}, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `attributes` WHERE code = ? ORDER BY `attributes`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs("size", 1).
+		WillReturnRows(sizeAttributeRows())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `attributes` SET `name`=?,`options`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs("Size", "S,M,L,XL", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "update", "attribute", 1, `{"options":{"from":["S","M","L"],"to":["S","M","L","XL"]}}`)
+	mock.ExpectCommit()
+
+	attribute, err := models.UpdateAttribute(alice, "size", "Size", models.StringList{"S", "M", "L", "XL"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.StringList{"S", "M", "L", "XL"}, attribute.Options)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestCreateProductWithUndefinedAttribute(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `attributes` WHERE code IN (?,?)")).
+		WithArgs("flavor", "size").
+		WillReturnRows(sizeAttributeRows())
+	mock.ExpectRollback()
+
+	price, _ := models.ParseDecimal("10")
+	product := models.Product{SKU: "TEE", Name: "T-shirt", Price: price,
+		Attributes: models.AttributeValues{"size": "XXL", "flavor": "mint"}}
+	_, err = models.CreateProduct(alice, &product)
+	assert.Equal(t, models.ValidationErrors{
+		"attributes.size":   "must be one of S, M, L",
+		"attributes.flavor": "is not a defined attribute",
+	}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
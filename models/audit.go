@@ -0,0 +1,183 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// the entities of the audit trail
+const (
+	AuditProduct       = "product"
+	AuditStockMovement = "stock_movement"
+	AuditReservation   = "reservation"
+	AuditWarehouse     = "warehouse"
+	AuditPriceList     = "price_list"
+	AuditExchangeRate  = "exchange_rate"
+	AuditCategory      = "category"
+	AuditAttribute     = "attribute"
+	AuditUser          = "user"
+	AuditAPIKey        = "api_key"
+)
+
+// AuditEntry records who changed an entity, the entry is written in the
+// transaction of the change
+type AuditEntry struct {
+	ID        int          `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	Actor     string       `json:"actor" gorm:"column:actor;size:64;index"`
+	Action    string       `json:"action" gorm:"column:action;size:32;not null"`
+	Entity    string       `json:"entity" gorm:"column:entity;size:32;not null;index:idx_audit_entries_entity,priority:1"`
+	EntityID  int          `json:"entity_id" gorm:"column:entity_id;not null;index:idx_audit_entries_entity,priority:2"`
+	Changes   AuditChanges `json:"changes" gorm:"column:changes;type:json"`
+	RequestID string       `json:"request_id" gorm:"column:request_id;size:64;index"`
+	ClientIP  string       `json:"client_ip" gorm:"column:client_ip;size:45"`
+	CreatedAt time.Time    `json:"created_at" gorm:"column:created_at"`
+}
+
+// Actor is the user and request behind a change
+type Actor struct {
+	Username  string
+	RequestID string
+	ClientIP  string
+}
+
+// changes without a user, like the reservation sweeper, are made by SystemActor
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor returns a context carrying the actor, the context is passed to the
+// writes which are audited
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	if actor.Username == "" {
+		actor.Username = SystemActor
+	}
+	return actor
+}
+
+// fields which change with every write and would only clutter the diff
+var auditIgnoredFields = map[string]bool{"created_at": true, "updated_at": true, "stock": true, "available": true, "list_price": true}
+
+// recordAudit writes the entry for the change of the entity, before is nil
+// for a new entity and after is nil for a removed one. The actor comes from
+// the context of tx.
+func recordAudit(tx *gorm.DB, action, entity string, entityID int, before, after interface{}) error {
+	changes, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+	actor := actorFrom(tx.Statement.Context)
+	entry := AuditEntry{
+		Actor:     actor.Username,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Changes:   changes,
+		RequestID: actor.RequestID,
+		ClientIP:  actor.ClientIP,
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&entry).Error
+}
+
+// auditDiff compares the JSON fields of two versions of an entity
+func auditDiff(before, after interface{}) (AuditChanges, error) {
+	from, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := AuditChanges{}
+	for field, value := range from {
+		if !reflect.DeepEqual(value, to[field]) {
+			changes[field] = AuditChange{From: value, To: to[field]}
+		}
+	}
+	for field, value := range to {
+		if _, ok := from[field]; !ok && value != nil {
+			changes[field] = AuditChange{To: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return changes, nil
+}
+
+func auditFields(entity interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if value := reflect.ValueOf(entity); !value.IsValid() || value.Kind() == reflect.Pointer && value.IsNil() {
+		return fields, nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	// numbers are kept as json.Number so that prices keep their exact digits
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+	for field := range auditIgnoredFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
+
+// AuditQuery filters the trail, empty fields match every entry
+type AuditQuery struct {
+	Entity   string
+	EntityID int
+	Actor    string
+	Limit    int
+	BeforeID int // the next_cursor of the previous page
+}
+
+type AuditPage struct {
+	Data       []AuditEntry `json:"data"`
+	Limit      int          `json:"limit"`
+	NextCursor *string      `json:"next_cursor"`
+}
+
+// GetAuditEntries returns a page of the trail, the newest entries first
+func GetAuditEntries(q AuditQuery) (*AuditPage, error) {
+	query := DB.Order("id DESC").Limit(q.Limit + 1)
+	if q.Entity != "" {
+		query = query.Where("entity = ?", q.Entity)
+	}
+	if q.EntityID != 0 {
+		query = query.Where("entity_id = ?", q.EntityID)
+	}
+	if q.Actor != "" {
+		query = query.Where("actor = ?", q.Actor)
+	}
+	if q.BeforeID != 0 {
+		query = query.Where("id < ?", q.BeforeID)
+	}
+
+	page := &AuditPage{Data: []AuditEntry{}, Limit: q.Limit}
+	if err := query.Find(&page.Data).Error; err != nil {
+		return nil, err
+	}
+	// one more row tells whether there is a next page
+	if len(page.Data) > q.Limit {
+		page.Data = page.Data[:q.Limit]
+		next := strconv.Itoa(page.Data[q.Limit-1].ID)
+		page.NextCursor = &next
+	}
+	return page, nil
+}
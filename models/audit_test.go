@@ -0,0 +1,58 @@
+package models_test
+
+import (
+	"context"
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// the context of the audited writes in the tests
+var alice = models.WithActor(context.Background(), models.Actor{Username: "alice", RequestID: "req-1", ClientIP: "10.0.0.1"})
+
+// expectAudit expects the audit entry alice writes for a change
+func expectAudit(mock sqlmock.Sqlmock, action, entity string, id int, changes interface{}) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `audit_entries` (`actor`,`action`,`entity`,`entity_id`,`changes`,`request_id`,`client_ip`,`created_at`) VALUES (?,?,?,?,?,?,?,?)")).
+		WithArgs("alice", action, entity, id, changes, "req-1", "10.0.0.1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestGetAuditEntries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `audit_entries` WHERE entity = ? AND entity_id = ? AND id < ? ORDER BY id DESC LIMIT ?")).
+		WithArgs("product", 1, 90, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "action", "entity", "entity_id", "changes", "request_id"}).
+			AddRow(80, "alice", "update", "product", 1, `{"price":{"from":10,"to":12}}`, "req-1").
+			AddRow(70, "bob", "update", "product", 1, nil, "req-2").
+			AddRow(60, "alice", "create", "product", 1, `{"sku":{"from":null,"to":"APP-001"}}`, "req-3"))
+
+	page, err := models.GetAuditEntries(models.AuditQuery{Entity: "product", EntityID: 1, Limit: 2, BeforeID: 90})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Data, 2) {
+		assert.Equal(t, models.AuditChanges{"price": {From: 10.0, To: 12.0}}, page.Data[0].Changes)
+		assert.Nil(t, page.Data[1].Changes)
+	}
+	if assert.NotNil(t, page.NextCursor) {
+		assert.Equal(t, "70", *page.NextCursor)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
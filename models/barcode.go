@@ -0,0 +1,234 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/utils"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrBarcodeNotFound is returned when the product doesn't have the barcode
+var ErrBarcodeNotFound = errors.New("barcode not found")
+
+// upper limit of the barcodes of a product
+const MaxProductBarcodes = 20
+
+type BarcodeSymbology string
+
+const (
+	BarcodeEAN13  BarcodeSymbology = "ean13"
+	BarcodeUPCA   BarcodeSymbology = "upca"
+	BarcodeGTIN14 BarcodeSymbology = "gtin14"
+)
+
+// Barcode is a GTIN printed on a product or its packaging. A product may have
+// several, e.g. the EAN-13 of a unit and the GTIN-14 of a carton. UPC-A,
+// EAN-13 and GTIN-14 are the same number padded with zeros to a different
+// length, the barcode is unique by its 14 digit GTIN.
+type Barcode struct {
+	GTIN      string           `json:"gtin" gorm:"column:gtin;size:14;primaryKey"`
+	Code      string           `json:"code" gorm:"column:code;size:14;not null"` // as it was given
+	Symbology BarcodeSymbology `json:"symbology" gorm:"column:symbology;size:8;not null"`
+	ProductID int              `json:"-" gorm:"column:product_id;not null;index"`
+}
+
+// parseBarcode tells the symbology from the length of the code and checks
+// its check digit, it returns why the code is invalid or ""
+func parseBarcode(code string) (*Barcode, string) {
+	code = strings.TrimSpace(code)
+	barcode := &Barcode{Code: code}
+	switch len(code) {
+	case 12:
+		barcode.Symbology = BarcodeUPCA
+	case 13:
+		barcode.Symbology = BarcodeEAN13
+	case 14:
+		barcode.Symbology = BarcodeGTIN14
+	default:
+		return nil, "must be a UPC-A, EAN-13 or GTIN-14 of 12, 13 or 14 digits"
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return nil, "must be digits only"
+		}
+	}
+	last := len(code) - 1
+	if check := utils.GTINCheckDigit(code[:last]); int(code[last]-'0') != check {
+		return nil, fmt.Sprintf("has an invalid check digit, expected %d", check)
+	}
+	barcode.GTIN = strings.Repeat("0", 14-len(code)) + code
+	return barcode, ""
+}
+
+// Render encodes the barcode for printing, EAN-13 and UPC-A as EAN-13 bars
+// and GTIN-14 as ITF-14
+func (b *Barcode) Render() (*utils.LinearBarcode, error) {
+	switch b.Symbology {
+	case BarcodeUPCA:
+		barcode, err := utils.EAN13("0" + b.Code)
+		if err != nil {
+			return nil, err
+		}
+		barcode.Text = b.Code
+		return barcode, nil
+	case BarcodeEAN13:
+		return utils.EAN13(b.Code)
+	case BarcodeGTIN14:
+		return utils.ITF14(b.Code)
+	}
+	return nil, fmt.Errorf("unknown symbology %q", b.Symbology)
+}
+
+func loadBarcodes(db *gorm.DB, productID int) ([]Barcode, error) {
+	barcodes := []Barcode{}
+	if err := db.Where("product_id = ?", productID).Order("gtin").Find(&barcodes).Error; err != nil {
+		return nil, err
+	}
+	return barcodes, nil
+}
+
+// AddBarcode gives the product another barcode and a new version. A barcode
+// belongs to one product, also while that product is in the trash.
+func AddBarcode(ctx context.Context, productID int, code string) (*Product, error) {
+	barcode, reason := parseBarcode(code)
+	if reason != "" {
+		return nil, ValidationErrors{"code": reason}
+	}
+	barcode.ProductID = productID
+
+	var product *Product
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		loaded, err := getProductVersion(tx, uint(productID), 0)
+		if err != nil {
+			return err
+		}
+		before := *loaded
+		if before.Barcodes, err = loadBarcodes(tx, productID); err != nil {
+			return err
+		}
+		if len(before.Barcodes) >= MaxProductBarcodes {
+			return ValidationErrors{"code": fmt.Sprintf("the product has the maximum of %d barcodes", MaxProductBarcodes)}
+		}
+		if err := tx.Create(barcode).Error; err != nil {
+			return err
+		}
+
+		after := before
+		after.Barcodes = append(append([]Barcode{}, before.Barcodes...), *barcode)
+		sort.Slice(after.Barcodes, func(i, j int) bool { return after.Barcodes[i].GTIN < after.Barcodes[j].GTIN })
+		if err := writeProduct(tx, &before, &after); err != nil {
+			return err
+		}
+		product = &after
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// RemoveBarcode takes the barcode from the product and gives the product a
+// new version, the code may be given in any of its lengths
+func RemoveBarcode(ctx context.Context, productID int, code string) (*Product, error) {
+	barcode, reason := parseBarcode(code)
+	if reason != "" {
+		return nil, ValidationErrors{"code": reason}
+	}
+
+	var product *Product
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		loaded, err := getProductVersion(tx, uint(productID), 0)
+		if err != nil {
+			return err
+		}
+		before := *loaded
+		if before.Barcodes, err = loadBarcodes(tx, productID); err != nil {
+			return err
+		}
+		result := tx.Where("gtin = ? AND product_id = ?", barcode.GTIN, productID).Delete(&Barcode{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrBarcodeNotFound
+		}
+
+		after := before
+		after.Barcodes = []Barcode{}
+		for _, b := range before.Barcodes {
+			if b.GTIN != barcode.GTIN {
+				after.Barcodes = append(after.Barcodes, b)
+			}
+		}
+		if err := writeProduct(tx, &before, &after); err != nil {
+			return err
+		}
+		product = &after
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// GetProductBarcode returns the barcode of the product, the code may be given
+// in any of its lengths
+func GetProductBarcode(productID int, code string) (*Barcode, error) {
+	barcode, reason := parseBarcode(code)
+	if reason != "" {
+		return nil, ValidationErrors{"code": reason}
+	}
+	var stored Barcode
+	err := DB.Where("gtin = ? AND product_id = ?", barcode.GTIN, productID).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBarcodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// LookupBarcode returns the product with the scanned barcode. A UPC-A finds
+// the product also when it was registered as EAN-13 and the other way round.
+// Products in the trash aren't found.
+func LookupBarcode(code string) (*Product, error) {
+	barcode, reason := parseBarcode(code)
+	if reason != "" {
+		return nil, ValidationErrors{"barcode": reason}
+	}
+	var stored Barcode
+	if err := DB.Where("gtin = ?", barcode.GTIN).First(&stored).Error; err != nil {
+		return nil, err
+	}
+	return GetProductByID(stored.ProductID)
+}
+
+// AttachBarcodes fills in the barcodes of the products
+func AttachBarcodes(products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]int, len(products))
+	for i := range products {
+		ids[i] = products[i].ID
+	}
+	var barcodes []Barcode
+	if err := DB.Where("product_id IN ?", ids).Order("gtin").Find(&barcodes).Error; err != nil {
+		return err
+	}
+	byProduct := map[int][]Barcode{}
+	for _, barcode := range barcodes {
+		byProduct[barcode.ProductID] = append(byProduct[barcode.ProductID], barcode)
+	}
+	for i := range products {
+		products[i].Barcodes = byProduct[products[i].ID]
+	}
+	return nil
+}
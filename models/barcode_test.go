@@ -0,0 +1,98 @@
+package models_test
+
+import (
+	"errors"
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestAddBarcode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// checked before the product is read
+	_, err = models.AddBarcode(alice, 5, "4006381333932")
+	assert.Equal(t, models.ValidationErrors{"code": "has an invalid check digit, expected 1"}, err)
+	_, err = models.AddBarcode(alice, 5, "40063813")
+	assert.Equal(t, models.ValidationErrors{"code": "must be a UPC-A, EAN-13 or GTIN-14 of 12, 13 or 14 digits"}, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "type", "price", "currency", "version"}).AddRow(5, "COLA", "simple", "1", "USD", 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE product_id = ? ORDER BY gtin")).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `barcodes` (`gtin`,`code`,`symbology`,`product_id`) VALUES (?,?,?,?)")).
+		WithArgs("00036000291452", "036000291452", "upca", 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "update", "product", 5, `{"barcodes":{"from":null,"to":[{"code":"036000291452","gtin":"00036000291452","symbology":"upca"}]},"version":{"from":2,"to":3}}`)
+	mock.ExpectCommit()
+
+	product, err := models.AddBarcode(alice, 5, " 036000291452 ")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, product.Version)
+	assert.Equal(t, []models.Barcode{{GTIN: "00036000291452", Code: "036000291452", Symbology: models.BarcodeUPCA, ProductID: 5}}, product.Barcodes)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestLookupBarcode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the UPC-A registered above scanned as EAN-13
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE gtin = ? ORDER BY `barcodes`.`gtin` LIMIT ?")).
+		WithArgs("00036000291452", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}).AddRow("00036000291452", "036000291452", "upca", 5))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku"}).AddRow(5, "COLA"))
+	expectDuePrices(mock, 5)
+
+	product, err := models.LookupBarcode("0036000291452")
+	assert.NoError(t, err)
+	assert.Equal(t, "COLA", product.SKU)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `barcodes` WHERE gtin = ?")).
+		WithArgs("04006381333931", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"gtin", "code", "symbology", "product_id"}))
+
+	_, err = models.LookupBarcode("4006381333931")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+	_, err = models.LookupBarcode("ABC")
+	assert.Equal(t, models.ValidationErrors{"barcode": "must be a UPC-A, EAN-13 or GTIN-14 of 12, 13 or 14 digits"}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
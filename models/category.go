@@ -0,0 +1,343 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrCategoryNotEmpty is returned when a category which still has
+	// subcategories or products is deleted
+	ErrCategoryNotEmpty = errors.New("category is not empty")
+	ErrUnknownCategory  = errors.New("unknown category")
+)
+
+// Category groups products, categories nest through their parent. Position
+// orders a category among its siblings, starting at 0.
+type Category struct {
+	ID        int       `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	ParentID  *int      `json:"parent_id" gorm:"column:parent_id;index:idx_categories_parent,priority:1"`
+	Name      string    `json:"name" gorm:"column:name;size:255;not null"`
+	Position  int       `json:"position" gorm:"column:position;not null;default:0;index:idx_categories_parent,priority:2"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+
+	Children []*Category `json:"children,omitempty" gorm:"-"` // filled in by the tree reads
+}
+
+// Normalize trims the name
+func (c *Category) Normalize() {
+	c.Name = strings.TrimSpace(c.Name)
+}
+
+// Validate checks the category, it returns ValidationErrors
+func (c *Category) Validate() error {
+	errs := ValidationErrors{}
+	switch {
+	case c.Name == "":
+		errs["name"] = "is required"
+	case len(c.Name) > 255:
+		errs["name"] = "must be at most 255 characters"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// categoryTree is every category by id and the children of every category in
+// position order, the roots are the children of 0
+type categoryTree struct {
+	byID     map[int]*Category
+	children map[int][]*Category
+}
+
+// loadCategoryTree reads every category, the catalog has few enough of them
+// to walk the tree in memory
+func loadCategoryTree(db *gorm.DB) (*categoryTree, error) {
+	var categories []*Category
+	if err := db.Order("position, id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	tree := &categoryTree{byID: map[int]*Category{}, children: map[int][]*Category{}}
+	for _, category := range categories {
+		tree.byID[category.ID] = category
+		parent := parentKey(category.ParentID)
+		tree.children[parent] = append(tree.children[parent], category)
+	}
+	return tree, nil
+}
+
+func parentKey(parentID *int) int {
+	if parentID == nil {
+		return 0
+	}
+	return *parentID
+}
+
+// subtree returns the id and the ids of every descendant of the category
+func (t *categoryTree) subtree(id int) []int {
+	ids := []int{id}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range t.children[ids[i]] {
+			ids = append(ids, child.ID)
+		}
+	}
+	return ids
+}
+
+// attachChildren fills in the children of the categories below the parent
+// and returns them
+func (t *categoryTree) attachChildren(parent int) []*Category {
+	children := t.children[parent]
+	for _, child := range children {
+		child.Children = t.attachChildren(child.ID)
+	}
+	return children
+}
+
+// lockCategoryTree loads the tree with every row locked, moves and deletes
+// renumber siblings and must not interleave
+func lockCategoryTree(tx *gorm.DB) (*categoryTree, error) {
+	return loadCategoryTree(tx.Clauses(clause.Locking{Strength: "UPDATE"}))
+}
+
+// GetCategoryTree returns the root categories with their subcategories
+func GetCategoryTree() ([]*Category, error) {
+	tree, err := loadCategoryTree(DB)
+	if err != nil {
+		return nil, err
+	}
+	roots := tree.attachChildren(0)
+	if roots == nil {
+		roots = []*Category{}
+	}
+	return roots, nil
+}
+
+// GetCategory returns the category with its subcategories, it fails with
+// gorm.ErrRecordNotFound for unknown ids
+func GetCategory(id int) (*Category, error) {
+	tree, err := loadCategoryTree(DB)
+	if err != nil {
+		return nil, err
+	}
+	category, ok := tree.byID[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	category.Children = tree.attachChildren(id)
+	return category, nil
+}
+
+// CategorySubtree returns the id of the category and of all its descendants,
+// it fails with ErrUnknownCategory for unknown ids
+func CategorySubtree(id int) ([]int, error) {
+	tree, err := loadCategoryTree(DB)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tree.byID[id]; !ok {
+		return nil, ErrUnknownCategory
+	}
+	return tree.subtree(id), nil
+}
+
+// CreateCategory adds the category as last child of its parent
+func CreateCategory(ctx context.Context, category *Category) (int, error) {
+	category.Normalize()
+	if err := category.Validate(); err != nil {
+		return 0, err
+	}
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tree, err := lockCategoryTree(tx)
+		if err != nil {
+			return err
+		}
+		if category.ParentID != nil && tree.byID[*category.ParentID] == nil {
+			return ValidationErrors{"parent_id": "unknown category"}
+		}
+		category.ID = 0
+		category.Position = len(tree.children[parentKey(category.ParentID)])
+		if err := tx.Create(category).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditCategory, category.ID, nil, category)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return category.ID, nil
+}
+
+// RenameCategory changes the name of the category
+func RenameCategory(ctx context.Context, id int, name string) (*Category, error) {
+	var category Category
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&category, id).Error; err != nil {
+			return err
+		}
+		before := category
+		category.Name = name
+		category.Normalize()
+		if err := category.Validate(); err != nil {
+			return err
+		}
+		if err := tx.Model(&category).Select("name", "updated_at").Updates(&category).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "update", AuditCategory, id, &before, &category)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// MoveCategory makes the category the child of the parent at the position, a
+// nil parent makes it a root and a nil position appends it to the siblings.
+// Moving within the same parent reorders the siblings.
+func MoveCategory(ctx context.Context, id int, parentID *int, position *int) (*Category, error) {
+	var moved Category
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tree, err := lockCategoryTree(tx)
+		if err != nil {
+			return err
+		}
+		category, ok := tree.byID[id]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		if parentID != nil {
+			if tree.byID[*parentID] == nil {
+				return ValidationErrors{"parent_id": "unknown category"}
+			}
+			for _, descendant := range tree.subtree(id) {
+				if descendant == *parentID {
+					return ValidationErrors{"parent_id": "must not be the category or one of its subcategories"}
+				}
+			}
+		}
+
+		oldParent, newParent := parentKey(category.ParentID), parentKey(parentID)
+		siblings := removeCategory(tree.children[newParent], id)
+		at := len(siblings)
+		if position != nil {
+			if *position < 0 || *position > len(siblings) {
+				return ValidationErrors{"position": fmt.Sprintf("must be between 0 and %d", len(siblings))}
+			}
+			at = *position
+		}
+
+		before := *category
+		category.ParentID = parentID
+		siblings = append(siblings[:at], append([]*Category{category}, siblings[at:]...)...)
+		if err := renumberCategories(tx, siblings, category); err != nil {
+			return err
+		}
+		if oldParent != newParent {
+			if err := renumberCategories(tx, removeCategory(tree.children[oldParent], id), nil); err != nil {
+				return err
+			}
+		}
+		moved = *category
+		return recordAudit(tx, "move", AuditCategory, id, &before, &moved)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &moved, nil
+}
+
+// DeleteCategory removes a category without subcategories and products, the
+// products in the trash count as well since they may be restored
+func DeleteCategory(ctx context.Context, id int) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tree, err := lockCategoryTree(tx)
+		if err != nil {
+			return err
+		}
+		category, ok := tree.byID[id]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		if len(tree.children[id]) > 0 {
+			return ErrCategoryNotEmpty
+		}
+		var count int64
+		if err := tx.Unscoped().Model(&Product{}).Where("category_id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrCategoryNotEmpty
+		}
+
+		if err := tx.Delete(&Category{}, id).Error; err != nil {
+			return err
+		}
+		if err := renumberCategories(tx, removeCategory(tree.children[parentKey(category.ParentID)], id), nil); err != nil {
+			return err
+		}
+		return recordAudit(tx, "delete", AuditCategory, id, category, nil)
+	})
+}
+
+// removeCategory returns a copy of the siblings without the category
+func removeCategory(siblings []*Category, id int) []*Category {
+	rest := make([]*Category, 0, len(siblings))
+	for _, sibling := range siblings {
+		if sibling.ID != id {
+			rest = append(rest, sibling)
+		}
+	}
+	return rest
+}
+
+// renumberCategories writes the positions of the siblings in their order, only
+// the rows which changed are updated. The parent of the moved category is
+// written as well.
+func renumberCategories(tx *gorm.DB, siblings []*Category, moved *Category) error {
+	now := time.Now()
+	for i, sibling := range siblings {
+		if sibling.Position == i && sibling != moved {
+			continue
+		}
+		sibling.Position, sibling.UpdatedAt = i, now
+		columns := map[string]interface{}{"position": i, "updated_at": now}
+		if sibling == moved {
+			columns["parent_id"] = sibling.ParentID
+		}
+		if err := tx.Model(&Category{}).Where("id = ?", sibling.ID).UpdateColumns(columns).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkProductCategory fails with ValidationErrors when the category of the
+// product doesn't exist. The category is share locked so that it can't be
+// deleted before the product is written.
+func checkProductCategory(tx *gorm.DB, product *Product) error {
+	if product.CategoryID == nil {
+		return nil
+	}
+	err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Select("id").First(&Category{}, *product.CategoryID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ValidationErrors{"category_id": "unknown category"}
+	}
+	return err
+}
+
+func equalIntPointers(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
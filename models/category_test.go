@@ -0,0 +1,188 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// fruit (1) has the children apples (2) and pears (3), apples has the child
+// red apples (4); vegetables (5) is the second root
+func categoryRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "parent_id", "name", "position"}).
+		AddRow(1, nil, "Fruit", 0).
+		AddRow(5, nil, "Vegetables", 1).
+		AddRow(2, 1, "Apples", 0).
+		AddRow(4, 2, "Red apples", 0).
+		AddRow(3, 1, "Pears", 1)
+}
+
+func TestMoveCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// pears become the first child of vegetables, apples move up in fruit
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id FOR UPDATE")).
+		WillReturnRows(categoryRows())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `categories` SET `parent_id`=?,`position`=?,`updated_at`=? WHERE id = ?")).
+		WithArgs(5, 0, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "move", "category", 3, `{"parent_id":{"from":1,"to":5},"position":{"from":1,"to":0}}`)
+	mock.ExpectCommit()
+
+	parent, position := 5, 0
+	category, err := models.MoveCategory(alice, 3, &parent, &position)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, *category.ParentID)
+	assert.Equal(t, 0, category.Position)
+
+	// fruit can't be moved below its own grandchild
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id FOR UPDATE")).
+		WillReturnRows(categoryRows())
+	mock.ExpectRollback()
+
+	parent = 4
+	_, err = models.MoveCategory(alice, 1, &parent, nil)
+	assert.Equal(t, models.ValidationErrors{"parent_id": "must not be the category or one of its subcategories"}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestReorderCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// vegetables become the first root, fruit moves behind them
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id FOR UPDATE")).
+		WillReturnRows(categoryRows())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `categories` SET `parent_id`=?,`position`=?,`updated_at`=? WHERE id = ?")).
+		WithArgs(nil, 0, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `categories` SET `position`=?,`updated_at`=? WHERE id = ?")).
+		WithArgs(1, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "move", "category", 5, `{"position":{"from":1,"to":0}}`)
+	mock.ExpectCommit()
+
+	position := 0
+	_, err = models.MoveCategory(alice, 5, nil, &position)
+	assert.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id FOR UPDATE")).
+		WillReturnRows(categoryRows())
+	mock.ExpectRollback()
+
+	position = 3
+	_, err = models.MoveCategory(alice, 5, nil, &position)
+	assert.Equal(t, models.ValidationErrors{"position": "must be between 0 and 1"}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestDeleteCategoryNotEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// apples have a subcategory
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id FOR UPDATE")).
+		WillReturnRows(categoryRows())
+	mock.ExpectRollback()
+
+	err = models.DeleteCategory(alice, 2)
+	assert.ErrorIs(t, err, models.ErrCategoryNotEmpty)
+
+	// red apples have products, deleted ones count as well
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id FOR UPDATE")).
+		WillReturnRows(categoryRows())
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `products` WHERE category_id = ?")).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	err = models.DeleteCategory(alice, 4)
+	assert.ErrorIs(t, err, models.ErrCategoryNotEmpty)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestListProductsInCategorySubtree(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	category := 1
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id")).
+		WillReturnRows(categoryRows())
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `products` WHERE category_id IN (?,?,?,?)")).
+		WithArgs(1, 2, 3, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE category_id IN (?,?,?,?) AND `products`.`deleted_at` IS NULL ORDER BY id LIMIT ?")).
+		WithArgs(1, 2, 3, 4, 51).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err = models.ListProducts(models.ProductQuery{Limit: 50, CategoryID: &category, IncludeSubcategories: true})
+	assert.NoError(t, err)
+
+	category = 9
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `categories` ORDER BY position, id")).
+		WillReturnRows(categoryRows())
+
+	_, err = models.ListProducts(models.ProductQuery{Limit: 50, CategoryID: &category})
+	assert.ErrorIs(t, err, models.ErrUnknownCategory)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,79 @@
+package models_test
+
+import (
+	"myapp/models"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitDBSQLite(t *testing.T) {
+	url := "sqlite://" + filepath.Join(t.TempDir(), "inventory.db")
+	db, err := models.InitDB(url)
+	if err != nil && strings.Contains(err.Error(), "CGO_ENABLED=0") {
+		t.Skip("the SQLite driver needs cgo")
+	}
+	if !assert.NoError(t, err) {
+		return
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	price, _ := models.ParseDecimal("2.5")
+	_, err = models.CreateAttribute(alice, &models.Attribute{Code: "organic", Name: "Organic", Type: models.AttributeBoolean})
+	assert.NoError(t, err)
+	product := &models.Product{SKU: "APP-001", Name: "Apple", Price: price, Tags: models.StringList{"fruit"},
+		Attributes: models.AttributeValues{"organic": true}}
+	id, err := models.CreateProduct(alice, product)
+	assert.NoError(t, err)
+
+	_, err = models.CreateProduct(alice, &models.Product{SKU: "APP-001", Name: "Apple", Price: price})
+	assert.True(t, models.IsDuplicateKeyError(err), "got %v", err)
+
+	page, err := models.ListProducts(models.ProductQuery{Limit: 10, Attributes: []models.AttributeFilter{{Code: "organic", Value: "true"}}})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Data, 1) {
+		assert.Equal(t, id, page.Data[0].ID)
+	}
+
+	warehouseID, err := models.CreateWarehouse(alice, &models.Warehouse{Code: "MAIN", Name: "Main"})
+	assert.NoError(t, err)
+	_, err = models.PostMovement(alice, &models.MovementInput{Type: models.MovementReceipt, ProductID: id, WarehouseID: warehouseID, Quantity: 10})
+	assert.NoError(t, err)
+	_, err = models.CreateReservation(alice, &models.ReservationInput{ProductID: id, WarehouseID: warehouseID, Quantity: 4})
+	assert.NoError(t, err)
+	summaries, err := models.GetStockSummaries([]int{id})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, summaries[id].Available)
+
+	updated, err := models.UpdateProduct(alice, uint(id), &models.Product{SKU: "APP-001", Name: "Green Apple", Price: price}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+
+	search, err := models.SearchProducts(models.ProductSearch{Query: "green", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, search.Total)
+
+	_, err = models.DeleteProduct(alice, id, 2)
+	assert.NoError(t, err)
+	_, err = models.GetProductByID(id)
+	assert.Error(t, err)
+
+	// InitDB migrates an existing database again
+	db, err = models.InitDB(url)
+	if assert.NoError(t, err) {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}
+}
+
+func TestInitDBUnsupportedDatabase(t *testing.T) {
+	_, err := models.InitDB("postgres://postgres@localhost/inventory")
+	assert.EqualError(t, err, `database "postgres" is not supported yet, use mysql:// or sqlite://`)
+	_, err = models.InitDB("oracle://localhost/inventory")
+	assert.EqualError(t, err, `unknown database "oracle", use mysql:// or sqlite://`)
+	_, err = models.InitDB("sqlite://?_busy_timeout=100")
+	assert.EqualError(t, err, "the SQLite URL has no file")
+}
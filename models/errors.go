@@ -0,0 +1,61 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// mysql error number for "Duplicate entry ... for key ..."
+const mysqlErrDuplicateEntry = 1062
+
+// mysql error number for "Deadlock found when trying to get lock"
+const mysqlErrDeadlock = 1213
+
+// IsDuplicateKeyError reports whether err is a unique constraint violation
+// of MySQL or SQLite
+func IsDuplicateKeyError(err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDuplicateEntry
+	}
+	// the SQLite driver reads the code of the error without cgo
+	return errors.Is(sqlite.Dialector{}.Translate(err), gorm.ErrDuplicatedKey)
+}
+
+// IsDeadlockError reports whether InnoDB rolled the transaction back to break
+// a deadlock, SQLite runs one writer at a time and has none
+func IsDeadlockError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDeadlock
+}
+
+// TrashedSKUError means the SKU belongs to a product in the trash, which keeps
+// its SKU until it is purged
+type TrashedSKUError struct {
+	ProductID int
+}
+
+func (e *TrashedSKUError) Error() string {
+	return fmt.Sprintf("SKU belongs to product %d in the trash, restore it with POST /products/%d/restore", e.ProductID, e.ProductID)
+}
+
+// trashedSKUError turns the duplicate key error of a write of the SKU into a
+// TrashedSKUError when a product in the trash holds the SKU
+func trashedSKUError(tx *gorm.DB, sku string, err error) error {
+	if !IsDuplicateKeyError(err) {
+		return err
+	}
+	var trashed Product
+	lookup := tx.Unscoped().Select("id").Where("sku = ? AND deleted_at IS NOT NULL", sku).Limit(1).Find(&trashed)
+	if lookup.Error != nil || trashed.ID == 0 {
+		return err
+	}
+	return &TrashedSKUError{ProductID: trashed.ID}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNoExchangeRate = errors.New("no exchange rate")
+
+// ExchangeRate converts amounts of FromCurrency into ToCurrency, one unit of
+// FromCurrency is Rate units of ToCurrency
+type ExchangeRate struct {
+	ID           int       `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	FromCurrency string    `json:"from_currency" gorm:"column:from_currency;size:3;not null;uniqueIndex:idx_exchange_rates_pair,priority:1"`
+	ToCurrency   string    `json:"to_currency" gorm:"column:to_currency;size:3;not null;uniqueIndex:idx_exchange_rates_pair,priority:2"`
+	Rate         Rate      `json:"rate" gorm:"column:rate;type:decimal(19,8);not null"`
+	UpdatedBy    string    `json:"updated_by" gorm:"column:updated_by;size:64"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func GetExchangeRates() ([]ExchangeRate, error) {
+	rates := []ExchangeRate{}
+	if err := DB.Order("from_currency, to_currency").Find(&rates).Error; err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// SetExchangeRate adds the rate of the currency pair or replaces it
+func SetExchangeRate(ctx context.Context, from, to string, rate Rate) (*ExchangeRate, error) {
+	errs := ValidationErrors{}
+	for field, currency := range map[string]string{"from_currency": from, "to_currency": to} {
+		if _, ok := CurrencyPlaces(currency); !ok {
+			errs[field] = "must be an ISO 4217 currency code"
+		}
+	}
+	if from == to {
+		errs["to_currency"] = "must differ from from_currency"
+	}
+	if rate.units <= 0 {
+		errs["rate"] = "must be a positive number"
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// the unique index decides which of two concurrent first rates of a pair
+	// is created, the other one is retried as an update
+	var saved ExchangeRate
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		saved, err = saveExchangeRate(ctx, from, to, rate)
+		if !IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func saveExchangeRate(ctx context.Context, from, to string, rate Rate) (ExchangeRate, error) {
+	actor := actorFrom(ctx)
+	var saved ExchangeRate
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("from_currency = ? AND to_currency = ?", from, to).First(&saved).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			saved = ExchangeRate{FromCurrency: from, ToCurrency: to, Rate: rate, UpdatedBy: actor.Username}
+			if err := tx.Create(&saved).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, "create", AuditExchangeRate, saved.ID, nil, &saved)
+		}
+		if err != nil {
+			return err
+		}
+
+		before := saved
+		saved.Rate, saved.UpdatedBy = rate, actor.Username
+		if err := tx.Model(&saved).Select("rate", "updated_by", "updated_at").Updates(&saved).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "update", AuditExchangeRate, saved.ID, &before, &saved)
+	})
+	return saved, err
+}
+
+// exchangeRates converts between currencies with the stored rates. A pair
+// without a rate uses the inverse of the opposite pair.
+type exchangeRates map[[2]string]Rate
+
+func loadExchangeRates(db *gorm.DB) (exchangeRates, error) {
+	var rows []ExchangeRate
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	rates := exchangeRates{}
+	for _, row := range rows {
+		rates[[2]string{row.FromCurrency, row.ToCurrency}] = row.Rate
+	}
+	return rates, nil
+}
+
+// convert returns the amount in the currency to, rounded to its decimal places
+func (rates exchangeRates) convert(amount Decimal, from, to string) (Decimal, error) {
+	if from == to {
+		return amount, nil
+	}
+	places, ok := CurrencyPlaces(to)
+	if !ok {
+		places = DecimalPlaces
+	}
+	if rate, ok := rates[[2]string{from, to}]; ok {
+		return amount.Convert(rate, false, places)
+	}
+	if rate, ok := rates[[2]string{to, from}]; ok {
+		return amount.Convert(rate, true, places)
+	}
+	return Decimal{}, fmt.Errorf("%w from %s to %s", ErrNoExchangeRate, from, to)
+}
@@ -0,0 +1,59 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestSetExchangeRateRetriesConcurrentCreate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// another request creates the pair between the read and the insert
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `exchange_rates` WHERE from_currency = ? AND to_currency = ? ORDER BY `exchange_rates`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs("USD", "EUR", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `exchange_rates`")).
+		WillReturnError(&mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+
+	// the retry replaces its rate
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `exchange_rates` WHERE from_currency = ? AND to_currency = ? ORDER BY `exchange_rates`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs("USD", "EUR", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_currency", "to_currency", "rate", "updated_by"}).
+			AddRow(4, "USD", "EUR", "0.90000000", "bob"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `exchange_rates` SET `rate`=?,`updated_by`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs("0.92", "alice", sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "update", "exchange_rate", 4, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	rate, err := models.ParseRate("0.92")
+	assert.NoError(t, err)
+	saved, err := models.SetExchangeRate(alice, "USD", "EUR", rate)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, saved.ID)
+	assert.Equal(t, "alice", saved.UpdatedBy)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
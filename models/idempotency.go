@@ -0,0 +1,149 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIdempotencyKeyMismatch   = errors.New("idempotency key was used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is in progress")
+	ErrIdempotencyKeyLost       = errors.New("idempotency key is no longer held by the request")
+)
+
+// how long the response of an idempotent request is kept for replay
+var IdempotencyKeyTTL = 24 * time.Hour
+
+// the lease of a key in progress. The request renews it while it is
+// processed, a lease which ran out belongs to a request that crashed and is
+// taken over by the next retry.
+var IdempotencyLockTimeout = time.Minute
+
+type IdempotencyStatus string
+
+const (
+	IdempotencyInProgress IdempotencyStatus = "in_progress"
+	IdempotencyCompleted  IdempotencyStatus = "completed"
+)
+
+// IdempotencyKey remembers a request made with an Idempotency-Key header and
+// its response. Keys are scoped to the user who sent them.
+type IdempotencyKey struct {
+	ID           int               `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	UserID       int               `json:"user_id" gorm:"column:user_id;not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Key          string            `json:"key" gorm:"column:idempotency_key;size:255;not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Fingerprint  string            `json:"-" gorm:"column:fingerprint;size:64;not null"` // sha256 of method, path and body
+	Status       IdempotencyStatus `json:"status" gorm:"column:status;size:16;not null"`
+	ResponseCode int               `json:"response_code" gorm:"column:response_code"`
+	ContentType  string            `json:"-" gorm:"column:content_type;size:255"`
+	ResponseBody []byte            `json:"-" gorm:"column:response_body"`
+	ExpiresAt    time.Time         `json:"expires_at" gorm:"column:expires_at;index;not null"`
+	LockedUntil  *time.Time        `json:"locked_until" gorm:"column:locked_until"` // end of the lease of an in progress request
+	CreatedAt    time.Time         `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt    time.Time         `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// BeginIdempotentRequest claims the key for a request. started is true when
+// the caller has to process the request and record its response, otherwise
+// the returned key holds the completed response to replay.
+func BeginIdempotentRequest(userID int, key, fingerprint string) (record *IdempotencyKey, started bool, err error) {
+	// the unique index decides which of two concurrent requests gets the key,
+	// the second attempt runs after an expired key was removed
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+		lockedUntil := now.Add(IdempotencyLockTimeout)
+		record = &IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			Fingerprint: fingerprint,
+			Status:      IdempotencyInProgress,
+			ExpiresAt:   now.Add(IdempotencyKeyTTL),
+			LockedUntil: &lockedUntil,
+		}
+		err = DB.Create(record).Error
+		if err == nil {
+			return record, true, nil
+		}
+		if !IsDuplicateKeyError(err) {
+			return nil, false, err
+		}
+
+		var existing IdempotencyKey
+		err = DB.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// the other request failed and gave the key up
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if existing.ExpiresAt.Before(now) {
+			if err := DB.Where("id = ? AND expires_at < ?", existing.ID, now).Delete(&IdempotencyKey{}).Error; err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if existing.Status == IdempotencyInProgress && existing.LockedUntil != nil && existing.LockedUntil.Before(now) {
+			// the request which held the key crashed
+			err := DB.Where("id = ? AND status = ? AND locked_until < ?", existing.ID, IdempotencyInProgress, now).
+				Delete(&IdempotencyKey{}).Error
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if existing.Fingerprint != fingerprint {
+			return nil, false, ErrIdempotencyKeyMismatch
+		}
+		if existing.Status != IdempotencyCompleted {
+			return nil, false, ErrIdempotencyKeyInProgress
+		}
+		return &existing, false, nil
+	}
+	return nil, false, ErrIdempotencyKeyInProgress
+}
+
+// RenewIdempotentRequest extends the lease of a started request, it fails
+// with ErrIdempotencyKeyLost when the key was taken over or removed
+func RenewIdempotentRequest(id int) error {
+	result := DB.Model(&IdempotencyKey{}).Where("id = ? AND status = ?", id, IdempotencyInProgress).
+		Update("locked_until", time.Now().Add(IdempotencyLockTimeout))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// CompleteIdempotentRequest stores the response of a started request, it
+// fails with ErrIdempotencyKeyLost when the key was taken over or removed
+func CompleteIdempotentRequest(id int, code int, contentType string, body []byte) error {
+	result := DB.Model(&IdempotencyKey{}).Where("id = ? AND status = ?", id, IdempotencyInProgress).Updates(map[string]interface{}{
+		"status":        IdempotencyCompleted,
+		"response_code": code,
+		"content_type":  contentType,
+		"response_body": body,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// AbandonIdempotentRequest releases the key of a request that failed, so
+// that the client may retry it
+func AbandonIdempotentRequest(id int) error {
+	return DB.Where("id = ?", id).Delete(&IdempotencyKey{}).Error
+}
+
+func PurgeExpiredIdempotencyKeys() error {
+	return DB.Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{}).Error
+}
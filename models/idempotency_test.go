@@ -0,0 +1,129 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestBeginIdempotentRequestTakesOverExpiredKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WillReturnError(&mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `idempotency_keys` WHERE user_id = ? AND idempotency_key = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "idempotency_key", "fingerprint", "status", "expires_at"}).
+			AddRow(3, 7, "key-1", "other", "completed", time.Now().Add(-time.Minute)))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `idempotency_keys` WHERE id = ? AND expires_at < ?")).
+		WithArgs(3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+
+	record, started, err := models.BeginIdempotentRequest(7, "key-1", "fingerprint")
+	assert.NoError(t, err)
+	assert.True(t, started)
+	assert.Equal(t, 4, record.ID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestBeginIdempotentRequestTakesOverAbandonedKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the request holding the key crashed, its lease ran out
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WillReturnError(&mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `idempotency_keys` WHERE user_id = ? AND idempotency_key = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "idempotency_key", "fingerprint", "status", "expires_at", "locked_until"}).
+			AddRow(3, 7, "key-1", "fingerprint", "in_progress", time.Now().Add(time.Hour), time.Now().Add(-time.Second)))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `idempotency_keys` WHERE id = ? AND status = ? AND locked_until < ?")).
+		WithArgs(3, "in_progress", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+
+	record, started, err := models.BeginIdempotentRequest(7, "key-1", "fingerprint")
+	assert.NoError(t, err)
+	assert.True(t, started)
+	assert.Equal(t, 4, record.ID)
+	assert.WithinDuration(t, time.Now().Add(models.IdempotencyLockTimeout), *record.LockedUntil, time.Second)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestCompleteIdempotentRequestAfterTakeover(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the key was taken over by a retry after its lease ran out
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `idempotency_keys` SET `locked_until`=?,`updated_at`=? WHERE id = ? AND status = ?")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 3, "in_progress").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `idempotency_keys` SET `content_type`=?,`response_body`=?,`response_code`=?,`status`=?,`updated_at`=? WHERE id = ? AND status = ?")).
+		WithArgs("application/json", []byte(`{}`), 200, "completed", sqlmock.AnyArg(), 3, "in_progress").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.ErrorIs(t, models.RenewIdempotentRequest(3), models.ErrIdempotencyKeyLost)
+	assert.ErrorIs(t, models.CompleteIdempotentRequest(3, 200, "application/json", []byte(`{}`)), models.ErrIdempotencyKeyLost)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
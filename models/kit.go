@@ -0,0 +1,378 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNotAKit is returned when components are set for a simple product
+	ErrNotAKit = errors.New("product is not a kit")
+	// ErrKitChangedConcurrently is returned when another kit was defined at
+	// the same time and the database gave up this definition, it may be retried
+	ErrKitChangedConcurrently = errors.New("kit components changed concurrently")
+)
+
+// upper limit of the components of a kit
+const MaxKitComponents = 50
+
+// KitComponent is a product and the quantity of it in one kit. Components may
+// be kits themselves, as long as no kit ends up containing itself.
+type KitComponent struct {
+	KitID       int `json:"-" gorm:"column:kit_id;primaryKey;autoIncrement:false"`
+	ComponentID int `json:"product_id" gorm:"column:component_id;primaryKey;autoIncrement:false;index"`
+	Quantity    int `json:"quantity" gorm:"column:quantity;not null"`
+}
+
+// validateComponents checks the list without the database, errors are keyed
+// by "components[<index>].<field>"
+func validateComponents(kitID int, components []KitComponent) error {
+	if len(components) > MaxKitComponents {
+		return ValidationErrors{"components": fmt.Sprintf("must be at most %d", MaxKitComponents)}
+	}
+	errs := ValidationErrors{}
+	seen := map[int]bool{}
+	for i, component := range components {
+		field := fmt.Sprintf("components[%d].", i)
+		switch {
+		case component.ComponentID <= 0:
+			errs[field+"product_id"] = "is required"
+		case component.ComponentID == kitID:
+			errs[field+"product_id"] = "must not be the kit itself"
+		case seen[component.ComponentID]:
+			errs[field+"product_id"] = "is listed twice"
+		}
+		seen[component.ComponentID] = true
+		if component.Quantity <= 0 {
+			errs[field+"quantity"] = "must be greater than 0"
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetKitComponents replaces the components of the kit and gives it a new
+// version. The kit must still be at the version, version 0 updates any
+// version. Definitions which would make a kit contain itself, directly or
+// through other kits, are rejected.
+func SetKitComponents(ctx context.Context, id int, components []KitComponent, version int) (*Product, error) {
+	if err := validateComponents(id, components); err != nil {
+		return nil, err
+	}
+	components = append([]KitComponent{}, components...)
+	sort.Slice(components, func(i, j int) bool { return components[i].ComponentID < components[j].ComponentID })
+
+	var product *Product
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		loaded, err := getProductVersion(tx, uint(id), version)
+		if err != nil {
+			return err
+		}
+		if loaded.Type != ProductTypeKit {
+			return ErrNotAKit
+		}
+		before := *loaded
+		if before.Components, err = loadComponents(tx, id); err != nil {
+			return err
+		}
+
+		ids := make([]int, len(components))
+		for i := range components {
+			ids[i] = components[i].ComponentID
+		}
+		if err := checkComponentsExist(tx, ids); err != nil {
+			return err
+		}
+		if err := checkKitCycle(tx, id, ids); err != nil {
+			return err
+		}
+
+		if err := tx.Where("kit_id = ?", id).Delete(&KitComponent{}).Error; err != nil {
+			return err
+		}
+		for i := range components {
+			components[i].KitID = id
+		}
+		if len(components) > 0 {
+			if err := tx.Create(&components).Error; err != nil {
+				return err
+			}
+		}
+
+		after := before
+		after.Components = components
+		if err := writeProduct(tx, &before, &after); err != nil {
+			return err
+		}
+		product = &after
+		return nil
+	})
+	if IsDeadlockError(err) {
+		return nil, ErrKitChangedConcurrently
+	}
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+func loadComponents(db *gorm.DB, kitID int) ([]KitComponent, error) {
+	components := []KitComponent{}
+	if err := db.Where("kit_id = ?", kitID).Order("component_id").Find(&components).Error; err != nil {
+		return nil, err
+	}
+	return components, nil
+}
+
+// checkComponentsExist fails with the ValidationErrors of the ids which
+// aren't products
+func checkComponentsExist(tx *gorm.DB, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var existing []int
+	if err := tx.Model(&Product{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+		return err
+	}
+	found := map[int]bool{}
+	for _, id := range existing {
+		found[id] = true
+	}
+	errs := ValidationErrors{}
+	for i, id := range ids {
+		if !found[id] {
+			errs[fmt.Sprintf("components[%d].product_id", i)] = "unknown product"
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkKitCycle walks down from the new components and fails when it reaches
+// the kit. The rows are read with a shared lock: of two concurrent definitions
+// which together would form a cycle, the one that writes second waits for the
+// other, InnoDB detects the deadlock and rolls one of them back.
+func checkKitCycle(tx *gorm.DB, kitID int, componentIDs []int) error {
+	visited := map[int]bool{}
+	frontier := componentIDs
+	for len(frontier) > 0 {
+		var rows []KitComponent
+		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Where("kit_id IN ?", frontier).Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		for _, id := range frontier {
+			visited[id] = true
+		}
+		frontier = nil
+		for _, row := range rows {
+			if row.ComponentID == kitID {
+				return ValidationErrors{"components": fmt.Sprintf("would make the kit contain itself through kit %d", row.KitID)}
+			}
+			if !visited[row.ComponentID] {
+				visited[row.ComponentID] = true
+				frontier = append(frontier, row.ComponentID)
+			}
+		}
+	}
+	return nil
+}
+
+// kitParts returns the simple products each kit is made of and their
+// quantity per kit, nested kits are expanded
+func kitParts(db *gorm.DB, kitIDs []int) (map[int]map[int]int, error) {
+	byKit := map[int][]KitComponent{}
+	loaded := map[int]bool{}
+	frontier := kitIDs
+	for len(frontier) > 0 {
+		var rows []KitComponent
+		if err := db.Where("kit_id IN ?", frontier).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range frontier {
+			loaded[id] = true
+		}
+		frontier = nil
+		for _, row := range rows {
+			byKit[row.KitID] = append(byKit[row.KitID], row)
+			if !loaded[row.ComponentID] {
+				loaded[row.ComponentID] = true
+				frontier = append(frontier, row.ComponentID)
+			}
+		}
+	}
+
+	// a component without components of its own is a part, kits without
+	// components have no parts and are never available
+	var expand func(id, quantity int, parts map[int]int)
+	expand = func(id, quantity int, parts map[int]int) {
+		for _, component := range byKit[id] {
+			if len(byKit[component.ComponentID]) == 0 {
+				parts[component.ComponentID] += quantity * component.Quantity
+				continue
+			}
+			expand(component.ComponentID, quantity*component.Quantity, parts)
+		}
+	}
+	result := make(map[int]map[int]int, len(kitIDs))
+	for _, id := range kitIDs {
+		result[id] = map[int]int{}
+		expand(id, 1, result[id])
+	}
+	return result, nil
+}
+
+// kitStockLevels computes the stock levels of the kits from the stock of
+// their parts. A kit is available in a warehouse as often as its scarcest
+// part allows, warehouses which lack a part are left out.
+func kitStockLevels(db *gorm.DB, kitIDs []int) (map[int][]StockLevel, error) {
+	parts, err := kitParts(db, kitIDs)
+	if err != nil {
+		return nil, err
+	}
+	var partIDs []int
+	seen := map[int]bool{}
+	for _, kit := range parts {
+		for id := range kit {
+			if !seen[id] {
+				seen[id] = true
+				partIDs = append(partIDs, id)
+			}
+		}
+	}
+
+	sort.Ints(partIDs)
+
+	result := map[int][]StockLevel{}
+	if len(partIDs) == 0 {
+		return result, nil
+	}
+	var levels []StockLevel
+	if err := db.Where("product_id IN ?", partIDs).Order("warehouse_id").Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	byPart := map[int]map[int]StockLevel{}
+	var warehouses []int
+	seenWarehouse := map[int]bool{}
+	for _, level := range levels {
+		if byPart[level.ProductID] == nil {
+			byPart[level.ProductID] = map[int]StockLevel{}
+		}
+		byPart[level.ProductID][level.WarehouseID] = level
+		if !seenWarehouse[level.WarehouseID] {
+			seenWarehouse[level.WarehouseID] = true
+			warehouses = append(warehouses, level.WarehouseID)
+		}
+	}
+
+	for _, kitID := range kitIDs {
+		if len(parts[kitID]) == 0 {
+			continue
+		}
+		for _, warehouseID := range warehouses {
+			kit := StockLevel{ProductID: kitID, WarehouseID: warehouseID, OnHand: -1, Available: -1}
+			for partID, quantity := range parts[kitID] {
+				level, ok := byPart[partID][warehouseID]
+				if !ok {
+					kit.OnHand = -1
+					break
+				}
+				kit.OnHand = minKits(kit.OnHand, level.OnHand/quantity)
+				kit.Available = minKits(kit.Available, level.Available/quantity)
+				if level.UpdatedAt.After(kit.UpdatedAt) {
+					kit.UpdatedAt = level.UpdatedAt
+				}
+			}
+			if kit.OnHand < 0 {
+				continue
+			}
+			kit.Available = max(kit.Available, 0)
+			kit.Reserved = kit.OnHand - kit.Available
+			result[kitID] = append(result[kitID], kit)
+		}
+	}
+	return result, nil
+}
+
+// minKits is the smaller count of kits, -1 stands for no limit yet
+func minKits(current, count int) int {
+	count = max(count, 0)
+	if current < 0 || count < current {
+		return count
+	}
+	return current
+}
+
+// GetKitStock returns the stock levels the parts of the kit make up
+func GetKitStock(kitID int) ([]StockLevel, error) {
+	levels, err := kitStockLevels(DB, []int{kitID})
+	if err != nil {
+		return nil, err
+	}
+	if levels[kitID] == nil {
+		return []StockLevel{}, nil
+	}
+	return levels[kitID], nil
+}
+
+// AttachComponents fills in the components of the kits
+func AttachComponents(products []Product) error {
+	var ids []int
+	for i := range products {
+		if products[i].Type == ProductTypeKit {
+			ids = append(ids, products[i].ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var components []KitComponent
+	if err := DB.Where("kit_id IN ?", ids).Order("component_id").Find(&components).Error; err != nil {
+		return err
+	}
+	byKit := map[int][]KitComponent{}
+	for _, component := range components {
+		byKit[component.KitID] = append(byKit[component.KitID], component)
+	}
+	for i := range products {
+		if products[i].Type == ProductTypeKit {
+			products[i].Components = byKit[products[i].ID]
+			if products[i].Components == nil {
+				products[i].Components = []KitComponent{}
+			}
+		}
+	}
+	return nil
+}
+
+// checkProductTypeChange allows a product to become a kit while it holds no
+// stock, and a kit to become a simple product once it has no components
+func checkProductTypeChange(tx *gorm.DB, product *Product) error {
+	var count int64
+	if product.Type == ProductTypeKit {
+		err := tx.Model(&StockLevel{}).Where("product_id = ? AND (on_hand <> 0 OR reserved <> 0)", product.ID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ValidationErrors{"type": "can't become kit while the product has stock"}
+		}
+		return nil
+	}
+	if err := tx.Model(&KitComponent{}).Where("kit_id = ?", product.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ValidationErrors{"type": "can't change while the kit has components"}
+	}
+	return nil
+}
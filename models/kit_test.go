@@ -0,0 +1,247 @@
+package models_test
+
+import (
+	"errors"
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// the gift set (10) holds two mugs (1) and one tea (2)
+func expectGiftSetParts(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `kit_components` WHERE kit_id IN (?)")).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"kit_id", "component_id", "quantity"}).AddRow(10, 1, 2).AddRow(10, 2, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `kit_components` WHERE kit_id IN (?,?)")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"kit_id", "component_id", "quantity"}))
+}
+
+func expectIssue(mock sqlmock.Sqlmock, productID, levelID, onHand, reserved int) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `warehouses` WHERE `warehouses`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "allow_negative"}).AddRow(2, "TPE", false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `stock_levels`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `stock_levels` WHERE product_id = ? AND warehouse_id = ? ORDER BY `stock_levels`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(productID, 2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "warehouse_id", "on_hand", "reserved"}).AddRow(levelID, productID, 2, onHand, reserved))
+}
+
+func TestPostKitIssue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// selling two gift sets issues four mugs and two teas
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`type` FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(10, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(10, "kit"))
+	expectGiftSetParts(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `products` WHERE (id IN (?,?) AND type = ?) AND `products`.`deleted_at` IS NULL")).
+		WithArgs(1, 2, "kit").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	expectIssue(mock, 1, 11, 10, 0)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_levels` SET `on_hand`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs(6, sqlmock.AnyArg(), 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectIssue(mock, 2, 12, 5, 1)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_levels` SET `on_hand`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs(3, sqlmock.AnyArg(), 12).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `stock_movements`")).
+		WithArgs(sqlmock.AnyArg(), 1, 2, "issue", -4, "SO-1", "", 10, "alice", sqlmock.AnyArg(),
+			sqlmock.AnyArg(), 2, 2, "issue", -2, "SO-1", "", 10, "alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	expectAudit(mock, "create", "stock_movement", 1, sqlmock.AnyArg())
+	expectAudit(mock, "create", "stock_movement", 2, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	movements, err := models.PostMovement(alice, &models.MovementInput{
+		Type:        models.MovementIssue,
+		ProductID:   10,
+		WarehouseID: 2,
+		Quantity:    2,
+		Reference:   "SO-1",
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, movements, 2) {
+		assert.Equal(t, 10, *movements[0].KitID)
+		assert.Equal(t, movements[0].BatchID, movements[1].BatchID)
+	}
+
+	// the teas run out, the mugs taken before are rolled back
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`type` FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(10, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(10, "kit"))
+	expectGiftSetParts(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `products` WHERE (id IN (?,?) AND type = ?)")).
+		WithArgs(1, 2, "kit").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	expectIssue(mock, 1, 11, 6, 0)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_levels` SET `on_hand`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs(2, sqlmock.AnyArg(), 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectIssue(mock, 2, 12, 3, 2)
+	mock.ExpectRollback()
+
+	_, err = models.PostMovement(alice, &models.MovementInput{
+		Type:        models.MovementIssue,
+		ProductID:   10,
+		WarehouseID: 2,
+		Quantity:    2,
+	})
+	assert.True(t, errors.Is(err, models.ErrInsufficientStock))
+
+	// kits have no stock of their own to receive
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`type` FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(10, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(10, "kit"))
+	mock.ExpectRollback()
+
+	_, err = models.PostMovement(alice, &models.MovementInput{
+		Type:        models.MovementReceipt,
+		ProductID:   10,
+		WarehouseID: 2,
+		Quantity:    2,
+	})
+	assert.Equal(t, models.ValidationErrors{"type": "must be issue for a kit, move its components instead"}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestKitStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// warehouse 2 has mugs for 4 sets and tea for 3, one tea is reserved;
+	// warehouse 3 has no tea and can't make any set
+	expectGiftSetParts(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `stock_levels` WHERE product_id IN (?,?) ORDER BY warehouse_id")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "warehouse_id", "on_hand", "reserved"}).
+			AddRow(11, 1, 2, 9, 0).
+			AddRow(12, 2, 2, 3, 1).
+			AddRow(13, 1, 3, 20, 0))
+
+	products := []models.Product{{ID: 10, Type: models.ProductTypeKit}}
+	assert.NoError(t, models.AttachStock(products))
+	assert.Equal(t, models.StockSummary{ProductID: 10, OnHand: 3, Reserved: 1, Available: 2}, *products[0].Stock)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestSetKitComponentsRejectsCycle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// checked before the kit is read
+	_, err = models.SetKitComponents(alice, 10, []models.KitComponent{{ComponentID: 10, Quantity: 1}, {ComponentID: 1}}, 1)
+	assert.Equal(t, models.ValidationErrors{
+		"components[0].product_id": "must not be the kit itself",
+		"components[1].quantity":   "must be greater than 0",
+	}, err)
+
+	// the hamper (20) holds the gift set, which can't hold the hamper
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(10, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "type", "version"}).AddRow(10, "GIFT", "kit", 3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `kit_components` WHERE kit_id = ? ORDER BY component_id")).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"kit_id", "component_id", "quantity"}).AddRow(10, 1, 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `products` WHERE id IN (?,?)")).
+		WithArgs(1, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(20))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `kit_components` WHERE kit_id IN (?,?) FOR SHARE")).
+		WithArgs(1, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"kit_id", "component_id", "quantity"}).AddRow(20, 10, 1))
+	mock.ExpectRollback()
+
+	_, err = models.SetKitComponents(alice, 10, []models.KitComponent{{ComponentID: 20, Quantity: 1}, {ComponentID: 1, Quantity: 2}}, 3)
+	assert.Equal(t, models.ValidationErrors{"components": "would make the kit contain itself through kit 20"}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestSetKitComponentsConcurrentCycle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the hamper (20) gets the gift set while the gift set gets the hamper,
+	// InnoDB picks this definition to break the deadlock
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(10, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "type", "version"}).AddRow(10, "GIFT", "kit", 3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `kit_components` WHERE kit_id = ? ORDER BY component_id")).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"kit_id", "component_id", "quantity"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `products` WHERE id IN (?)")).
+		WithArgs(20).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `kit_components` WHERE kit_id IN (?) FOR SHARE")).
+		WithArgs(20).
+		WillReturnRows(sqlmock.NewRows([]string{"kit_id", "component_id", "quantity"}))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `kit_components` WHERE kit_id = ?")).
+		WithArgs(10).
+		WillReturnError(&mysqlDriver.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"})
+	mock.ExpectRollback()
+
+	_, err = models.SetKitComponents(alice, 10, []models.KitComponent{{ComponentID: 20, Quantity: 1}}, 3)
+	assert.ErrorIs(t, err, models.ErrKitChangedConcurrently)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,366 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DecimalPlaces is the number of decimal places a Decimal keeps, the columns
+// are DECIMAL(19,4)
+const DecimalPlaces = 4
+
+const decimalUnit = 10000 // 10^DecimalPlaces
+
+var (
+	ErrInvalidDecimal = errors.New("invalid decimal number")
+	ErrDecimalPlaces  = fmt.Errorf("decimal number has more than %d decimal places", DecimalPlaces)
+)
+
+// Decimal is an exact decimal number with DecimalPlaces decimal places. JSON
+// numbers and strings are both accepted, it is written as a JSON number with
+// its exact digits so that no precision is lost. The zero value is 0.
+type Decimal struct {
+	units int64 // in 10^-DecimalPlaces
+}
+
+var decimalType = reflect.TypeOf(Decimal{})
+
+// DecimalFromInt returns the whole number n
+func DecimalFromInt(n int64) Decimal {
+	return Decimal{n * decimalUnit}
+}
+
+// MustParseDecimal is ParseDecimal which panics on invalid numbers, it is
+// meant for constants
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// ParseDecimal reads a decimal number like "12.5", "-3" or "1e2". Numbers
+// with more than DecimalPlaces decimal places fail with ErrDecimalPlaces.
+func ParseDecimal(s string) (Decimal, error) {
+	return parseDecimal(s, false)
+}
+
+// parseDecimal rounds half away from zero instead of failing when round is set
+func parseDecimal(s string, round bool) (Decimal, error) {
+	units, err := parseFixed(s, DecimalPlaces, round)
+	if err == errTooManyPlaces {
+		err = ErrDecimalPlaces
+	}
+	return Decimal{units}, err
+}
+
+var errTooManyPlaces = errors.New("too many decimal places")
+
+// parseFixed reads a decimal number as a count of 10^-places, it fails with
+// errTooManyPlaces unless round is set
+func parseFixed(s string, places int, round bool) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || len(s) > 64 || strings.IndexFunc(s, func(c rune) bool { return !strings.ContainsRune("0123456789.+-eE", c) }) >= 0 {
+		return 0, ErrInvalidDecimal
+	}
+	// big.Rat would compute any power of ten
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		if exp, err := strconv.Atoi(s[i+1:]); err != nil || exp < -30 || exp > 30 {
+			return 0, ErrInvalidDecimal
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrInvalidDecimal
+	}
+	r.Mul(r, new(big.Rat).SetInt(pow10(places)))
+	if !r.IsInt() && !round {
+		return 0, errTooManyPlaces
+	}
+	units := roundRat(r)
+	if !units.IsInt64() {
+		return 0, ErrInvalidDecimal
+	}
+	return units.Int64(), nil
+}
+
+// roundRat rounds half away from zero
+func roundRat(r *big.Rat) *big.Int {
+	if r.IsInt() {
+		return new(big.Int).Set(r.Num())
+	}
+	half := big.NewRat(1, 2)
+	if r.Sign() < 0 {
+		half.Neg(half)
+	}
+	sum := new(big.Rat).Add(r, half)
+	return new(big.Int).Quo(sum.Num(), sum.Denom())
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// formatFixed formats a count of 10^-places without trailing zeros
+func formatFixed(units int64, places int) string {
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	unit := pow10(places).Int64()
+	s := sign + strconv.FormatInt(units/unit, 10)
+	if fraction := units % unit; fraction != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%0*d", places, fraction), "0")
+	}
+	return s
+}
+
+// String formats the number without trailing zeros, like "12.5"
+func (d Decimal) String() string {
+	return formatFixed(d.units, DecimalPlaces)
+}
+
+// Places returns the number of decimal places which aren't zero
+func (d Decimal) Places() int {
+	places := DecimalPlaces
+	for units := d.units; places > 0 && units%10 == 0; units /= 10 {
+		places--
+	}
+	return places
+}
+
+// Sign returns -1, 0 or 1
+func (d Decimal) Sign() int {
+	switch {
+	case d.units < 0:
+		return -1
+	case d.units > 0:
+		return 1
+	}
+	return 0
+}
+
+// Cmp returns -1, 0 or 1 when d is less than, equal to or greater than other
+func (d Decimal) Cmp(other Decimal) int {
+	switch {
+	case d.units < other.units:
+		return -1
+	case d.units > other.units:
+		return 1
+	}
+	return 0
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	return Decimal{-d.units}
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+	value, err := ParseDecimal(text)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: decimalErrorValue(err), Type: decimalType}
+	}
+	*d = value
+	return nil
+}
+
+// decimalErrorValue tells the decimal errors apart in a json.UnmarshalTypeError
+func decimalErrorValue(err error) string {
+	if errors.Is(err, ErrDecimalPlaces) {
+		return "decimal places"
+	}
+	return "number"
+}
+
+// decimalReason is the reason for ValidationErrors of a ParseDecimal error
+func decimalReason(err error) string {
+	if errors.Is(err, ErrDecimalPlaces) {
+		return fmt.Sprintf("must have at most %d decimal places", DecimalPlaces)
+	}
+	return "must be a number"
+}
+
+// DecimalErrors returns the ValidationErrors of a JSON decoding error of a
+// Decimal field, ok is false for other errors. The decoder doesn't always
+// report the field of errors from UnmarshalJSON, field is used then.
+func DecimalErrors(err error, field string) (errs ValidationErrors, ok bool) {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Type != decimalType {
+		return nil, false
+	}
+	if typeErr.Field != "" {
+		field = typeErr.Field
+	}
+	if typeErr.Value == "decimal places" {
+		return ValidationErrors{field: decimalReason(ErrDecimalPlaces)}, true
+	}
+	return ValidationErrors{field: decimalReason(ErrInvalidDecimal)}, true
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Decimal) Scan(value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case []byte:
+		*d, err = parseDecimal(string(v), true)
+	case string:
+		*d, err = parseDecimal(v, true)
+	case float64:
+		*d, err = parseDecimal(strconv.FormatFloat(v, 'f', -1, 64), true)
+	case int64:
+		*d = DecimalFromInt(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Decimal", value)
+	}
+	return err
+}
+
+// RatePlaces is the number of decimal places a Rate keeps, the columns are
+// DECIMAL(19,8)
+const RatePlaces = 8
+
+var ErrInvalidRate = fmt.Errorf("rate must be a positive number with at most %d decimal places", RatePlaces)
+
+// Rate is an exchange rate, a positive decimal number with RatePlaces decimal
+// places. It is written as a JSON number like Decimal.
+type Rate struct {
+	units int64 // in 10^-RatePlaces
+}
+
+var rateType = reflect.TypeOf(Rate{})
+
+// ParseRate reads a rate like "31.25", it fails with ErrInvalidRate
+func ParseRate(s string) (Rate, error) {
+	units, err := parseFixed(s, RatePlaces, false)
+	if err != nil || units <= 0 {
+		return Rate{}, ErrInvalidRate
+	}
+	return Rate{units}, nil
+}
+
+func (r Rate) String() string {
+	return formatFixed(r.units, RatePlaces)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+	value, err := ParseRate(text)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "rate", Type: rateType}
+	}
+	*r = value
+	return nil
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *Rate) Scan(value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case []byte:
+		r.units, err = parseFixed(string(v), RatePlaces, true)
+	case string:
+		r.units, err = parseFixed(v, RatePlaces, true)
+	case float64:
+		r.units, err = parseFixed(strconv.FormatFloat(v, 'f', -1, 64), RatePlaces, true)
+	default:
+		return fmt.Errorf("cannot scan %T into Rate", value)
+	}
+	return err
+}
+
+// Convert returns the amount times the rate, or divided by it when inverse is
+// set, rounded half away from zero to the decimal places
+func (d Decimal) Convert(rate Rate, inverse bool, places int) (Decimal, error) {
+	if rate.units <= 0 {
+		return Decimal{}, ErrInvalidRate
+	}
+	amount := new(big.Rat).SetInt64(d.units)
+	if inverse {
+		amount.Mul(amount, new(big.Rat).SetFrac(pow10(RatePlaces), big.NewInt(rate.units)))
+	} else {
+		amount.Mul(amount, new(big.Rat).SetFrac(big.NewInt(rate.units), pow10(RatePlaces)))
+	}
+	// round to the places and back to 10^-DecimalPlaces
+	if places > DecimalPlaces {
+		places = DecimalPlaces
+	}
+	step := pow10(DecimalPlaces - places)
+	units := roundRat(amount.Quo(amount, new(big.Rat).SetInt(step)))
+	units.Mul(units, step)
+	if !units.IsInt64() {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	return Decimal{units.Int64()}, nil
+}
+
+// DefaultCurrency is the currency of products which don't name one
+var DefaultCurrency = "USD"
+
+// currencyMinorUnits are the decimal places of the ISO 4217 currencies
+var currencyMinorUnits = map[string]int{
+	"AED": 2, "ARS": 2, "AUD": 2, "BGN": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2,
+	"CLP": 0, "CNY": 2, "COP": 2, "CZK": 2, "DKK": 2, "EGP": 2, "EUR": 2, "GBP": 2,
+	"HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0,
+	"KRW": 0, "KWD": 3, "MXN": 2, "MYR": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2,
+	"PLN": 2, "RON": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2,
+	"TWD": 2, "UAH": 2, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// CurrencyPlaces returns the decimal places of the currency, ok is false for
+// unknown currencies
+func CurrencyPlaces(currency string) (places int, ok bool) {
+	places, ok = currencyMinorUnits[currency]
+	return places, ok
+}
+
+// validateAmount checks that the amount is a valid amount of the currency,
+// it returns the reason for ValidationErrors or ""
+func validateAmount(amount Decimal, currency string) string {
+	places, ok := CurrencyPlaces(currency)
+	switch {
+	case amount.Sign() < 0:
+		return "must not be negative"
+	case !ok:
+		return ""
+	case amount.Places() > places && places == 0:
+		return "must be a whole amount of " + currency
+	case amount.Places() > places:
+		return fmt.Sprintf("must have at most %d decimal places for %s", places, currency)
+	}
+	return ""
+}
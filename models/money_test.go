@@ -0,0 +1,100 @@
+package models_test
+
+import (
+	"encoding/json"
+	"myapp/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDecimal(t *testing.T) {
+	for input, expected := range map[string]string{
+		"12.5":      "12.5",
+		"-3":        "-3",
+		"0.10":      "0.1",
+		"1e2":       "100",
+		"1.2345":    "1.2345",
+		" 7.000 ":   "7",
+		"0.0001":    "0.0001",
+		"123456789": "123456789",
+	} {
+		d, err := models.ParseDecimal(input)
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, expected, d.String(), input)
+		}
+	}
+
+	_, err := models.ParseDecimal("1.23456")
+	assert.ErrorIs(t, err, models.ErrDecimalPlaces)
+	for _, input := range []string{"", "abc", "1,5", "0x10", "1e999", "99999999999999999999"} {
+		_, err := models.ParseDecimal(input)
+		assert.ErrorIs(t, err, models.ErrInvalidDecimal, input)
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	var product struct {
+		Price models.Decimal `json:"price"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"price": 0.1}`), &product))
+	assert.Equal(t, "0.1", product.Price.String())
+	assert.NoError(t, json.Unmarshal([]byte(`{"price": "19.99"}`), &product))
+	assert.Equal(t, 2, product.Price.Places())
+
+	data, err := json.Marshal(product)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"price":19.99}`, string(data))
+
+	err = json.Unmarshal([]byte(`{"price": 1.00001}`), &product)
+	errs, ok := models.DecimalErrors(err, "price")
+	assert.True(t, ok)
+	assert.Equal(t, models.ValidationErrors{"price": "must have at most 4 decimal places"}, errs)
+
+	err = json.Unmarshal([]byte(`{"price": true}`), &product)
+	errs, ok = models.DecimalErrors(err, "price")
+	assert.True(t, ok)
+	assert.Equal(t, models.ValidationErrors{"price": "must be a number"}, errs)
+}
+
+func TestDecimalScan(t *testing.T) {
+	var d models.Decimal
+	assert.NoError(t, d.Scan([]byte("10.5000")))
+	assert.Equal(t, "10.5", d.String())
+	// float columns are rounded to the decimal places
+	assert.NoError(t, d.Scan(0.1+0.2))
+	assert.Equal(t, "0.3", d.String())
+	assert.NoError(t, d.Scan(int64(3)))
+	assert.Equal(t, models.DecimalFromInt(3), d)
+
+	value, err := models.MustParseDecimal("-2.25").Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "-2.25", value)
+}
+
+func TestCurrencyPlaces(t *testing.T) {
+	places, ok := models.CurrencyPlaces("EUR")
+	assert.True(t, ok)
+	assert.Equal(t, 2, places)
+	places, ok = models.CurrencyPlaces("JPY")
+	assert.True(t, ok)
+	assert.Equal(t, 0, places)
+	_, ok = models.CurrencyPlaces("XYZ")
+	assert.False(t, ok)
+}
+
+func TestDecimalConvert(t *testing.T) {
+	rate, err := models.ParseRate("0.03125")
+	assert.NoError(t, err)
+	converted, err := models.DecimalFromInt(100).Convert(rate, false, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "3.13", converted.String())
+	converted, err = models.MustParseDecimal("3.125").Convert(rate, true, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "100", converted.String())
+
+	for _, input := range []string{"0", "-1", "1.123456789", "abc"} {
+		_, err := models.ParseRate(input)
+		assert.ErrorIs(t, err, models.ErrInvalidRate, input)
+	}
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultPriceList is the code of the prices of the products themselves.
+// Named price lists fall back to it for the products they don't price.
+const DefaultPriceList = "default"
+
+var priceListCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// PriceList is a named set of product prices in one currency, like
+// retail-TWD or wholesale-USD
+type PriceList struct {
+	ID        int       `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	Code      string    `json:"code" gorm:"column:code;size:32;uniqueIndex;not null"`
+	Name      string    `json:"name" gorm:"column:name;size:255;not null"`
+	Currency  string    `json:"currency" gorm:"column:currency;size:3;not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// PriceListItem is the price of a product in a price list, in the currency of
+// the list
+type PriceListItem struct {
+	ID          int       `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	PriceListID int       `json:"price_list_id" gorm:"column:price_list_id;not null;uniqueIndex:idx_price_list_items_product,priority:1"`
+	ProductID   int       `json:"product_id" gorm:"column:product_id;not null;uniqueIndex:idx_price_list_items_product,priority:2"`
+	Price       Decimal   `json:"price" gorm:"column:price;type:decimal(19,4);not null"`
+	UpdatedBy   string    `json:"updated_by" gorm:"column:updated_by;size:64"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// Normalize trims the code and the name and uppercases the currency
+func (l *PriceList) Normalize() {
+	l.Code = strings.TrimSpace(l.Code)
+	l.Name = strings.TrimSpace(l.Name)
+	l.Currency = strings.ToUpper(strings.TrimSpace(l.Currency))
+}
+
+// Validate checks the price list, it returns ValidationErrors
+func (l *PriceList) Validate() error {
+	errs := ValidationErrors{}
+	switch {
+	case l.Code == "":
+		errs["code"] = "is required"
+	case len(l.Code) > 32:
+		errs["code"] = "must be at most 32 characters"
+	case !priceListCodePattern.MatchString(l.Code):
+		errs["code"] = "may only contain letters, digits, '.', '_' and '-'"
+	case strings.EqualFold(l.Code, DefaultPriceList):
+		errs["code"] = "is reserved for the prices of the products"
+	}
+	switch {
+	case l.Name == "":
+		errs["name"] = "is required"
+	case len(l.Name) > 255:
+		errs["name"] = "must be at most 255 characters"
+	}
+	if _, ok := CurrencyPlaces(l.Currency); !ok {
+		errs["currency"] = "must be an ISO 4217 currency code"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func GetPriceLists() ([]PriceList, error) {
+	lists := []PriceList{}
+	if err := DB.Order("code").Find(&lists).Error; err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// GetPriceList returns the price list with the code, it fails with
+// gorm.ErrRecordNotFound for unknown codes
+func GetPriceList(code string) (*PriceList, error) {
+	var list PriceList
+	if err := DB.Where("code = ?", code).First(&list).Error; err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GetPriceListItems returns the prices of the list ordered by product
+func GetPriceListItems(listID int) ([]PriceListItem, error) {
+	items := []PriceListItem{}
+	if err := DB.Where("price_list_id = ?", listID).Order("product_id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func CreatePriceList(ctx context.Context, list *PriceList) (int, error) {
+	list.Normalize()
+	if err := list.Validate(); err != nil {
+		return 0, err
+	}
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(list).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditPriceList, list.ID, nil, list)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return list.ID, nil
+}
+
+// SetListPrice sets the price of the product in the price list. It fails with
+// gorm.ErrRecordNotFound when the list or the product doesn't exist.
+func SetListPrice(ctx context.Context, code string, productID int, price Decimal) (*PriceListItem, error) {
+	var item PriceListItem
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var list PriceList
+		if err := tx.Where("code = ?", code).First(&list).Error; err != nil {
+			return err
+		}
+		if err := tx.Select("id").First(&Product{}, productID).Error; err != nil {
+			return err
+		}
+		if reason := validateAmount(price, list.Currency); reason != "" {
+			return ValidationErrors{"price": reason}
+		}
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("price_list_id = ? AND product_id = ?", list.ID, productID).First(&item).Error
+		var before map[string]interface{}
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			item = PriceListItem{PriceListID: list.ID, ProductID: productID}
+		case err != nil:
+			return err
+		default:
+			before = listPriceFields(&item)
+		}
+		item.Price, item.UpdatedBy = price, actorFrom(ctx).Username
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "set_price", AuditPriceList, list.ID, before, listPriceFields(&item))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// DeleteListPrice removes the price of the product from the price list, the
+// product falls back to the default price list again
+func DeleteListPrice(ctx context.Context, code string, productID int) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var list PriceList
+		if err := tx.Where("code = ?", code).First(&list).Error; err != nil {
+			return err
+		}
+		var item PriceListItem
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("price_list_id = ? AND product_id = ?", list.ID, productID).First(&item).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "remove_price", AuditPriceList, list.ID, listPriceFields(&item), nil)
+	})
+}
+
+// the item as it is shown in the audit trail of the price list
+func listPriceFields(item *PriceListItem) map[string]interface{} {
+	return map[string]interface{}{"product_id": item.ProductID, "price": item.Price}
+}
+
+// ListPrice is the price of a product read with a price list or a currency
+type ListPrice struct {
+	// the list the price comes from, DefaultPriceList when the product has no
+	// price in the requested list
+	PriceList string  `json:"price_list"`
+	Price     Decimal `json:"price"`
+	Currency  string  `json:"currency"`
+}
+
+// PriceSelection is the price list and the currency products are read in,
+// empty fields aren't selected
+type PriceSelection struct {
+	PriceList string
+	Currency  string
+}
+
+// AttachListPrices fills in the list price of the products. The price comes
+// from the selected list, or from the product when the list doesn't price it,
+// and is converted to the selected currency, or else to the currency of the
+// list. It fails with gorm.ErrRecordNotFound for an unknown price list and
+// with ErrNoExchangeRate when a currency can't be converted.
+func AttachListPrices(products []Product, selection PriceSelection) error {
+	if selection.PriceList == "" && selection.Currency == "" {
+		return nil
+	}
+
+	var list *PriceList
+	items := map[int]Decimal{}
+	if selection.PriceList != "" && selection.PriceList != DefaultPriceList {
+		var err error
+		if list, err = GetPriceList(selection.PriceList); err != nil {
+			return err
+		}
+		ids := make([]int, len(products))
+		for i := range products {
+			ids[i] = products[i].ID
+		}
+		var rows []PriceListItem
+		if err := DB.Where("price_list_id = ? AND product_id IN ?", list.ID, ids).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			items[row.ProductID] = row.Price
+		}
+	}
+	rates, err := loadExchangeRates(DB)
+	if err != nil {
+		return err
+	}
+
+	for i := range products {
+		price := ListPrice{PriceList: DefaultPriceList, Price: products[i].Price, Currency: products[i].Currency}
+		if listed, ok := items[products[i].ID]; ok {
+			price = ListPrice{PriceList: list.Code, Price: listed, Currency: list.Currency}
+		}
+		currency := selection.Currency
+		if currency == "" && list != nil {
+			currency = list.Currency
+		}
+		if currency != "" {
+			if price.Price, err = rates.convert(price.Price, price.Currency, currency); err != nil {
+				return err
+			}
+			price.Currency = currency
+		}
+		products[i].ListPrice = &price
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestAttachListPrices(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	products := []models.Product{
+		{ID: 1, Price: models.DecimalFromInt(10), Currency: "USD"},
+		{ID: 2, Price: models.MustParseDecimal("2.5"), Currency: "USD"},
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `price_lists` WHERE code = ? ORDER BY `price_lists`.`id` LIMIT ?")).
+		WithArgs("retail-TWD", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "currency"}).AddRow(3, "retail-TWD", "Retail Taiwan", "TWD"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `price_list_items` WHERE price_list_id = ? AND product_id IN (?,?)")).
+		WithArgs(3, 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "price_list_id", "product_id", "price"}).AddRow(1, 3, 1, "320.0000"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `exchange_rates`")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_currency", "to_currency", "rate"}).AddRow(1, "USD", "TWD", "31.50000000"))
+
+	err = models.AttachListPrices(products, models.PriceSelection{PriceList: "retail-TWD"})
+	assert.NoError(t, err)
+	assert.Equal(t, &models.ListPrice{PriceList: "retail-TWD", Price: models.DecimalFromInt(320), Currency: "TWD"}, products[0].ListPrice)
+	// not in the list, the price of the product is converted
+	assert.Equal(t, &models.ListPrice{PriceList: "default", Price: models.MustParseDecimal("78.75"), Currency: "TWD"}, products[1].ListPrice)
+
+	// the inverse of the opposite pair, rounded to the cents
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `exchange_rates`")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_currency", "to_currency", "rate"}).AddRow(2, "EUR", "USD", "1.08000000"))
+	err = models.AttachListPrices(products[:1], models.PriceSelection{Currency: "EUR"})
+	assert.NoError(t, err)
+	assert.Equal(t, &models.ListPrice{PriceList: "default", Price: models.MustParseDecimal("9.26"), Currency: "EUR"}, products[0].ListPrice)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `exchange_rates`")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_currency", "to_currency", "rate"}))
+	err = models.AttachListPrices(products[:1], models.PriceSelection{Currency: "JPY"})
+	assert.ErrorIs(t, err, models.ErrNoExchangeRate)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestSetListPriceInListCurrency(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `price_lists` WHERE code = ?")).
+		WithArgs("retail-JPY", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "currency"}).AddRow(4, "retail-JPY", "Retail Japan", "JPY"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	_, err = models.SetListPrice(alice, "retail-JPY", 1, models.MustParseDecimal("1200.5"))
+	assert.Equal(t, models.ValidationErrors{"price": "must be a whole amount of JPY"}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestPriceListValidate(t *testing.T) {
+	list := &models.PriceList{Code: " wholesale-USD ", Name: "Wholesale", Currency: "usd"}
+	list.Normalize()
+	assert.NoError(t, list.Validate())
+	assert.Equal(t, "wholesale-USD", list.Code)
+	assert.Equal(t, "USD", list.Currency)
+
+	invalid := &models.PriceList{Code: "Default", Currency: "dollar"}
+	assert.Equal(t, models.ValidationErrors{
+		"code":     "is reserved for the prices of the products",
+		"name":     "is required",
+		"currency": "must be an ISO 4217 currency code",
+	}, invalid.Validate())
+}
@@ -1,32 +1,295 @@
 package models
 
 import (
-	"gorm.io/driver/mysql"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"myapp/utils"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var DB *gorm.DB
 
+// ErrVersionConflict is returned when the product was changed since the
+// version the client based its write on
+var ErrVersionConflict = errors.New("product version conflict")
+
+var (
+	ErrProductNotDeleted = errors.New("product is not deleted")
+	// ErrProductReferenced is returned when a product can't be purged because
+	// the stock ledger, reservations, variants or kits still refer to it
+	ErrProductReferenced = errors.New("product is still referenced")
+)
+
+type ProductStatus string
+
+const (
+	ProductStatusDraft        ProductStatus = "draft"
+	ProductStatusActive       ProductStatus = "active"
+	ProductStatusDiscontinued ProductStatus = "discontinued"
+)
+
+func (s ProductStatus) Valid() bool {
+	switch s {
+	case ProductStatusDraft, ProductStatusActive, ProductStatusDiscontinued:
+		return true
+	}
+	return false
+}
+
+// ProductType tells simple products, which have stock of their own, from
+// kits, which are made of other products
+type ProductType string
+
+const (
+	ProductTypeSimple ProductType = "simple"
+	ProductTypeKit    ProductType = "kit"
+)
+
+func (t ProductType) Valid() bool {
+	switch t {
+	case ProductTypeSimple, ProductTypeKit:
+		return true
+	}
+	return false
+}
+
+const DefaultUnit = "pcs"
+
+// upper limit of the tags of a product
+const MaxProductTags = 20
+
 type Product struct {
-	ID    int     `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
-	Name  string  `json:"name" gorm:"column:name"`
-	Price float64 `json:"price" gorm:"column:price"`
+	ID          int           `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	SKU         string        `json:"sku" gorm:"column:sku;size:64;uniqueIndex"`
+	Name        string        `json:"name" gorm:"column:name"`
+	Description string        `json:"description" gorm:"column:description;size:2000"`
+	Unit        string        `json:"unit" gorm:"column:unit;size:16;default:pcs"`
+	Status      ProductStatus `json:"status" gorm:"column:status;size:16;default:active"`
+	Type        ProductType   `json:"type" gorm:"column:type;size:16;not null;default:simple"`
+	Price       Decimal       `json:"price" gorm:"column:price;type:decimal(19,4);not null"`
+	Currency    string        `json:"currency" gorm:"column:currency;size:3;not null;default:USD"` // ISO 4217
+	CategoryID  *int          `json:"category_id" gorm:"column:category_id;index"`
+	// the base product of a variant, set by GenerateVariants
+	ParentID    *int            `json:"parent_id" gorm:"column:parent_id;index"`
+	Attributes  AttributeValues `json:"attributes,omitempty" gorm:"column:attributes;type:json"`
+	Tags        StringList      `json:"tags,omitempty" gorm:"column:tags;size:1000"`
+	VariantAxes StringList      `json:"variant_axes,omitempty" gorm:"column:variant_axes;size:255"` // the attributes the variants differ in
+	Version     int             `json:"version" gorm:"column:version;not null;default:1"`           // incremented by every update
+	CreatedAt   time.Time       `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" gorm:"column:updated_at"`
+	// set by DeleteProduct, deleted products are hidden from every query
+	// which isn't Unscoped
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"column:deleted_at;index"`
+
+	Stock     *StockSummary `json:"stock,omitempty" gorm:"-"`      // filled in by AttachStock
+	ListPrice *ListPrice    `json:"list_price,omitempty" gorm:"-"` // filled in by AttachListPrices
+	Variants  []Product     `json:"variants,omitempty" gorm:"-"`   // filled in by AttachVariants
+	// the components of a kit, filled in by AttachComponents
+	Components []KitComponent `json:"components,omitempty" gorm:"-"`
+	Barcodes   []Barcode      `json:"barcodes,omitempty" gorm:"-"` // filled in by AttachBarcodes
+}
+
+// ValidationErrors maps the JSON field name to the reason it is invalid
+type ValidationErrors map[string]string
+
+func (e ValidationErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, reason := range e {
+		fields = append(fields, field+": "+reason)
+	}
+	return "validation failed: " + strings.Join(fields, ", ")
+}
+
+var (
+	skuPattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	unitPattern = regexp.MustCompile(`^[A-Za-z]+$`)
+)
+
+// Normalize trims the text fields and fills in the defaults
+func (p *Product) Normalize() {
+	p.SKU = strings.TrimSpace(p.SKU)
+	p.Name = strings.TrimSpace(p.Name)
+	p.Description = strings.TrimSpace(p.Description)
+	p.Unit = strings.TrimSpace(p.Unit)
+	if p.Unit == "" {
+		p.Unit = DefaultUnit
+	}
+	if p.Status == "" {
+		p.Status = ProductStatusActive
+	}
+	if p.Type == "" {
+		p.Type = ProductTypeSimple
+	}
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	// tags keep their first spelling, empty and repeated ones are dropped
+	if p.Tags != nil {
+		tags := StringList{}
+		seen := map[string]bool{}
+		for _, tag := range p.Tags {
+			tag = strings.TrimSpace(tag)
+			if tag != "" && !seen[strings.ToLower(tag)] {
+				seen[strings.ToLower(tag)] = true
+				tags = append(tags, tag)
+			}
+		}
+		p.Tags = tags
+	}
+}
+
+// Validate checks the fields of the product, it returns ValidationErrors
+func (p *Product) Validate() error {
+	errs := ValidationErrors{}
+	switch {
+	case p.SKU == "":
+		errs["sku"] = "is required"
+	case len(p.SKU) > 64:
+		errs["sku"] = "must be at most 64 characters"
+	case !skuPattern.MatchString(p.SKU):
+		errs["sku"] = "may only contain letters, digits, '.', '_' and '-'"
+	}
+	switch {
+	case p.Name == "":
+		errs["name"] = "is required"
+	case len(p.Name) > 255:
+		errs["name"] = "must be at most 255 characters"
+	}
+	if len(p.Description) > 2000 {
+		errs["description"] = "must be at most 2000 characters"
+	}
+	if len(p.Unit) > 16 || !unitPattern.MatchString(p.Unit) {
+		errs["unit"] = "must be 1-16 letters"
+	}
+	if !p.Status.Valid() {
+		errs["status"] = "must be one of draft, active, discontinued"
+	}
+	if !p.Type.Valid() {
+		errs["type"] = "must be simple or kit"
+	}
+	if _, ok := CurrencyPlaces(p.Currency); !ok {
+		errs["currency"] = "must be an ISO 4217 currency code"
+	}
+	if reason := validateAmount(p.Price, p.Currency); reason != "" {
+		errs["price"] = reason
+	}
+	if len(p.Tags) > MaxProductTags {
+		errs["tags"] = fmt.Sprintf("must be at most %d", MaxProductTags)
+	}
+	for _, tag := range p.Tags {
+		switch {
+		case len(tag) > 32:
+			errs["tags"] = fmt.Sprintf("%q must be at most 32 characters", tag)
+		case strings.Contains(tag, ","):
+			errs["tags"] = fmt.Sprintf("%q must not contain ','", tag)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 // init database
 func InitDB(dsn string) (*gorm.DB, error) {
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	dialector, err := openDialector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
-	db.AutoMigrate(&Product{})
+	if err := migrateFloatPrices(db); err != nil {
+		return nil, err
+	}
+	if err := db.AutoMigrate(&Product{}, &User{}, &RefreshToken{}, &RevokedToken{}, &APIKey{},
+		&Warehouse{}, &StockLevel{}, &StockMovement{}, &Reservation{}, &IdempotencyKey{}, &AuditEntry{}, &ProductPrice{},
+		&PriceList{}, &PriceListItem{}, &ExchangeRate{}, &Category{}, &Attribute{}, &KitComponent{}, &Barcode{}); err != nil {
+		return nil, err
+	}
+	if err := backfillProductSKUs(db); err != nil {
+		return nil, err
+	}
+	if err := backfillProductPrices(db); err != nil {
+		return nil, err
+	}
 
 	// global DB
 	DB = db
 	return db, nil
 }
 
+// migrateFloatPrices converts the FLOAT prices of tables created before
+// prices were decimals. The prices are rounded to DefaultCurrency, which is
+// the currency of the existing rows.
+func migrateFloatPrices(db *gorm.DB) error {
+	for _, model := range []interface{}{&Product{}, &ProductPrice{}} {
+		migrator := db.Migrator()
+		if !migrator.HasTable(model) {
+			continue
+		}
+		columns, err := migrator.ColumnTypes(model)
+		if err != nil {
+			return err
+		}
+		for _, column := range columns {
+			if column.Name() != "price" {
+				continue
+			}
+			switch strings.ToUpper(column.DatabaseTypeName()) {
+			case "FLOAT", "DOUBLE", "REAL":
+			default:
+				continue
+			}
+			if err := migrator.AlterColumn(model, "Price"); err != nil {
+				return err
+			}
+			if !migrator.HasColumn(model, "Currency") {
+				if err := migrator.AddColumn(model, "Currency"); err != nil {
+					return err
+				}
+			}
+			places, _ := CurrencyPlaces(DefaultCurrency)
+			err := db.Unscoped().Model(model).Where("1 = 1").UpdateColumns(map[string]interface{}{
+				"price":    gorm.Expr("ROUND(price, ?)", places),
+				"currency": DefaultCurrency,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// products created before the sku column existed get a generated sku
+func backfillProductSKUs(db *gorm.DB) error {
+	var ids []int
+	if err := db.Model(&Product{}).Where("sku IS NULL OR sku = ''").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := db.Model(&Product{}).Where("id = ?", id).Update("sku", fmt.Sprintf("LEGACY-%d", id)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func GetAllProducts() ([]Product, error) {
 	var products []Product
 	if err := DB.Find(&products).Error; err != nil {
@@ -36,48 +299,379 @@ func GetAllProducts() ([]Product, error) {
 }
 
 func GetProductByID(id int) (*Product, error) {
-	var product Product
-	if err := DB.First(&product, id).Error; err != nil {
+	products := make([]Product, 1)
+	if err := DB.First(&products[0], id).Error; err != nil {
 		return nil, err
 	}
-	return &product, nil
+	if err := resolveEffectivePrices(products); err != nil {
+		return nil, err
+	}
+	return &products[0], nil
 }
 
-func CreateProduct(product *Product) (int, error) {
-	if err := DB.Create(product).Error; err != nil {
+// CreateProduct creates a product, variants are only made by GenerateVariants
+func CreateProduct(ctx context.Context, product *Product) (int, error) {
+	product.ParentID, product.VariantAxes = nil, nil
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return createProduct(tx, product)
+	})
+	markSearchStale(product.ID)
+	if err != nil {
 		return 0, err
 	}
 	return product.ID, nil
 }
 
-func DeleteProduct(id int) (int, error) {
+func createProduct(tx *gorm.DB, product *Product) error {
+	product.Version = 1
+	product.DeletedAt = gorm.DeletedAt{}
+	if err := checkProductCategory(tx, product); err != nil {
+		return err
+	}
+	if err := checkProductAttributes(tx, product); err != nil {
+		return err
+	}
+	if err := tx.Create(product).Error; err != nil {
+		return trashedSKUError(tx, product.SKU, err)
+	}
+	if err := recordPrice(tx, product); err != nil {
+		return err
+	}
+	return recordAudit(tx, "create", AuditProduct, product.ID, nil, product)
+}
+
+// DeleteProduct moves the product to the trash if it is still at the version,
+// version 0 deletes any version. It returns 0 when there is no such product.
+func DeleteProduct(ctx context.Context, id int, version int) (int, error) {
 	if DB == nil {
 		return 0, DB.Error
 	}
+	var deleted int
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		deleted, err = deleteProduct(tx, id, version)
+		return err
+	})
+	markSearchStale(id)
+	return deleted, err
+}
 
+// deleteProduct moves the product to the trash, it returns 0 when there is
+// no such product. The trail keeps the product as it was deleted.
+func deleteProduct(tx *gorm.DB, id int, version int) (int, error) {
 	var product Product
-	result := DB.Delete(&product, id)
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if version != 0 && product.Version != version {
+		return 0, ErrVersionConflict
+	}
+	result := tx.Delete(&Product{}, id)
 	if result.Error != nil {
 		return 0, result.Error
 	}
+	if err := recordAudit(tx, "delete", AuditProduct, id, &product, nil); err != nil {
+		return 0, err
+	}
 	return int(result.RowsAffected), nil
 }
 
-func UpdateProduct(id uint, updatedData *Product) error {
+// RestoreProduct takes the product out of the trash, the restored product
+// gets a new version
+func RestoreProduct(ctx context.Context, id int) (*Product, error) {
 	var product Product
-	if err := DB.First(&product, id).Error; err != nil {
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().First(&product, id).Error; err != nil {
+			return err
+		}
+		if !product.DeletedAt.Valid {
+			return ErrProductNotDeleted
+		}
+
+		before := product
+		product.DeletedAt = gorm.DeletedAt{}
+		product.Version++
+		result := tx.Unscoped().Model(&product).Where("version = ?", before.Version).
+			Select("deleted_at", "version", "updated_at").Updates(&product)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		return recordAudit(tx, "restore", AuditProduct, id, &before, &product)
+	})
+	markSearchStale(id)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// PurgeProduct removes a product from the trash for good. Products which
+// appear in the stock ledger or in reservations are kept for their history.
+func PurgeProduct(ctx context.Context, id int) error {
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var product Product
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
+			return err
+		}
+		if !product.DeletedAt.Valid {
+			return ErrProductNotDeleted
+		}
+
+		// variants keep their base product
+		if len(product.VariantAxes) > 0 {
+			var count int64
+			if err := tx.Unscoped().Model(&Product{}).Where("parent_id = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrProductReferenced
+			}
+		}
+		for _, ref := range []struct {
+			model  interface{}
+			column string
+		}{{&StockMovement{}, "product_id"}, {&Reservation{}, "product_id"}, {&KitComponent{}, "component_id"}} {
+			var count int64
+			if err := tx.Model(ref.model).Where(ref.column+" = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrProductReferenced
+			}
+		}
+
+		// without movements the stock levels are empty rows
+		if err := tx.Where("product_id = ?", id).Delete(&StockLevel{}).Error; err != nil {
+			return err
+		}
+		if product.Type == ProductTypeKit {
+			if err := tx.Where("kit_id = ?", id).Delete(&KitComponent{}).Error; err != nil {
+				return err
+			}
+		}
+		// purging frees the barcodes of the product
+		if err := tx.Where("product_id = ?", id).Delete(&Barcode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&product).Error; err != nil {
+			return err
+		}
+		// the last state of the product is kept in the trail
+		return recordAudit(tx, "purge", AuditProduct, id, &product, nil)
+	})
+	markSearchStale(id)
+	return err
+}
+
+// UpdateProduct replaces every writable field of the product, fields missing
+// from the data are reset to their defaults. The product must still be at the
+// version, version 0 updates any version.
+func UpdateProduct(ctx context.Context, id uint, data *Product, version int) (*Product, error) {
+	var product *Product
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		product, err = updateProduct(tx, id, data, version)
 		return err
+	})
+	markSearchStale(int(id))
+	return product, err
+}
+
+func updateProduct(tx *gorm.DB, id uint, data *Product, version int) (*Product, error) {
+	product, err := getProductVersion(tx, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	before := *product
+	product.SKU = data.SKU
+	product.Name = data.Name
+	product.Description = data.Description
+	product.Unit = data.Unit
+	product.Status = data.Status
+	product.Type = data.Type
+	product.Price = data.Price
+	product.Currency = data.Currency
+	product.CategoryID = data.CategoryID
+	product.Attributes = data.Attributes
+	product.Tags = data.Tags
+	return saveProduct(tx, &before, product)
+}
+
+// fields of the product which are maintained by the server
+var readOnlyProductFields = []string{"id", "version", "created_at", "updated_at", "deleted_at", "stock", "list_price",
+	"parent_id", "variant_axes", "variants", "components", "barcodes"}
+
+// PatchProduct applies a JSON Merge Patch (RFC 7396) to the product, only the
+// fields present in the patch are changed and null resets a field
+func PatchProduct(ctx context.Context, id uint, patch []byte, version int) (*Product, error) {
+	// malformed patches don't need a transaction
+	if err := checkProductPatch(patch); err != nil {
+		return nil, err
 	}
+	var product *Product
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		product, err = patchProduct(tx, id, patch, version)
+		return err
+	})
+	markSearchStale(int(id))
+	return product, err
+}
 
-	if updatedData.Name != "" {
-		product.Name = updatedData.Name
+// patchProduct expects a patch that passed checkProductPatch
+func patchProduct(tx *gorm.DB, id uint, patch []byte, version int) (*Product, error) {
+	product, err := getProductVersion(tx, id, version)
+	if err != nil {
+		return nil, err
 	}
-	if updatedData.Price != 0 {
-		product.Price = updatedData.Price
+	updated, err := applyProductPatch(product, patch)
+	if err != nil {
+		return nil, err
 	}
+	return saveProduct(tx, product, updated)
+}
 
-	if err := DB.Save(&product).Error; err != nil {
-		return err
+// checkProductPatch rejects patches which aren't JSON objects or which
+// change read-only fields
+func checkProductPatch(patch []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil || members == nil {
+		return utils.ErrInvalidMergePatch
+	}
+	errs := ValidationErrors{}
+	for _, field := range readOnlyProductFields {
+		if _, ok := members[field]; ok {
+			errs[field] = "is read-only"
+		}
+	}
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
+
+// applyProductPatch returns a copy of the product with the merge patch
+// applied, the read-only fields are kept
+func applyProductPatch(product *Product, patch []byte) (*Product, error) {
+	current, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := utils.MergePatch(current, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	updated := Product{}
+	if err := decoder.Decode(&updated); err != nil {
+		return nil, patchDecodeError(err)
+	}
+	updated.ID, updated.Version, updated.CreatedAt, updated.DeletedAt = product.ID, product.Version, product.CreatedAt, product.DeletedAt
+	return &updated, nil
+}
+
+// patchDecodeError turns a decoding error of the patched product into the
+// ValidationErrors of the offending field
+func patchDecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		// price is the only Decimal of a product
+		if errs, ok := DecimalErrors(err, "price"); ok {
+			return errs
+		}
+		switch typeErr.Type.Kind() {
+		case reflect.Float64:
+			return ValidationErrors{typeErr.Field: decimalReason(ErrInvalidDecimal)}
+		case reflect.Int:
+			return ValidationErrors{typeErr.Field: "must be an integer"}
+		case reflect.Map:
+			return ValidationErrors{typeErr.Field: "must be an object"}
+		}
+		return ValidationErrors{typeErr.Field: "must be a string"}
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return ValidationErrors{strings.Trim(field, `"`): "is not a product field"}
+	}
+	return err
+}
+
+func getProductVersion(tx *gorm.DB, id uint, version int) (*Product, error) {
+	var product Product
+	if err := tx.First(&product, id).Error; err != nil {
+		return nil, err
+	}
+	if version != 0 && product.Version != version {
+		return nil, ErrVersionConflict
+	}
+	return &product, nil
+}
+
+// columns written by saveProduct, id and created_at never change
+var productWriteColumns = []string{"sku", "name", "description", "unit", "status", "type", "price", "currency", "category_id",
+	"attributes", "tags", "variant_axes", "version", "updated_at"}
+
+// saveProduct validates and writes the product loaded at product.Version,
+// before is the product as it was loaded
+func saveProduct(tx *gorm.DB, before, product *Product) (*Product, error) {
+	product.Normalize()
+	if err := product.Validate(); err != nil {
+		return nil, err
+	}
+	if err := writeProduct(tx, before, product); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// writeProduct updates the product and increments its version, the update
+// only applies if nobody else has changed the product since it was loaded.
+// The change from before is recorded in the audit trail and a new price in
+// the price history.
+func writeProduct(tx *gorm.DB, before, product *Product) error {
+	if !equalIntPointers(product.CategoryID, before.CategoryID) {
+		if err := checkProductCategory(tx, product); err != nil {
+			return err
+		}
+	}
+	if (product.Type == ProductTypeKit) != (before.Type == ProductTypeKit) {
+		if err := checkProductTypeChange(tx, product); err != nil {
+			return err
+		}
+	}
+	if !reflect.DeepEqual(product.Attributes, before.Attributes) {
+		if err := checkProductAttributes(tx, product); err != nil {
+			return err
+		}
+		if err := checkVariantOptions(tx, before, product); err != nil {
+			return err
+		}
+	}
+	loaded := product.Version
+	product.Version++
+	result := tx.Model(product).Where("version = ?", loaded).Select(productWriteColumns).Updates(product)
+	if result.Error != nil {
+		return trashedSKUError(tx, product.SKU, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	if product.Price != before.Price || product.Currency != before.Currency {
+		if err := recordPrice(tx, product); err != nil {
+			return err
+		}
+		if err := followBasePrice(tx, before, product); err != nil {
+			return err
+		}
+	}
+	return recordAudit(tx, "update", AuditProduct, product.ID, before, product)
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// upper limit of the operations of a single batch
+var MaxBatchOperations = 100
+
+var ErrBatchNotApplied = errors.New("not applied because another operation of the batch failed")
+
+type BatchOp string
+
+const (
+	BatchCreate BatchOp = "create"
+	BatchUpdate BatchOp = "update" // full replacement like PUT
+	BatchPatch  BatchOp = "patch"  // JSON Merge Patch like PATCH
+	BatchDelete BatchOp = "delete"
+)
+
+// BatchOperation is one write of a batch. Update, patch and delete name the
+// version they are based on like the If-Match header of the single requests.
+type BatchOperation struct {
+	Op      BatchOp         `json:"op"`
+	ID      uint            `json:"id"`
+	Version int             `json:"version"`
+	Product *Product        `json:"product"`
+	Patch   json.RawMessage `json:"patch"`
+}
+
+type BatchResult struct {
+	Product *Product // nil for deletes
+	Err     error
+}
+
+func (op *BatchOperation) Validate() error {
+	errs := ValidationErrors{}
+	switch op.Op {
+	case BatchCreate:
+	case BatchUpdate, BatchPatch, BatchDelete:
+		if op.ID == 0 {
+			errs["id"] = "is required"
+		}
+		if op.Version < 1 {
+			errs["version"] = "is required"
+		}
+	default:
+		errs["op"] = "must be one of create, update, patch, delete"
+	}
+	if (op.Op == BatchCreate || op.Op == BatchUpdate) && op.Product == nil {
+		errs["product"] = "is required"
+	}
+	if op.Op == BatchPatch && len(op.Patch) == 0 {
+		errs["patch"] = "is required"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// RunProductBatch runs the operations in order. Atomic batches run in one
+// transaction which is rolled back when an operation fails, the other
+// operations then fail with ErrBatchNotApplied. Otherwise every operation is
+// applied on its own. committed is false when an atomic batch was rolled back.
+func RunProductBatch(ctx context.Context, ops []BatchOperation, atomic bool) (results []BatchResult, committed bool, err error) {
+	db := DB.WithContext(ctx)
+	results = make([]BatchResult, len(ops))
+	defer func() {
+		for i := range ops {
+			markSearchStale(int(ops[i].ID))
+			if results != nil && results[i].Product != nil {
+				markSearchStale(results[i].Product.ID)
+			}
+		}
+	}()
+	if !atomic {
+		for i := range ops {
+			if results[i].Err = ops[i].Validate(); results[i].Err != nil {
+				continue
+			}
+			// a failed operation rolls back its own transaction only
+			err := db.Transaction(func(tx *gorm.DB) error {
+				results[i] = runBatchOperation(tx, &ops[i])
+				return results[i].Err
+			})
+			if err != nil && results[i].Err == nil {
+				// the commit failed
+				results[i] = BatchResult{Err: err}
+			}
+		}
+		return results, true, nil
+	}
+
+	// operations are checked before the transaction is started
+	for i := range ops {
+		results[i].Err = ops[i].Validate()
+	}
+	if failBatch(results) {
+		return results, false, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := range ops {
+			results[i] = runBatchOperation(tx, &ops[i])
+			if results[i].Err != nil {
+				return errBatchFailed
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchFailed) {
+		failBatch(results)
+		return results, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return results, true, nil
+}
+
+var errBatchFailed = errors.New("batch failed")
+
+// failBatch marks every operation without an error of its own as not applied,
+// it reports whether any operation failed
+func failBatch(results []BatchResult) bool {
+	failed := false
+	for _, result := range results {
+		if result.Err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = BatchResult{Err: ErrBatchNotApplied}
+			}
+		}
+	}
+	return failed
+}
+
+func runBatchOperation(tx *gorm.DB, op *BatchOperation) BatchResult {
+	if err := op.Validate(); err != nil {
+		return BatchResult{Err: err}
+	}
+
+	switch op.Op {
+	case BatchCreate:
+		product := *op.Product
+		product.ID, product.ParentID, product.VariantAxes = 0, nil, nil
+		product.Normalize()
+		if err := product.Validate(); err != nil {
+			return BatchResult{Err: err}
+		}
+		if err := createProduct(tx, &product); err != nil {
+			return BatchResult{Err: err}
+		}
+		return BatchResult{Product: &product}
+	case BatchUpdate:
+		product, err := updateProduct(tx, op.ID, op.Product, op.Version)
+		return BatchResult{Product: product, Err: err}
+	case BatchPatch:
+		if err := checkProductPatch(op.Patch); err != nil {
+			return BatchResult{Err: err}
+		}
+		product, err := patchProduct(tx, op.ID, op.Patch, op.Version)
+		return BatchResult{Product: product, Err: err}
+	default:
+		deleted, err := deleteProduct(tx, int(op.ID), op.Version)
+		if err == nil && deleted == 0 {
+			err = gorm.ErrRecordNotFound
+		}
+		return BatchResult{Err: err}
+	}
+}
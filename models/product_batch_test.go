@@ -0,0 +1,133 @@
+package models_test
+
+import (
+	"encoding/json"
+	"errors"
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestRunProductBatchAtomicRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `products`")).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "10", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	expectPrice(mock, 5, "10")
+	expectAudit(mock, "create", "product", 5, sqlmock.AnyArg())
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "version"}).
+			AddRow(2, "BAN-002", "BANANA", 3.0, 4))
+	mock.ExpectRollback()
+
+	ops := []models.BatchOperation{
+		{Op: models.BatchCreate, Product: &models.Product{SKU: "APP-001", Name: "APPLE", Price: models.DecimalFromInt(10)}},
+		{Op: models.BatchPatch, ID: 2, Version: 3, Patch: json.RawMessage(`{"price":4}`)},
+	}
+	results, committed, err := models.RunProductBatch(alice, ops, true)
+	assert.NoError(t, err)
+	assert.False(t, committed)
+	if assert.Len(t, results, 2) {
+		assert.True(t, errors.Is(results[0].Err, models.ErrBatchNotApplied))
+		assert.Nil(t, results[0].Product)
+		assert.True(t, errors.Is(results[1].Err, models.ErrVersionConflict))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestRunProductBatchAtomicWithInvalidOperation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// nothing reaches the database
+	ops := []models.BatchOperation{
+		{Op: models.BatchDelete, ID: 1, Version: 1},
+		{Op: "rename"},
+		{Op: models.BatchUpdate, ID: 2},
+	}
+	results, committed, err := models.RunProductBatch(alice, ops, true)
+	assert.NoError(t, err)
+	assert.False(t, committed)
+	assert.Equal(t, []models.BatchResult{
+		{Err: models.ErrBatchNotApplied},
+		{Err: models.ValidationErrors{"op": "must be one of create, update, patch, delete"}},
+		{Err: models.ValidationErrors{"version": "is required", "product": "is required"}},
+	}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestRunProductBatchIndependent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).AddRow(4, "APP-001", "APPLE", "simple", "10", "USD", 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `deleted_at`=? WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "delete", "product", 4, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	// the missing product does not stop the second delete
+	ops := []models.BatchOperation{
+		{Op: models.BatchDelete, ID: 3, Version: 1},
+		{Op: models.BatchDelete, ID: 4, Version: 2},
+	}
+	results, committed, err := models.RunProductBatch(alice, ops, false)
+	assert.NoError(t, err)
+	assert.True(t, committed)
+	assert.Equal(t, []models.BatchResult{{Err: gorm.ErrRecordNotFound}, {}}, results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,441 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrTooManyImportRows = errors.New("too many rows")
+
+// upper limit of the rows of a single import
+var MaxImportRows = 10000
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+// ProductCSVColumns are the columns of an export, an import accepts any of
+// them in any order. The cells of tags and attributes hold JSON.
+var ProductCSVColumns = []string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency", "category_id", "tags", "attributes", "version", "created_at", "updated_at"}
+
+// the JSON columns of a CSV file and what their cells must hold
+var csvJSONColumns = map[string]string{"tags": "must be a JSON array", "attributes": "must be a JSON object"}
+
+// ImportRow is one product of an import file as JSON object, Line is the
+// line in the file
+type ImportRow struct {
+	Line   int
+	Fields map[string]interface{}
+	Err    ValidationErrors // the row could not be read
+}
+
+type ImportOptions struct {
+	DryRun bool // validate only, nothing is written
+	Upsert bool // update the product with the same SKU instead of failing
+	Atomic bool // import every row or none
+}
+
+type ImportAction string
+
+const (
+	ImportCreated ImportAction = "created"
+	ImportUpdated ImportAction = "updated"
+	ImportFailed  ImportAction = "failed"
+)
+
+type ImportRowResult struct {
+	Line   int              `json:"line"`
+	SKU    string           `json:"sku,omitempty"`
+	Action ImportAction     `json:"action"`
+	ID     int              `json:"id,omitempty"`
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
+
+// ImportReport lists the outcome of every row. When nothing was committed the
+// actions tell what the import would have done.
+type ImportReport struct {
+	DryRun    bool              `json:"dry_run"`
+	Atomic    bool              `json:"atomic"`
+	Committed bool              `json:"committed"` // false when nothing was written
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+// ReadImportRows reads a CSV file with a header line or a file with one JSON
+// object per line. Empty CSV cells are left out of the row.
+func ReadImportRows(r io.Reader, format string) ([]ImportRow, error) {
+	switch format {
+	case ImportFormatCSV:
+		return readCSVRows(r)
+	case ImportFormatNDJSON:
+		return readNDJSONRows(r)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+func readCSVRows(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, column := range ProductCSVColumns {
+		known[column] = true
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if !known[header[i]] {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == MaxImportRows {
+			return nil, ErrTooManyImportRows
+		}
+		line, _ := reader.FieldPos(0)
+		row := ImportRow{Line: line, Fields: map[string]interface{}{}}
+		if len(record) > len(header) {
+			row.Err = ValidationErrors{"row": fmt.Sprintf("has %d fields, the header has %d", len(record), len(header))}
+		}
+		for i, value := range record {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			if header[i] == "price" {
+				value = strings.TrimSpace(value)
+				if _, err := ParseDecimal(value); err != nil {
+					row.Err = ValidationErrors{"price": decimalReason(err)}
+					continue
+				}
+				// the exact digits, a float64 could round them
+				row.Fields["price"] = json.Number(value)
+				continue
+			}
+			if header[i] == "category_id" {
+				value = strings.TrimSpace(value)
+				if _, err := strconv.Atoi(value); err != nil {
+					row.Err = ValidationErrors{"category_id": "must be an integer"}
+					continue
+				}
+				row.Fields["category_id"] = json.Number(value)
+				continue
+			}
+			if reason, ok := csvJSONColumns[header[i]]; ok {
+				decoder := json.NewDecoder(strings.NewReader(value))
+				decoder.UseNumber()
+				var decoded interface{}
+				if err := decoder.Decode(&decoded); err != nil || !atEOF(decoder) {
+					row.Err = ValidationErrors{header[i]: reason}
+					continue
+				}
+				row.Fields[header[i]] = decoded
+				continue
+			}
+			row.Fields[header[i]] = value
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readNDJSONRows(r io.Reader) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var rows []ImportRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, ErrTooManyImportRows
+		}
+		row := ImportRow{Line: line}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		if err := decoder.Decode(&row.Fields); err != nil || row.Fields == nil || !atEOF(decoder) {
+			row.Err = ValidationErrors{"row": "must be a JSON object"}
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// atEOF tells whether nothing but whitespace follows the decoded value
+func atEOF(decoder *json.Decoder) bool {
+	_, err := decoder.Token()
+	return err == io.EOF
+}
+
+// plannedImport is a validated row and the product it will write, before is
+// the existing product an update replaces
+type plannedImport struct {
+	result  *ImportRowResult
+	before  *Product
+	product *Product
+}
+
+// ImportProducts validates every row first and then writes the valid ones.
+// In atomic mode nothing is written when a row fails, otherwise every valid
+// row is written on its own.
+func ImportProducts(ctx context.Context, rows []ImportRow, opts ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{DryRun: opts.DryRun, Atomic: opts.Atomic, Rows: make([]ImportRowResult, len(rows))}
+
+	existing, err := productsBySKU(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var categories *categoryTree
+	var attributes map[string]*Attribute
+
+	var planned []plannedImport
+	seen := map[string]int{}
+	for i, row := range rows {
+		result := &report.Rows[i]
+		result.Line = row.Line
+		product, errs := planImportRow(row, existing, opts.Upsert)
+		if errs == nil && product.CategoryID != nil {
+			// checked up front so that a dry run reports unknown categories
+			if categories == nil {
+				if categories, err = loadCategoryTree(DB); err != nil {
+					return nil, err
+				}
+			}
+			if categories.byID[*product.CategoryID] == nil {
+				errs = ValidationErrors{"category_id": "unknown category"}
+			}
+		}
+		if errs == nil && len(product.Attributes) > 0 {
+			if attributes == nil {
+				if attributes, err = allAttributes(DB); err != nil {
+					return nil, err
+				}
+			}
+			errs = validateAttributes(product.Attributes, attributes)
+		}
+		if product != nil {
+			result.SKU = product.SKU
+			if line, ok := seen[product.SKU]; ok && errs == nil {
+				errs = ValidationErrors{"sku": fmt.Sprintf("is already used on line %d", line)}
+			}
+			if errs == nil {
+				seen[product.SKU] = row.Line
+			}
+		}
+		if errs != nil {
+			result.Action, result.Errors = ImportFailed, errs
+			continue
+		}
+		p := plannedImport{result: result, product: product}
+		result.Action, result.ID = ImportCreated, product.ID
+		if product.ID != 0 {
+			result.Action, p.before = ImportUpdated, existing[product.SKU]
+		}
+		planned = append(planned, p)
+	}
+
+	failed := len(rows) - len(planned)
+	if opts.DryRun || (opts.Atomic && failed > 0) {
+		report.count()
+		return report, nil
+	}
+
+	if opts.Atomic {
+		err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for _, p := range planned {
+				if err := writeImportedProduct(tx, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		for _, p := range planned {
+			markSearchStale(p.product.ID)
+		}
+		if err != nil && !errors.Is(err, errImportRowFailed) {
+			return nil, err
+		}
+		report.Committed = err == nil
+		if !report.Committed {
+			// the ids of the rolled back rows were never committed
+			for _, p := range planned {
+				if p.result.Action == ImportCreated {
+					p.result.ID = 0
+				}
+			}
+		}
+	} else {
+		for _, p := range planned {
+			err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return writeImportedProduct(tx, p)
+			})
+			markSearchStale(p.product.ID)
+			if err != nil && !errors.Is(err, errImportRowFailed) {
+				return nil, err
+			}
+		}
+		report.Committed = true
+	}
+	report.count()
+	return report, nil
+}
+
+// errImportRowFailed means the failure was recorded in the row result
+var errImportRowFailed = errors.New("import row failed")
+
+func writeImportedProduct(tx *gorm.DB, p plannedImport) error {
+	var err error
+	if p.product.ID == 0 {
+		err = createProduct(tx, p.product)
+	} else {
+		err = writeProduct(tx, p.before, p.product)
+	}
+
+	var errs ValidationErrors
+	switch {
+	case err == nil:
+		p.result.ID = p.product.ID
+		return nil
+	case errors.As(err, &errs):
+		// the category was deleted since the rows were checked
+		p.result.Errors = errs
+	case errors.As(err, new(*TrashedSKUError)):
+		p.result.Errors = ValidationErrors{"sku": err.Error()}
+	case IsDuplicateKeyError(err):
+		p.result.Errors = ValidationErrors{"sku": "already exists"}
+	case errors.Is(err, ErrVersionConflict):
+		p.result.Errors = ValidationErrors{"sku": "the product was changed during the import"}
+	default:
+		return err
+	}
+	p.result.Action = ImportFailed
+	return errImportRowFailed
+}
+
+func (r *ImportReport) count() {
+	r.Created, r.Updated, r.Failed = 0, 0, 0
+	for _, row := range r.Rows {
+		switch row.Action {
+		case ImportCreated:
+			r.Created++
+		case ImportUpdated:
+			r.Updated++
+		case ImportFailed:
+			r.Failed++
+		}
+	}
+}
+
+// fields of an export which are ignored by an import
+var ignoredImportFields = []string{"id", "version", "created_at", "updated_at", "deleted_at", "stock", "list_price",
+	"parent_id", "variant_axes", "variants", "components", "barcodes"}
+
+// planImportRow builds the product of the row, a new one or the existing
+// product with the same SKU with the fields of the row applied
+func planImportRow(row ImportRow, existing map[string]*Product, upsert bool) (*Product, ValidationErrors) {
+	if row.Err != nil {
+		return nil, row.Err
+	}
+	for _, field := range ignoredImportFields {
+		delete(row.Fields, field)
+	}
+	sku, _ := row.Fields["sku"].(string)
+	sku = strings.TrimSpace(sku)
+
+	base := &Product{}
+	if current, ok := existing[sku]; ok && sku != "" {
+		if current.DeletedAt.Valid {
+			return &Product{SKU: sku}, ValidationErrors{"sku": (&TrashedSKUError{ProductID: current.ID}).Error()}
+		}
+		if !upsert {
+			return &Product{SKU: sku}, ValidationErrors{"sku": "already exists"}
+		}
+		base = current
+	}
+
+	patch, err := json.Marshal(row.Fields)
+	if err != nil {
+		return nil, ValidationErrors{"row": err.Error()}
+	}
+	product, err := applyProductPatch(base, patch)
+	if err != nil {
+		var errs ValidationErrors
+		if errors.As(err, &errs) {
+			return &Product{SKU: sku}, errs
+		}
+		return &Product{SKU: sku}, ValidationErrors{"row": err.Error()}
+	}
+
+	product.Normalize()
+	if err := product.Validate(); err != nil {
+		return product, err.(ValidationErrors)
+	}
+	return product, nil
+}
+
+// productsBySKU loads the products whose SKU appears in the rows, the ones
+// in the trash too since they keep their SKU
+func productsBySKU(rows []ImportRow) (map[string]*Product, error) {
+	var skus []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		sku, _ := row.Fields["sku"].(string)
+		if sku = strings.TrimSpace(sku); sku != "" && !seen[sku] {
+			seen[sku] = true
+			skus = append(skus, sku)
+		}
+	}
+
+	existing := map[string]*Product{}
+	for start := 0; start < len(skus); start += 500 {
+		end := min(start+500, len(skus))
+		var products []Product
+		if err := DB.Unscoped().Where("sku IN ?", skus[start:end]).Find(&products).Error; err != nil {
+			return nil, err
+		}
+		for i := range products {
+			existing[products[i].SKU] = &products[i]
+		}
+	}
+	return existing, nil
+}
+
+// EachProduct calls fn for every product in id order, the products are
+// loaded in batches so that the table is never held in memory
+func EachProduct(batchSize int, fn func(*Product) error) error {
+	var batch []Product
+	return DB.FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
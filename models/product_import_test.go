@@ -0,0 +1,170 @@
+package models_test
+
+import (
+	"encoding/json"
+	"errors"
+	"myapp/models"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestReadImportRows(t *testing.T) {
+	csvFile := "sku,name,price,description\nAPP-001,APPLE,10.5,\nBAN-002,BANANA,cheap,yellow\n"
+	rows, err := models.ReadImportRows(strings.NewReader(csvFile), models.ImportFormatCSV)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, models.ImportRow{Line: 2, Fields: map[string]interface{}{"sku": "APP-001", "name": "APPLE", "price": json.Number("10.5")}}, rows[0])
+		assert.Equal(t, 3, rows[1].Line)
+		assert.Equal(t, models.ValidationErrors{"price": "must be a number"}, rows[1].Err)
+	}
+
+	csvFile = "sku,tags,attributes\nAPP-001,\"[\"\"organic\"\"]\",\"{\"\"weight\"\":180}\"\nBAN-002,organic,\n"
+	rows, err = models.ReadImportRows(strings.NewReader(csvFile), models.ImportFormatCSV)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, map[string]interface{}{"sku": "APP-001", "tags": []interface{}{"organic"},
+			"attributes": map[string]interface{}{"weight": json.Number("180")}}, rows[0].Fields)
+		assert.Equal(t, models.ValidationErrors{"tags": "must be a JSON array"}, rows[1].Err)
+	}
+
+	_, err = models.ReadImportRows(strings.NewReader("sku,colour\nAPP-001,red\n"), models.ImportFormatCSV)
+	assert.EqualError(t, err, `unknown column "colour"`)
+
+	ndjson := "{\"sku\":\"APP-001\",\"price\":0}\n\n[1]\n"
+	rows, err = models.ReadImportRows(strings.NewReader(ndjson), models.ImportFormatNDJSON)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, map[string]interface{}{"sku": "APP-001", "price": json.Number("0")}, rows[0].Fields)
+		assert.Equal(t, 3, rows[1].Line)
+		assert.Equal(t, models.ValidationErrors{"row": "must be a JSON object"}, rows[1].Err)
+	}
+
+	defer func(limit int) { models.MaxImportRows = limit }(models.MaxImportRows)
+	models.MaxImportRows = 1
+	_, err = models.ReadImportRows(strings.NewReader("{}\n{}\n"), models.ImportFormatNDJSON)
+	assert.True(t, errors.Is(err, models.ErrTooManyImportRows))
+}
+
+func TestImportProductsAtomicWithInvalidRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE sku IN (?,?)")).
+		WithArgs("APP-001", "BAN-002").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "version"}).
+			AddRow(1, "APP-001", "APPLE", 10.0, 2))
+
+	rows := []models.ImportRow{
+		{Line: 1, Fields: map[string]interface{}{"sku": "APP-001", "price": 12.0}},
+		{Line: 2, Fields: map[string]interface{}{"sku": "BAN-002", "price": 3.0}},
+		{Line: 3, Fields: map[string]interface{}{"sku": "APP-001", "name": "APPLE"}},
+	}
+	report, err := models.ImportProducts(alice, rows, models.ImportOptions{Upsert: true, Atomic: true})
+	assert.NoError(t, err)
+
+	// nothing is written because of the row without a name
+	assert.False(t, report.Committed)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, 2, report.Failed)
+	assert.Equal(t, []models.ImportRowResult{
+		{Line: 1, SKU: "APP-001", Action: models.ImportUpdated, ID: 1},
+		{Line: 2, SKU: "BAN-002", Action: models.ImportFailed, Errors: models.ValidationErrors{"name": "is required"}},
+		{Line: 3, SKU: "APP-001", Action: models.ImportFailed, Errors: models.ValidationErrors{"sku": "is already used on line 1"}},
+	}, report.Rows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestImportProductsBestEffort(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE sku IN (?,?)")).
+		WithArgs("APP-001", "BAN-002").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", "10", "USD", 2))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `products`")).
+		WithArgs("BAN-002", "BANANA", "", "pcs", "active", "simple", "3", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	expectPrice(mock, 7, "3")
+	expectAudit(mock, "create", "product", 7, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	rows := []models.ImportRow{
+		{Line: 2, Fields: map[string]interface{}{"sku": "APP-001", "price": 12.0}},
+		{Line: 3, Fields: map[string]interface{}{"sku": "BAN-002", "name": "BANANA", "price": 3.0}},
+	}
+	// without upsert the existing SKU fails, the other row is still imported
+	report, err := models.ImportProducts(alice, rows, models.ImportOptions{})
+	assert.NoError(t, err)
+	assert.True(t, report.Committed)
+	assert.Equal(t, []models.ImportRowResult{
+		{Line: 2, SKU: "APP-001", Action: models.ImportFailed, Errors: models.ValidationErrors{"sku": "already exists"}},
+		{Line: 3, SKU: "BAN-002", Action: models.ImportCreated, ID: 7},
+	}, report.Rows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestImportProductsWithTrashedSKU(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the products in the trash are looked up too
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE sku IN (?)") + "$").
+		WithArgs("APP-001").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "currency", "version", "deleted_at"}).
+			AddRow(4, "APP-001", "APPLE", "10", "USD", 2, time.Now()))
+
+	rows := []models.ImportRow{{Line: 2, Fields: map[string]interface{}{"sku": "APP-001", "price": 12.0}}}
+	report, err := models.ImportProducts(alice, rows, models.ImportOptions{Upsert: true, Atomic: true})
+	assert.NoError(t, err)
+	assert.False(t, report.Committed)
+	assert.Equal(t, []models.ImportRowResult{
+		{Line: 2, SKU: "APP-001", Action: models.ImportFailed, Errors: models.ValidationErrors{
+			"sku": "SKU belongs to product 4 in the trash, restore it with POST /products/4/restore"}},
+	}, report.Rows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,312 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrPriceApplied = errors.New("price is already applied")
+
+// ProductPrice is an entry of the price history of a product. Every price
+// change adds an entry effective at the time of the change, a scheduled price
+// is an entry effective in the future which isn't applied yet. The scheduler
+// skips a price which no longer fits the product, it is never applied then.
+type ProductPrice struct {
+	ID          int        `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	ProductID   int        `json:"product_id" gorm:"column:product_id;not null;index:idx_product_prices_effective,priority:1"`
+	Price       Decimal    `json:"price" gorm:"column:price;type:decimal(19,4);not null"`
+	Currency    string     `json:"currency" gorm:"column:currency;size:3;not null;default:USD"`
+	EffectiveAt time.Time  `json:"effective_at" gorm:"column:effective_at;not null;index:idx_product_prices_effective,priority:2"`
+	AppliedAt   *time.Time `json:"applied_at" gorm:"column:applied_at"` // nil while the price is scheduled
+	SkippedAt   *time.Time `json:"skipped_at,omitempty" gorm:"column:skipped_at"`
+	SkipReason  string     `json:"skip_reason,omitempty" gorm:"column:skip_reason;size:128"`
+	CreatedBy   string     `json:"created_by" gorm:"column:created_by;size:64"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"column:created_at"`
+}
+
+// PriceInput is a scheduled price, it is in the currency of the product
+type PriceInput struct {
+	Price       *Decimal   `json:"price"`
+	EffectiveAt *time.Time `json:"effective_at"`
+}
+
+// Validate checks the scheduled price, it returns ValidationErrors. The
+// decimal places of the currency are checked by SchedulePrice.
+func (in *PriceInput) Validate() error {
+	errs := ValidationErrors{}
+	switch {
+	case in.Price == nil:
+		errs["price"] = "is required"
+	case in.Price.Sign() < 0:
+		errs["price"] = "must not be negative"
+	}
+	switch {
+	case in.EffectiveAt == nil:
+		errs["effective_at"] = "is required"
+	case !in.EffectiveAt.After(time.Now()):
+		errs["effective_at"] = "must be in the future"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// recordPrice adds the price the product has from now on to its history
+func recordPrice(tx *gorm.DB, product *Product) error {
+	now := time.Now()
+	entry := ProductPrice{
+		ProductID:   product.ID,
+		Price:       product.Price,
+		Currency:    product.Currency,
+		EffectiveAt: now,
+		AppliedAt:   &now,
+		CreatedBy:   actorFrom(tx.Statement.Context).Username,
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&entry).Error
+}
+
+// products created before the price history existed start it with their
+// current price
+func backfillProductPrices(db *gorm.DB) error {
+	now := time.Now()
+	return db.Exec(`INSERT INTO product_prices (product_id, price, currency, effective_at, applied_at, created_by, created_at)
+		SELECT p.id, p.price, p.currency, COALESCE(p.created_at, ?), COALESCE(p.created_at, ?), ?, ? FROM products p
+		WHERE NOT EXISTS (SELECT 1 FROM product_prices pp WHERE pp.product_id = p.id)`, now, now, SystemActor, now).Error
+}
+
+// GetProductPrices returns the price history and the scheduled prices of the
+// product, the latest effective time first
+func GetProductPrices(productID int) ([]ProductPrice, error) {
+	prices := []ProductPrice{}
+	err := DB.Where("product_id = ?", productID).Order("effective_at DESC, id DESC").Find(&prices).Error
+	if err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// GetPriceAt returns the entry which was effective at the time, a scheduled
+// price counts from its effective time even if the scheduler hasn't applied it
+// yet, unless it was skipped. It fails with gorm.ErrRecordNotFound before the
+// first price.
+func GetPriceAt(productID int, at time.Time) (*ProductPrice, error) {
+	var price ProductPrice
+	err := DB.Where("product_id = ? AND effective_at <= ? AND skipped_at IS NULL", productID, at).
+		Order("effective_at DESC, id DESC").First(&price).Error
+	if err != nil {
+		return nil, err
+	}
+	return &price, nil
+}
+
+// resolveEffectivePrices sets the price of the products whose scheduled price
+// has become effective before the scheduler applied it, so that reads agree
+// with GetPriceAt. A price the scheduler would skip is left out.
+func resolveEffectivePrices(products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]int, len(products))
+	for i := range products {
+		ids[i] = products[i].ID
+	}
+	now := time.Now()
+	var due []int
+	err := DB.Model(&ProductPrice{}).
+		Where("product_id IN ? AND applied_at IS NULL AND skipped_at IS NULL AND effective_at <= ?", ids, now).
+		Distinct().Pluck("product_id", &due).Error
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	isDue := make(map[int]bool, len(due))
+	for _, id := range due {
+		isDue[id] = true
+	}
+	for i := range products {
+		if !isDue[products[i].ID] {
+			continue
+		}
+		price, err := GetPriceAt(products[i].ID, now)
+		if err != nil {
+			return err
+		}
+		// an applied price is already the price of the product, a later
+		// one than the scheduled
+		if price.AppliedAt == nil && price.Currency == products[i].Currency {
+			products[i].Price = price.Price
+		}
+	}
+	return nil
+}
+
+// SchedulePrice adds a price which the scheduler applies to the product at
+// its effective time
+func SchedulePrice(ctx context.Context, productID int, input *PriceInput) (*ProductPrice, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	var price *ProductPrice
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var product Product
+		if err := tx.Select("id", "currency").First(&product, productID).Error; err != nil {
+			return err
+		}
+		if reason := validateAmount(*input.Price, product.Currency); reason != "" {
+			return ValidationErrors{"price": reason}
+		}
+		price = &ProductPrice{
+			ProductID:   productID,
+			Price:       *input.Price,
+			Currency:    product.Currency,
+			EffectiveAt: *input.EffectiveAt,
+			CreatedBy:   actorFrom(ctx).Username,
+		}
+		if err := tx.Create(price).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "schedule_price", AuditProduct, productID, nil, scheduledPriceFields(price))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+// CancelScheduledPrice removes a scheduled price which isn't applied yet
+func CancelScheduledPrice(ctx context.Context, productID, priceID int) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var price ProductPrice
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ?", productID).First(&price, priceID).Error
+		if err != nil {
+			return err
+		}
+		if price.AppliedAt != nil {
+			return ErrPriceApplied
+		}
+		if err := tx.Delete(&price).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "cancel_price", AuditProduct, productID, scheduledPriceFields(&price), nil)
+	})
+}
+
+// the scheduled price as it is shown in the audit trail of the product
+func scheduledPriceFields(price *ProductPrice) map[string]interface{} {
+	return map[string]interface{}{"scheduled_price": map[string]interface{}{
+		"id":           price.ID,
+		"price":        price.Price,
+		"currency":     price.Currency,
+		"effective_at": price.EffectiveAt,
+	}}
+}
+
+// ApplyScheduledPrices sets the price of the products whose scheduled price
+// has become effective and returns how many were applied, it is run by the
+// background scheduler. Prices of deleted products wait until the product is
+// restored. A price in another currency than the product's or older than the
+// latest applied price is skipped and logged.
+func ApplyScheduledPrices() (int, error) {
+	var ids []int
+	err := DB.Model(&ProductPrice{}).
+		Joins("JOIN products ON products.id = product_prices.product_id AND products.deleted_at IS NULL").
+		Where("product_prices.applied_at IS NULL AND product_prices.skipped_at IS NULL AND product_prices.effective_at <= ?", time.Now()).
+		Order("product_prices.effective_at, product_prices.id").Limit(500).
+		Pluck("product_prices.id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, id := range ids {
+		var skipped string
+		err := DB.Transaction(func(tx *gorm.DB) (err error) {
+			skipped, err = applyScheduledPrice(tx, id)
+			return err
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// cancelled or applied in the meantime
+			continue
+		}
+		if err != nil {
+			return applied, err
+		}
+		if skipped != "" {
+			logrus.Warnf("Skipped scheduled price %d: %s", id, skipped)
+			continue
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// applyScheduledPrice sets the price of the product, or marks the price as
+// skipped and returns why
+func applyScheduledPrice(tx *gorm.DB, id int) (string, error) {
+	var price ProductPrice
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("applied_at IS NULL AND skipped_at IS NULL").First(&price, id).Error
+	if err != nil {
+		return "", err
+	}
+	var product Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, price.ProductID).Error; err != nil {
+		return "", err
+	}
+
+	var latest ProductPrice
+	err = tx.Where("product_id = ? AND applied_at IS NOT NULL", product.ID).
+		Order("effective_at DESC, id DESC").Limit(1).Find(&latest).Error
+	if err != nil {
+		return "", err
+	}
+	reason := ""
+	switch {
+	case price.Currency != product.Currency:
+		reason = "the product is priced in " + product.Currency + " now"
+	case latest.ID != 0 && price.EffectiveAt.Before(latest.EffectiveAt):
+		reason = "a later price is already in effect"
+	}
+	if reason != "" {
+		return reason, skipScheduledPrice(tx, &price, reason)
+	}
+
+	// the entry itself is the history of the change, so the product is
+	// written without writeProduct
+	before := product
+	product.Price, product.Currency = price.Price, price.Currency
+	product.Version++
+	result := tx.Model(&product).Where("version = ?", before.Version).
+		Select("price", "currency", "version", "updated_at").Updates(&product)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", ErrVersionConflict
+	}
+	if err := tx.Model(&price).Update("applied_at", time.Now()).Error; err != nil {
+		return "", err
+	}
+	if err := followBasePrice(tx, &before, &product); err != nil {
+		return "", err
+	}
+	return "", recordAudit(tx, "update", AuditProduct, product.ID, &before, &product)
+}
+
+// skipScheduledPrice keeps the price in the history but never applies it
+func skipScheduledPrice(tx *gorm.DB, price *ProductPrice, reason string) error {
+	err := tx.Model(price).Updates(map[string]interface{}{"skipped_at": time.Now(), "skip_reason": reason}).Error
+	if err != nil {
+		return err
+	}
+	return recordAudit(tx, "skip_price", AuditProduct, price.ProductID, scheduledPriceFields(price), nil)
+}
@@ -0,0 +1,269 @@
+package models_test
+
+import (
+	"database/sql/driver"
+	"myapp/models"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// expectPrice expects the price history entry alice adds by changing the
+// price, the price is in USD
+func expectPrice(mock sqlmock.Sqlmock, productID int, price string) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `product_prices` (`product_id`,`price`,`currency`,`effective_at`,`applied_at`,`skipped_at`,`skip_reason`,`created_by`,`created_at`) VALUES (?,?,?,?,?,?,?,?,?)")).
+		WithArgs(productID, price, "USD", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "", "alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectDuePrices expects the check for scheduled prices of the products
+// which are effective but not applied yet, there are none
+func expectDuePrices(mock sqlmock.Sqlmock, productIDs ...int) {
+	args := []driver.Value{}
+	for _, id := range productIDs {
+		args = append(args, id)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT `product_id` FROM `product_prices` WHERE product_id IN (")).
+		WithArgs(append(args, sqlmock.AnyArg())...).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id"}))
+}
+
+func TestSchedulePrice(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	effectiveAt := time.Now().Add(24 * time.Hour)
+	price := models.MustParseDecimal("12.5")
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`currency` FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency"}).AddRow(1, "USD"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `product_prices` (`product_id`,`price`,`currency`,`effective_at`,`applied_at`,`skipped_at`,`skip_reason`,`created_by`,`created_at`) VALUES (?,?,?,?,?,?,?,?,?)")).
+		WithArgs(1, "12.5", "USD", effectiveAt, nil, nil, "", "alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	expectAudit(mock, "schedule_price", "product", 1, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	scheduled, err := models.SchedulePrice(alice, 1, &models.PriceInput{Price: &price, EffectiveAt: &effectiveAt})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, scheduled.ID)
+	assert.Equal(t, "USD", scheduled.Currency)
+	assert.Nil(t, scheduled.AppliedAt)
+
+	// prices can only be scheduled for the future
+	past := time.Now().Add(-time.Hour)
+	negative := price.Neg()
+	_, err = models.SchedulePrice(alice, 1, &models.PriceInput{Price: &negative, EffectiveAt: &past})
+	assert.Equal(t, models.ValidationErrors{"price": "must not be negative", "effective_at": "must be in the future"}, err)
+
+	// the price is in the currency of the product
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`currency` FROM `products`")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency"}).AddRow(2, "JPY"))
+	mock.ExpectRollback()
+	_, err = models.SchedulePrice(alice, 2, &models.PriceInput{Price: &price, EffectiveAt: &effectiveAt})
+	assert.Equal(t, models.ValidationErrors{"price": "must be a whole amount of JPY"}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestGetPriceAt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	at := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE product_id = ? AND effective_at <= ? AND skipped_at IS NULL ORDER BY effective_at DESC, id DESC,`product_prices`.`id` LIMIT ?")).
+		WithArgs(1, at, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "effective_at"}).
+			AddRow(2, 1, 9.5, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+
+	price, err := models.GetPriceAt(1, at)
+	assert.NoError(t, err)
+	assert.Equal(t, "9.5", price.Price.String())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestApplyScheduledPrices(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `product_prices`.`id` FROM `product_prices` JOIN products ON products.id = product_prices.product_id AND products.deleted_at IS NULL WHERE product_prices.applied_at IS NULL AND product_prices.skipped_at IS NULL AND product_prices.effective_at <= ? ORDER BY product_prices.effective_at, product_prices.id LIMIT ?")).
+		WithArgs(sqlmock.AnyArg(), 500).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4))
+
+	// price 3 becomes the price of product 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE (applied_at IS NULL AND skipped_at IS NULL) AND `product_prices`.`id` = ? ORDER BY `product_prices`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at"}).
+			AddRow(3, 1, "12.5000", "USD", time.Now().Add(-time.Minute)))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "APPLE", "10.0000", "USD", 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE product_id = ? AND applied_at IS NOT NULL ORDER BY effective_at DESC, id DESC LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at"}).
+			AddRow(2, 1, "10.0000", "USD", time.Now().Add(-time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `price`=?,`currency`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?")).
+		WithArgs("12.5", "USD", 3, sqlmock.AnyArg(), 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `product_prices` SET `applied_at`=? WHERE `id` = ?")).
+		WithArgs(sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `audit_entries`")).
+		WithArgs("system", "update", "product", 1, `{"price":{"from":10,"to":12.5},"version":{"from":2,"to":3}}`, "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// price 4 was cancelled in the meantime
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE (applied_at IS NULL AND skipped_at IS NULL) AND `product_prices`.`id` = ?")).
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	applied, err := models.ApplyScheduledPrices()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, applied)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestApplyScheduledPricesSkipsStalePrices(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `product_prices`.`id` FROM `product_prices`")).
+		WithArgs(sqlmock.AnyArg(), 500).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5).AddRow(6))
+
+	// price 5 was scheduled before the product changed to EUR
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE (applied_at IS NULL AND skipped_at IS NULL) AND `product_prices`.`id` = ?")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at"}).
+			AddRow(5, 1, "12.5000", "USD", time.Now().Add(-time.Minute)))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "APPLE", "11.0000", "EUR", 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE product_id = ? AND applied_at IS NOT NULL")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at"}).
+			AddRow(2, 1, "11.0000", "EUR", time.Now().Add(-time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `product_prices` SET `skip_reason`=?,`skipped_at`=? WHERE `id` = ?")).
+		WithArgs("the product is priced in EUR now", sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `audit_entries`")).
+		WithArgs("system", "skip_price", "product", 1, sqlmock.AnyArg(), "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// price 6 was overtaken by a price set after its effective time
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE (applied_at IS NULL AND skipped_at IS NULL) AND `product_prices`.`id` = ?")).
+		WithArgs(6, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at"}).
+			AddRow(6, 2, "8.0000", "USD", time.Now().Add(-time.Hour)))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "currency", "version"}).
+			AddRow(2, "BAN-001", "BANANA", "9.0000", "USD", 4))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE product_id = ? AND applied_at IS NOT NULL")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at"}).
+			AddRow(7, 2, "9.0000", "USD", time.Now().Add(-time.Minute)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `product_prices` SET `skip_reason`=?,`skipped_at`=? WHERE `id` = ?")).
+		WithArgs("a later price is already in effect", sqlmock.AnyArg(), 6).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `audit_entries`")).
+		WithArgs("system", "skip_price", "product", 2, sqlmock.AnyArg(), "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	applied, err := models.ApplyScheduledPrices()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestCancelAppliedPrice(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE product_id = ? AND `product_prices`.`id` = ? ORDER BY `product_prices`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "effective_at", "applied_at"}).
+			AddRow(3, 1, 12.5, time.Now().Add(-time.Hour), time.Now()))
+	mock.ExpectRollback()
+
+	err = models.CancelScheduledPrice(alice, 1, 3)
+	assert.ErrorIs(t, err, models.ErrPriceApplied)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,328 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// columns that products can be sorted by, the JSON name is the column name
+var productSortColumns = map[string]bool{
+	"id":         true,
+	"sku":        true,
+	"name":       true,
+	"status":     true,
+	"price":      true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// ProductQuery selects a page of products, either by Offset or by the Cursor
+// of the previous page
+type ProductQuery struct {
+	Limit        int
+	Offset       int
+	Cursor       string
+	NameContains string
+	MinPrice     *Decimal
+	MaxPrice     *Decimal
+	Statuses     []ProductStatus
+	Sort         []SortField
+	// IncludeDeleted lists the products in the trash as well
+	IncludeDeleted bool
+	// CategoryID lists the products of the category only, and of its
+	// descendants with IncludeSubcategories
+	CategoryID           *int
+	IncludeSubcategories bool
+	// ParentID lists the variants of the product only, ExcludeVariants
+	// leaves every variant out
+	ParentID        *int
+	ExcludeVariants bool
+	// Attributes lists the products which have all of the attribute values
+	Attributes []AttributeFilter
+
+	categoryIDs []int // resolved by ListProducts
+}
+
+// AttributeFilter matches products whose attribute has the value, the value
+// is compared as it prints, e.g. "42" or "true"
+type AttributeFilter struct {
+	Code  string
+	Value string
+}
+
+type ProductPage struct {
+	Data       []Product `json:"data"`
+	Total      int64     `json:"total"`
+	Limit      int       `json:"limit"`
+	Offset     int       `json:"offset"`
+	NextCursor *string   `json:"next_cursor"`
+}
+
+// productCursor points behind the last product of a page
+type productCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+// ParseSort parses "name,-price" into sort fields, a leading '-' sorts descending
+func ParseSort(spec string) ([]SortField, error) {
+	var fields []SortField
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field := SortField{Column: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if !productSortColumns[field.Column] {
+			return nil, fmt.Errorf("unknown sort field %q", field.Column)
+		}
+		if seen[field.Column] {
+			return nil, fmt.Errorf("duplicate sort field %q", field.Column)
+		}
+		seen[field.Column] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// sortFields returns the sort with id appended as tie breaker, which makes
+// the order total so that a cursor identifies a single position
+func (q *ProductQuery) sortFields() []SortField {
+	fields := append([]SortField(nil), q.Sort...)
+	for _, f := range fields {
+		if f.Column == "id" {
+			return fields
+		}
+	}
+	return append(fields, SortField{Column: "id"})
+}
+
+func sortSpec(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.Column
+		if f.Desc {
+			parts[i] = "-" + f.Column
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (q *ProductQuery) filter(db *gorm.DB) *gorm.DB {
+	if q.NameContains != "" {
+		// '!' escapes the wildcards, a backslash would need quoting in MySQL
+		escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(q.NameContains))
+		db = db.Where("LOWER(name) LIKE ? ESCAPE '!'", "%"+escaped+"%")
+	}
+	if q.MinPrice != nil {
+		db = db.Where("price >= ?", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		db = db.Where("price <= ?", *q.MaxPrice)
+	}
+	if len(q.Statuses) > 0 {
+		db = db.Where("status IN ?", q.Statuses)
+	}
+	if len(q.categoryIDs) > 0 {
+		db = db.Where("category_id IN ?", q.categoryIDs)
+	}
+	if q.ParentID != nil {
+		db = db.Where("parent_id = ?", *q.ParentID)
+	}
+	if q.ExcludeVariants {
+		db = db.Where("parent_id IS NULL")
+	}
+	for _, attribute := range q.Attributes {
+		path := "$." + attribute.Code
+		if isSQLite(db) {
+			// json_extract returns booleans as 1 and 0, MySQL as true and false
+			db = db.Where("CASE json_type(attributes, ?) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' "+
+				"ELSE CAST(json_extract(attributes, ?) AS TEXT) END = ?", path, path, attribute.Value)
+		} else {
+			db = db.Where("JSON_UNQUOTE(JSON_EXTRACT(attributes, ?)) = ?", path, attribute.Value)
+		}
+	}
+	return db
+}
+
+// ListProducts returns a page of the filtered and sorted products, it fails
+// with ErrUnknownCategory when the category filter doesn't exist
+func ListProducts(q ProductQuery) (*ProductPage, error) {
+	if q.CategoryID != nil {
+		ids, err := CategorySubtree(*q.CategoryID)
+		if err != nil {
+			return nil, err
+		}
+		if !q.IncludeSubcategories {
+			ids = ids[:1]
+		}
+		q.categoryIDs = ids
+	}
+
+	fields := q.sortFields()
+	spec := sortSpec(fields)
+
+	var cursor *productCursor
+	if q.Cursor != "" {
+		var err error
+		if cursor, err = decodeCursor(q.Cursor, spec, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	db := DB
+	if q.IncludeDeleted {
+		db = DB.Unscoped()
+	}
+
+	page := &ProductPage{Data: []Product{}, Limit: q.Limit, Offset: q.Offset}
+	if err := q.filter(db.Model(&Product{})).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	query := q.filter(db.Model(&Product{}))
+	if cursor != nil {
+		condition, args := keysetCondition(fields, cursor.Values)
+		query = query.Where(condition, args...)
+	} else if q.Offset > 0 {
+		query = query.Offset(q.Offset)
+	}
+	for _, f := range fields {
+		if f.Desc {
+			query = query.Order(f.Column + " DESC")
+		} else {
+			query = query.Order(f.Column)
+		}
+	}
+
+	// one more row tells whether there is a next page
+	if err := query.Limit(q.Limit + 1).Find(&page.Data).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Data) > q.Limit {
+		page.Data = page.Data[:q.Limit]
+		next, err := encodeCursor(spec, fields, &page.Data[q.Limit-1])
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = &next
+	}
+	// after the cursor, which continues in the order of the stored prices
+	if err := resolveEffectivePrices(page.Data); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// keysetCondition selects the rows after the cursor values in the sort order:
+// (a > x) OR (a = x AND b < y) OR (a = x AND b = y AND id > z) ...
+func keysetCondition(fields []SortField, values []interface{}) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i, f := range fields {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, fields[j].Column+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if f.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, f.Column+op)
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return strings.Join(ors, " OR "), args
+}
+
+func productSortValue(p *Product, column string) interface{} {
+	switch column {
+	case "id":
+		return p.ID
+	case "sku":
+		return p.SKU
+	case "name":
+		return p.Name
+	case "status":
+		return string(p.Status)
+	case "price":
+		// a string, a JSON number would be read back as float64
+		return p.Price.String()
+	case "created_at":
+		return p.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "updated_at":
+		return p.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return nil
+}
+
+func encodeCursor(spec string, fields []SortField, last *Product) (string, error) {
+	cursor := productCursor{Sort: spec}
+	for _, f := range fields {
+		cursor.Values = append(cursor.Values, productSortValue(last, f.Column))
+	}
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor checks that the cursor was issued for the same sort and
+// converts its values back to the column types
+func decodeCursor(raw, spec string, fields []SortField) (*productCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor productCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != spec || len(cursor.Values) != len(fields) {
+		return nil, fmt.Errorf("%w: the cursor was issued for a different sort", ErrInvalidCursor)
+	}
+
+	for i, f := range fields {
+		switch f.Column {
+		case "id":
+			if _, ok := cursor.Values[i].(float64); !ok {
+				return nil, ErrInvalidCursor
+			}
+		case "price":
+			s, _ := cursor.Values[i].(string)
+			price, err := ParseDecimal(s)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			cursor.Values[i] = price
+		case "created_at", "updated_at":
+			s, _ := cursor.Values[i].(string)
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			cursor.Values[i] = t
+		default:
+			if _, ok := cursor.Values[i].(string); !ok {
+				return nil, ErrInvalidCursor
+			}
+		}
+	}
+	return &cursor, nil
+}
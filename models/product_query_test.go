@@ -0,0 +1,143 @@
+package models_test
+
+import (
+	"errors"
+	"myapp/models"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestParseSort(t *testing.T) {
+	fields, err := models.ParseSort("name, -price")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.SortField{{Column: "name"}, {Column: "price", Desc: true}}, fields)
+
+	fields, err = models.ParseSort("")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	_, err = models.ParseSort("password")
+	assert.EqualError(t, err, `unknown sort field "password"`)
+
+	_, err = models.ParseSort("name,-name")
+	assert.EqualError(t, err, `duplicate sort field "name"`)
+}
+
+func TestListProductsWithCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	minPrice := models.DecimalFromInt(10)
+	query := models.ProductQuery{
+		Limit:        2,
+		NameContains: "App_",
+		MinPrice:     &minPrice,
+		Statuses:     []models.ProductStatus{models.ProductStatusActive},
+		Sort:         []models.SortField{{Column: "price", Desc: true}},
+	}
+
+	// first page, one more row than the limit is fetched
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `products` WHERE LOWER(name) LIKE ? ESCAPE '!' AND price >= ? AND status IN (?)")).
+		WithArgs("%app!_%", "10", "active").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE LOWER(name) LIKE ? ESCAPE '!' AND price >= ? AND status IN (?) AND `products`.`deleted_at` IS NULL ORDER BY price DESC,id LIMIT ?")).
+		WithArgs("%app!_%", "10", "active", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "currency"}).
+			AddRow(1, "APP-001", "APP_LE", 30.0, "USD").
+			AddRow(2, "APP-002", "APP_RICOT", 20.0, "USD").
+			AddRow(3, "APP-003", "APP_S", 20.0, "USD"))
+	// a scheduled price of APP-002 is effective, the scheduler hasn't
+	// applied it yet
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT `product_id` FROM `product_prices` WHERE product_id IN (?,?) AND applied_at IS NULL AND skipped_at IS NULL AND effective_at <= ?")).
+		WithArgs(1, 2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `product_prices` WHERE product_id = ? AND effective_at <= ? AND skipped_at IS NULL ORDER BY effective_at DESC, id DESC,`product_prices`.`id` LIMIT ?")).
+		WithArgs(2, sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "price", "currency", "effective_at", "applied_at"}).
+			AddRow(7, 2, "18.0000", "USD", time.Now().Add(-time.Second), nil))
+
+	page, err := models.ListProducts(query)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), page.Total)
+	if assert.Len(t, page.Data, 2) {
+		assert.Equal(t, "30", page.Data[0].Price.String())
+		assert.Equal(t, "18", page.Data[1].Price.String())
+	}
+	if assert.NotNil(t, page.NextCursor) {
+		query.Cursor = *page.NextCursor
+	}
+
+	// the next page continues behind the last row of the first page
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `products`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE LOWER(name) LIKE ? ESCAPE '!' AND price >= ? AND status IN (?) AND ((price < ?) OR (price = ? AND id > ?)) AND `products`.`deleted_at` IS NULL ORDER BY price DESC,id LIMIT ?")).
+		WithArgs("%app!_%", "10", "active", "20", "20", 2.0, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price"}).
+			AddRow(3, "APP-003", "APP_S", 20.0))
+	expectDuePrices(mock, 3)
+
+	page, err = models.ListProducts(query)
+	assert.NoError(t, err)
+	assert.Len(t, page.Data, 1)
+	assert.Nil(t, page.NextCursor)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestListProductsWithCursorForOtherSort(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `products`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`deleted_at` IS NULL ORDER BY id LIMIT ?")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "APPLE").AddRow(2, "BANANA"))
+	expectDuePrices(mock, 1)
+
+	page, err := models.ListProducts(models.ProductQuery{Limit: 1})
+	assert.NoError(t, err)
+	if !assert.NotNil(t, page.NextCursor) {
+		return
+	}
+
+	_, err = models.ListProducts(models.ProductQuery{
+		Limit:  1,
+		Cursor: *page.NextCursor,
+		Sort:   []models.SortField{{Column: "name"}},
+	})
+	assert.True(t, errors.Is(err, models.ErrInvalidCursor))
+
+	_, err = models.ListProducts(models.ProductQuery{Limit: 1, Cursor: "not a cursor"})
+	assert.True(t, errors.Is(err, models.ErrInvalidCursor))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
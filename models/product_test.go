@@ -3,8 +3,10 @@ package models_test
 import (
 	"errors"
 	"myapp/models"
+	"myapp/utils"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -27,16 +29,21 @@ func TestCreateProduct(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `products`").
-		WithArgs("APPLE", 99.0).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "99", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPrice(mock, 1, "99")
+	expectAudit(mock, "create", "product", 1, sqlmock.AnyArg())
 	mock.ExpectCommit()
 
 	product := &models.Product{
-		Name:  "APPLE",
-		Price: 99.0,
+		SKU:    "APP-001",
+		Name:   "APPLE",
+		Unit:   "pcs",
+		Status: models.ProductStatusActive,
+		Price:  models.DecimalFromInt(99),
 	}
 
-	id, err := models.CreateProduct(product)
+	id, err := models.CreateProduct(alice, product)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, id)
 
@@ -59,12 +66,17 @@ func TestDeleteProduct(t *testing.T) {
 	models.DB = gormDB
 
 	mock.ExpectBegin()
-	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `products` WHERE `products`.`id` = ?")).
-		WithArgs(1).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).AddRow(1, "APP-001", "APPLE", "simple", "10", "USD", 3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `deleted_at`=? WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(sqlmock.AnyArg(), 1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	// the trail keeps the deleted product
+	expectAudit(mock, "delete", "product", 1, `{"currency":{"from":"USD","to":null},"description":{"from":"","to":null},"id":{"from":1,"to":null},"name":{"from":"APPLE","to":null},"price":{"from":10,"to":null},"sku":{"from":"APP-001","to":null},"status":{"from":"","to":null},"type":{"from":"simple","to":null},"unit":{"from":"","to":null},"version":{"from":3,"to":null}}`)
 	mock.ExpectCommit()
 
-	rowsAffected, err := models.DeleteProduct(1)
+	rowsAffected, err := models.DeleteProduct(alice, 1, 0)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, rowsAffected)
 
@@ -86,26 +98,30 @@ func TestUpdateProduct(t *testing.T) {
 
 	models.DB = gormDB
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
+	mock.ExpectBegin()
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 50.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", "50.0000", "USD"))
 
-	expectedUpdate := "UPDATE `products` SET `name`=?,`price`=? WHERE `id` = ?"
-	mock.ExpectBegin()
+	expectedUpdate := "UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?"
 	mock.ExpectExec(regexp.QuoteMeta(expectedUpdate)).
-		WithArgs("APPLE_UPDATED", 100.0, 1).
+		WithArgs("APP-001", "APPLE_UPDATED", "", "pcs", "active", "simple", "100", "USD", nil, nil, "", "", 1, sqlmock.AnyArg(), 0, 1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPrice(mock, 1, "100")
+	expectAudit(mock, "update", "product", 1, `{"name":{"from":"APPLE","to":"APPLE_UPDATED"},"price":{"from":50,"to":100},"version":{"from":0,"to":1}}`)
 	mock.ExpectCommit()
 
 	updatedProduct := &models.Product{
+		SKU:   "APP-001",
 		Name:  "APPLE_UPDATED",
-		Price: 100.0,
+		Price: models.DecimalFromInt(100),
 	}
 
-	err = models.UpdateProduct(1, updatedProduct)
+	product, err := models.UpdateProduct(alice, 1, updatedProduct, 0)
 	assert.NoError(t, err)
+	assert.Equal(t, "APPLE_UPDATED", product.Name)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unexpected: %s", err)
@@ -124,9 +140,9 @@ func TestGetAllProducts(t *testing.T) {
 	assert.NoError(t, err)
 
 	mock.ExpectQuery(`^SELECT \* FROM ` + "`products`").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 99.0).
-			AddRow(2, "BANANA", 50.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", 99.0).
+			AddRow(2, "BAN-002", "BANANA", "", "pcs", "active", "simple", 50.0))
 
 	models.DB = gormDB
 
@@ -134,7 +150,7 @@ func TestGetAllProducts(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, products, 2)
 	assert.Equal(t, "APPLE", products[0].Name)
-	assert.Equal(t, 99.0, products[0].Price)
+	assert.Equal(t, models.DecimalFromInt(99), products[0].Price)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unexpected: %s", err)
@@ -152,18 +168,19 @@ func TestGetProductByID(t *testing.T) {
 	}), &gorm.Config{})
 	assert.NoError(t, err)
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 99.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", 99.0))
+	expectDuePrices(mock, 1)
 
 	models.DB = gormDB
 
 	product, err := models.GetProductByID(1)
 	assert.NoError(t, err)
 	assert.Equal(t, "APPLE", product.Name)
-	assert.Equal(t, 99.0, product.Price)
+	assert.Equal(t, models.DecimalFromInt(99), product.Price)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unexpected: %s", err)
@@ -185,16 +202,19 @@ func TestCreateProductWithInvalidData(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `products`").
-		WithArgs("", -1.0). // Invalid data
+		WithArgs("APP-001", "", "", "pcs", "active", "simple", "-1", "USD", nil, nil, nil, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil). // Invalid data
 		WillReturnError(errors.New("Validation error"))
 	mock.ExpectRollback()
 
 	product := &models.Product{
-		Name:  "",
-		Price: -1.0,
+		SKU:    "APP-001",
+		Name:   "",
+		Unit:   "pcs",
+		Status: models.ProductStatusActive,
+		Price:  models.DecimalFromInt(-1),
 	}
 
-	_, err = models.CreateProduct(product)
+	_, err = models.CreateProduct(alice, product)
 	assert.Error(t, err)
 	assert.Equal(t, "Validation error", err.Error())
 
@@ -217,12 +237,15 @@ func TestDeleteProductWithInvalidData(t *testing.T) {
 	models.DB = gormDB
 
 	mock.ExpectBegin()
-	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `products` WHERE `products`.`id` = ?")).
-		WithArgs(999).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(999, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).AddRow(999, "APP-001", "APPLE", "simple", "10", "USD", 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `deleted_at`=? WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(sqlmock.AnyArg(), 999).
 		WillReturnError(errors.New("Delete failed"))
 	mock.ExpectRollback()
 
-	rowsAffected, err := models.DeleteProduct(999)
+	rowsAffected, err := models.DeleteProduct(alice, 999, 0)
 	assert.Error(t, err)
 	assert.Equal(t, "Delete failed", err.Error())
 	assert.Equal(t, 0, rowsAffected)
@@ -245,25 +268,26 @@ func TestUpdateProductWithInvalidData(t *testing.T) {
 
 	models.DB = gormDB
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
+	mock.ExpectBegin()
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).
-			AddRow(1, "APPLE", 50.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price"}).
+			AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", 50.0))
 
-	expectedUpdate := "UPDATE `products` SET `name`=?,`price`=? WHERE `id` = ?"
-	mock.ExpectBegin()
+	expectedUpdate := "UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?"
 	mock.ExpectExec(regexp.QuoteMeta(expectedUpdate)).
-		WithArgs("APPLE_UPDATED", 200.0, 1).
+		WithArgs("APP-001", "APPLE_UPDATED", "", "pcs", "active", "simple", "200", "USD", nil, nil, "", "", 1, sqlmock.AnyArg(), 0, 1).
 		WillReturnError(errors.New("Update failed"))
 	mock.ExpectRollback()
 
 	updatedProduct := &models.Product{
+		SKU:   "APP-001",
 		Name:  "APPLE_UPDATED",
-		Price: 200.0,
+		Price: models.DecimalFromInt(200),
 	}
 
-	err = models.UpdateProduct(1, updatedProduct)
+	_, err = models.UpdateProduct(alice, 1, updatedProduct, 0)
 	assert.Error(t, err)
 	assert.Equal(t, "Update failed", err.Error())
 
@@ -285,12 +309,14 @@ func TestUpdateProductNotFound(t *testing.T) {
 
 	models.DB = gormDB
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
+	mock.ExpectBegin()
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
 		WillReturnError(errors.New("Product not found"))
+	mock.ExpectRollback()
 
-	err = models.UpdateProduct(1, &models.Product{Name: "UpdatedName"})
+	_, err = models.UpdateProduct(alice, 1, &models.Product{Name: "UpdatedName"}, 0)
 
 	assert.Error(t, err)
 	assert.Equal(t, "Product not found", err.Error())
@@ -338,7 +364,7 @@ func TestGetProductByIDFailure(t *testing.T) {
 	}), &gorm.Config{})
 	assert.NoError(t, err)
 
-	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?"
+	expectedQuery := "SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?"
 	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
 		WithArgs(1, 1).
 		WillReturnError(errors.New("Product not found"))
@@ -354,3 +380,298 @@ func TestGetProductByIDFailure(t *testing.T) {
 		t.Errorf("Unexpected: %s", err)
 	}
 }
+
+func TestProductNormalizeAndValidate(t *testing.T) {
+	product := &models.Product{SKU: " APP-001 ", Name: " APPLE ", Price: models.DecimalFromInt(10)}
+	product.Normalize()
+	assert.NoError(t, product.Validate())
+	assert.Equal(t, "APP-001", product.SKU)
+	assert.Equal(t, "APPLE", product.Name)
+	assert.Equal(t, models.DefaultUnit, product.Unit)
+	assert.Equal(t, models.ProductStatusActive, product.Status)
+	assert.Equal(t, models.DefaultCurrency, product.Currency)
+
+	invalid := &models.Product{Name: "APPLE", Unit: "pcs", Status: "sold", Type: "simple", Price: models.DecimalFromInt(-1), Currency: "USD"}
+	err := invalid.Validate()
+	assert.Equal(t, models.ValidationErrors{
+		"sku":    "is required",
+		"status": "must be one of draft, active, discontinued",
+		"price":  "must not be negative",
+	}, err)
+
+	// the price is checked against the decimal places of the currency
+	yen := &models.Product{SKU: "APP-001", Name: "APPLE", Price: models.MustParseDecimal("10.5"), Currency: "jpy"}
+	yen.Normalize()
+	assert.Equal(t, "JPY", yen.Currency)
+	assert.Equal(t, models.ValidationErrors{"price": "must be a whole amount of JPY"}, yen.Validate())
+
+	cents := &models.Product{SKU: "APP-001", Name: "APPLE", Price: models.MustParseDecimal("10.125"), Currency: "EUR"}
+	cents.Normalize()
+	assert.Equal(t, models.ValidationErrors{"price": "must have at most 2 decimal places for EUR"}, cents.Validate())
+
+	unknown := &models.Product{SKU: "APP-001", Name: "APPLE", Price: models.DecimalFromInt(10), Currency: "EURO"}
+	unknown.Normalize()
+	assert.Equal(t, models.ValidationErrors{"currency": "must be an ISO 4217 currency code"}, unknown.Validate())
+}
+
+func TestUpdateProductReplacesAllFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency"}).
+			AddRow(1, "APP-001", "APPLE", "Red apples", "kg", "draft", "simple", "50.0000", "USD"))
+
+	// the description and price are reset, unit and status get their defaults
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?")).
+		WithArgs("APP-001", "APPLE", "", "pcs", "active", "simple", "0", "USD", nil, nil, "", "", 1, sqlmock.AnyArg(), 0, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPrice(mock, 1, "0")
+	expectAudit(mock, "update", "product", 1, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	_, err = models.UpdateProduct(alice, 1, &models.Product{SKU: "APP-001", Name: "APPLE"}, 0)
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestPatchProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price", "currency"}).
+			AddRow(1, "APP-001", "APPLE", "Red apples", "kg", "draft", "simple", "50.0000", "USD"))
+
+	// only the price changes and null clears the description
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `sku`=?,`name`=?,`description`=?,`unit`=?,`status`=?,`type`=?,`price`=?,`currency`=?,`category_id`=?,`attributes`=?,`tags`=?,`variant_axes`=?,`version`=?,`updated_at`=? WHERE version = ? AND `products`.`deleted_at` IS NULL AND `id` = ?")).
+		WithArgs("APP-001", "APPLE", "", "kg", "draft", "simple", "0", "USD", nil, nil, "", "", 1, sqlmock.AnyArg(), 0, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectPrice(mock, 1, "0")
+	expectAudit(mock, "update", "product", 1, `{"description":{"from":"Red apples","to":""},"price":{"from":50,"to":0},"version":{"from":0,"to":1}}`)
+	mock.ExpectCommit()
+
+	product, err := models.PatchProduct(alice, 1, []byte(`{"price": 0, "description": null}`), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, product.ID)
+	assert.Equal(t, models.Decimal{}, product.Price)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestPatchProductWithInvalidFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	_, err = models.PatchProduct(alice, 1, []byte(`{"id": 2, "name": "APPLE"}`), 0)
+	assert.Equal(t, models.ValidationErrors{"id": "is read-only"}, err)
+
+	for _, patch := range []string{`[1, 2]`, `null`, `{"name":`} {
+		_, err = models.PatchProduct(alice, 1, []byte(patch), 0)
+		assert.ErrorIs(t, err, utils.ErrInvalidMergePatch, patch)
+	}
+
+	for patch, expected := range map[string]models.ValidationErrors{
+		`{"price": "free"}`:  {"price": "must be a number"},
+		`{"colour": "red"}`:  {"colour": "is not a product field"},
+		`{"name": null}`:     {"name": "is required"},
+		`{"status": "sold"}`: {"status": "must be one of draft, active, discontinued"},
+	} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+			WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "unit", "status", "type", "price"}).
+				AddRow(1, "APP-001", "APPLE", "", "pcs", "active", "simple", 50.0))
+		mock.ExpectRollback()
+
+		_, err = models.PatchProduct(alice, 1, []byte(patch), 0)
+		assert.Equal(t, expected, err, patch)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestDeleteProductWithStaleVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).AddRow(1, "APP-001", "APPLE", "simple", "10", "USD", 3))
+	mock.ExpectRollback()
+
+	_, err = models.DeleteProduct(alice, 1, 2)
+	assert.ErrorIs(t, err, models.ErrVersionConflict)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestRestoreProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "version", "deleted_at"}).
+			AddRow(1, "APP-001", "APPLE", 10.0, 2, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `version`=?,`updated_at`=?,`deleted_at`=? WHERE version = ? AND `id` = ?")).
+		WithArgs(3, sqlmock.AnyArg(), nil, 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "restore", "product", 1, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	product, err := models.RestoreProduct(alice, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, product.Version)
+	assert.False(t, product.DeletedAt.Valid)
+
+	// a product which isn't in the trash
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "version"}).
+			AddRow(2, "BAN-002", "BANANA", 3.0, 1))
+	mock.ExpectRollback()
+
+	_, err = models.RestoreProduct(alice, 2)
+	assert.ErrorIs(t, err, models.ErrProductNotDeleted)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestPurgeProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	deleted := sqlmock.NewRows([]string{"id", "sku", "version", "deleted_at"}).AddRow(1, "APP-001", 2, time.Now())
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(deleted)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `stock_movements` WHERE product_id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `reservations` WHERE product_id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `kit_components` WHERE component_id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `stock_levels` WHERE product_id = ?")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `barcodes` WHERE product_id = ?")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "purge", "product", 1, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	assert.NoError(t, models.PurgeProduct(alice, 1))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestPurgeProductWithStockMovements(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "version", "deleted_at"}).AddRow(1, "APP-001", 2, time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `stock_movements` WHERE product_id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+
+	assert.ErrorIs(t, models.PurgeProduct(alice, 1), models.ErrProductReferenced)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
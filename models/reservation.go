@@ -0,0 +1,321 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrReservationNotActive = errors.New("reservation is not active")
+
+// reservations without an expiry are released after this duration
+var DefaultReservationTTL = 24 * time.Hour
+
+type ReservationStatus string
+
+const (
+	ReservationActive    ReservationStatus = "active"
+	ReservationReleased  ReservationStatus = "released"
+	ReservationFulfilled ReservationStatus = "fulfilled"
+	ReservationExpired   ReservationStatus = "expired"
+)
+
+// Reservation holds stock of a warehouse for a pending order, the held
+// quantity is kept in StockLevel.Reserved while the reservation is active
+type Reservation struct {
+	ID          int               `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	ProductID   int               `json:"product_id" gorm:"column:product_id;index;not null"`
+	WarehouseID int               `json:"warehouse_id" gorm:"column:warehouse_id;not null"`
+	Quantity    int               `json:"quantity" gorm:"column:quantity;not null"`
+	Reference   string            `json:"reference" gorm:"column:reference;size:64"`
+	Status      ReservationStatus `json:"status" gorm:"column:status;size:16;not null;index:idx_reservations_status_expires,priority:1"`
+	ExpiresAt   time.Time         `json:"expires_at" gorm:"column:expires_at;not null;index:idx_reservations_status_expires,priority:2"`
+	CreatedBy   string            `json:"created_by" gorm:"column:created_by;size:64"`
+	CreatedAt   time.Time         `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt   time.Time         `json:"updated_at" gorm:"column:updated_at"`
+}
+
+type ReservationInput struct {
+	ProductID   int        `json:"product_id"`
+	WarehouseID int        `json:"warehouse_id"`
+	Quantity    int        `json:"quantity"`
+	Reference   string     `json:"reference"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// StockSummary is the stock of a product over all warehouses
+type StockSummary struct {
+	ProductID int `json:"-"`
+	OnHand    int `json:"on_hand"`
+	Reserved  int `json:"reserved"`
+	Available int `json:"available"`
+}
+
+// Validate checks the reservation, it returns ValidationErrors
+func (in *ReservationInput) Validate() error {
+	errs := ValidationErrors{}
+	if in.ProductID <= 0 {
+		errs["product_id"] = "is required"
+	}
+	if in.WarehouseID <= 0 {
+		errs["warehouse_id"] = "is required"
+	}
+	if in.Quantity <= 0 {
+		errs["quantity"] = "must be greater than 0"
+	}
+	if len(in.Reference) > 64 {
+		errs["reference"] = "must be at most 64 characters"
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		errs["expires_at"] = "must be in the future"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CreateReservation holds stock for an order. The reserved quantity is
+// increased with a single conditional update, so concurrent reservations for
+// the last units can't oversell.
+func CreateReservation(ctx context.Context, input *ReservationInput) (*Reservation, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(DefaultReservationTTL)
+	if input.ExpiresAt != nil {
+		expiresAt = *input.ExpiresAt
+	}
+	reservation := &Reservation{
+		ProductID:   input.ProductID,
+		WarehouseID: input.WarehouseID,
+		Quantity:    input.Quantity,
+		Reference:   input.Reference,
+		Status:      ReservationActive,
+		ExpiresAt:   expiresAt,
+		CreatedBy:   actorFrom(ctx).Username,
+	}
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var product Product
+		if err := tx.Select("id", "type").First(&product, input.ProductID).Error; err != nil {
+			return err
+		}
+		if product.Type == ProductTypeKit {
+			return ValidationErrors{"product_id": "is a kit, reserve its components"}
+		}
+		if err := tx.Select("id").First(&Warehouse{}, input.WarehouseID).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&StockLevel{}).
+			Where("product_id = ? AND warehouse_id = ? AND on_hand - reserved >= ?",
+				input.ProductID, input.WarehouseID, input.Quantity).
+			Update("reserved", gorm.Expr("reserved + ?", input.Quantity))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock
+		}
+		if err := tx.Create(reservation).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditReservation, reservation.ID, nil, reservation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func GetReservationByID(id int) (*Reservation, error) {
+	var reservation Reservation
+	if err := DB.First(&reservation, id).Error; err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// GetReservations returns the newest reservations, filtered when the arguments aren't empty
+func GetReservations(productID int, status ReservationStatus, limit int) ([]Reservation, error) {
+	reservations := []Reservation{}
+	query := DB.Order("id DESC").Limit(limit)
+	if productID != 0 {
+		query = query.Where("product_id = ?", productID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// ReleaseReservation gives the held stock back
+func ReleaseReservation(ctx context.Context, id int) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := closeReservation(tx, id, ReservationReleased)
+		return err
+	})
+}
+
+// FulfillReservation ships the held stock, it posts an issue movement for the
+// reserved quantity and closes the reservation in one transaction
+func FulfillReservation(ctx context.Context, id int) ([]StockMovement, error) {
+	batchID, err := newBatchID()
+	if err != nil {
+		return nil, err
+	}
+
+	var movements []StockMovement
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		reservation, err := closeReservation(tx, id, ReservationFulfilled)
+		if err != nil {
+			return err
+		}
+		movements, err = postMovementTx(tx, &MovementInput{
+			Type:        MovementIssue,
+			ProductID:   reservation.ProductID,
+			WarehouseID: reservation.WarehouseID,
+			Quantity:    reservation.Quantity,
+			Reference:   reservation.Reference,
+			Note:        "reservation fulfilled",
+		}, batchID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// audit actions of the statuses closeReservation moves to
+var reservationActions = map[ReservationStatus]string{
+	ReservationReleased:  "release",
+	ReservationFulfilled: "fulfill",
+	ReservationExpired:   "expire",
+}
+
+// closeReservation moves an active reservation to the status and gives its
+// quantity back to the available stock
+func closeReservation(tx *gorm.DB, id int, status ReservationStatus) (*Reservation, error) {
+	var reservation Reservation
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&reservation, id).Error
+	if err != nil {
+		return nil, err
+	}
+	if reservation.Status != ReservationActive {
+		return nil, ErrReservationNotActive
+	}
+
+	before := reservation
+	if err := tx.Model(&reservation).Update("status", status).Error; err != nil {
+		return nil, err
+	}
+	if err := recordAudit(tx, reservationActions[status], AuditReservation, id, &before, &reservation); err != nil {
+		return nil, err
+	}
+	err = tx.Model(&StockLevel{}).
+		Where("product_id = ? AND warehouse_id = ?", reservation.ProductID, reservation.WarehouseID).
+		Update("reserved", gorm.Expr("reserved - ?", reservation.Quantity)).Error
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// ReleaseExpiredReservations expires the active reservations whose time is up
+// and returns how many were released, it is run by the background sweeper
+func ReleaseExpiredReservations() (int, error) {
+	var ids []int
+	err := DB.Model(&Reservation{}).
+		Where("status = ? AND expires_at < ?", ReservationActive, time.Now()).
+		Order("id").Limit(500).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range ids {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			_, err := closeReservation(tx, id, ReservationExpired)
+			return err
+		})
+		if errors.Is(err, ErrReservationNotActive) {
+			// released or fulfilled in the meantime
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// GetStockSummaries returns the stock over all warehouses for each product
+func GetStockSummaries(productIDs []int) (map[int]StockSummary, error) {
+	summaries := map[int]StockSummary{}
+	if len(productIDs) == 0 {
+		return summaries, nil
+	}
+
+	var rows []StockSummary
+	err := DB.Model(&StockLevel{}).
+		Select("product_id, SUM(on_hand) AS on_hand, SUM(reserved) AS reserved").
+		Where("product_id IN ?", productIDs).
+		Group("product_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		row.Available = row.OnHand - row.Reserved
+		summaries[row.ProductID] = row
+	}
+	return summaries, nil
+}
+
+// AttachStock fills in the stock of the products, the stock of kits is
+// computed from their components
+func AttachStock(products []Product) error {
+	var ids, kitIDs []int
+	for i := range products {
+		if products[i].Type == ProductTypeKit {
+			kitIDs = append(kitIDs, products[i].ID)
+			continue
+		}
+		ids = append(ids, products[i].ID)
+	}
+	summaries, err := GetStockSummaries(ids)
+	if err != nil {
+		return err
+	}
+	if len(kitIDs) > 0 {
+		levels, err := kitStockLevels(DB, kitIDs)
+		if err != nil {
+			return err
+		}
+		for _, id := range kitIDs {
+			summary := StockSummary{ProductID: id}
+			for _, level := range levels[id] {
+				summary.OnHand += level.OnHand
+				summary.Reserved += level.Reserved
+				summary.Available += level.Available
+			}
+			summaries[id] = summary
+		}
+	}
+	for i := range products {
+		summary := summaries[products[i].ID]
+		products[i].Stock = &summary
+	}
+	return nil
+}
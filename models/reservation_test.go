@@ -0,0 +1,147 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestCreateReservation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`type` FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(5, "simple"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `warehouses` WHERE `warehouses`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_levels` SET `reserved`=reserved + ?,`updated_at`=? WHERE product_id = ? AND warehouse_id = ? AND on_hand - reserved >= ?")).
+		WithArgs(3, sqlmock.AnyArg(), 5, 2, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `reservations`")).
+		WithArgs(5, 2, 3, "SO-1", "active", sqlmock.AnyArg(), "alice", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(8, 1))
+	expectAudit(mock, "create", "reservation", 8, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	reservation, err := models.CreateReservation(alice, &models.ReservationInput{
+		ProductID:   5,
+		WarehouseID: 2,
+		Quantity:    3,
+		Reference:   "SO-1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 8, reservation.ID)
+	assert.Equal(t, models.ReservationActive, reservation.Status)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestCreateReservationCannotOversell(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`type` FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(5, "simple"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `warehouses` WHERE `warehouses`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	// another reservation took the last units first, the condition doesn't match anymore
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_levels` SET `reserved`=reserved + ?")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	reservation, err := models.CreateReservation(alice, &models.ReservationInput{
+		ProductID:   5,
+		WarehouseID: 2,
+		Quantity:    3,
+	})
+	assert.ErrorIs(t, err, models.ErrInsufficientStock)
+	assert.Nil(t, reservation)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestReleaseExpiredReservations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id` FROM `reservations` WHERE status = ? AND expires_at < ? ORDER BY id LIMIT ?")).
+		WithArgs("active", sqlmock.AnyArg(), 500).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+	// reservation 1 expires
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `reservations` WHERE `reservations`.`id` = ? ORDER BY `reservations`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "warehouse_id", "quantity", "status", "expires_at"}).
+			AddRow(1, 5, 2, 3, "active", time.Now().Add(-time.Minute)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `reservations` SET `status`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs("expired", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// the sweeper has no user of its own
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `audit_entries`")).
+		WithArgs("system", "expire", "reservation", 1, `{"status":{"from":"active","to":"expired"}}`, "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_levels` SET `reserved`=reserved - ?,`updated_at`=? WHERE product_id = ? AND warehouse_id = ?")).
+		WithArgs(3, sqlmock.AnyArg(), 5, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// reservation 2 was fulfilled in the meantime
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `reservations` WHERE `reservations`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "warehouse_id", "quantity", "status"}).
+			AddRow(2, 5, 2, 1, "fulfilled"))
+	mock.ExpectRollback()
+
+	released, err := models.ReleaseExpiredReservations()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,67 @@
+package models
+
+type Role string
+
+const (
+	RoleViewer  Role = "viewer"
+	RoleClerk   Role = "clerk"
+	RoleManager Role = "manager"
+	RoleAdmin   Role = "admin"
+)
+
+// permissions checked by middlewares.RequirePermission
+const (
+	PermProductsRead   = "products:read"
+	PermProductsWrite  = "products:write"
+	PermProductsDelete = "products:delete"
+	PermProductsPurge  = "products:purge"
+	PermUsersManage    = "users:manage"
+	PermAuditRead      = "audit:read"
+
+	PermCategoriesManage    = "categories:manage"
+	PermAttributesManage    = "attributes:manage"
+	PermPriceListsManage    = "price_lists:manage"
+	PermExchangeRatesManage = "exchange_rates:manage"
+
+	PermStockRead        = "stock:read"
+	PermStockWrite       = "stock:write"
+	PermWarehousesManage = "warehouses:manage"
+)
+
+// every role includes the permissions of the roles before it
+var rolePermissions = map[Role][]string{
+	RoleViewer: {PermProductsRead, PermStockRead},
+	RoleClerk: {PermProductsRead, PermProductsWrite,
+		PermStockRead, PermStockWrite},
+	RoleManager: {PermProductsRead, PermProductsWrite, PermProductsDelete,
+		PermStockRead, PermStockWrite, PermWarehousesManage, PermAuditRead,
+		PermPriceListsManage, PermCategoriesManage, PermAttributesManage},
+	RoleAdmin: {PermProductsRead, PermProductsWrite, PermProductsDelete,
+		PermStockRead, PermStockWrite, PermWarehousesManage, PermAuditRead,
+		PermPriceListsManage, PermCategoriesManage, PermAttributesManage, PermUsersManage, PermProductsPurge, PermExchangeRatesManage},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission
+func (r Role) HasPermission(permission string) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits reports whether a request may use the permission: the role has to
+// grant it and, for a request authenticated by an api key, the scopes of the
+// key have to hold it
+func Permits(role Role, apiKeyScopes *StringList, permission string) bool {
+	if !role.HasPermission(permission) {
+		return false
+	}
+	return apiKeyScopes == nil || apiKeyScopes.Contains(permission)
+}
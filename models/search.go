@@ -0,0 +1,200 @@
+package models
+
+import (
+	"math"
+	"myapp/search"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// upper limits of a search
+const (
+	MaxSearchQueryLength = 200
+	MaxSearchOffset      = 10000
+)
+
+// the fields of a product the search looks at, a match in the SKU counts
+// three times a match in the description
+var productSearchWeights = map[string]float64{"sku": 3, "name": 2, "tags": 1.5, "description": 1}
+
+// productSearch holds the search index of the products. Writes mark the
+// products they touched as stale once their transaction ended, the next
+// search reads them again. That way rolled back writes never reach the index.
+var productSearch = struct {
+	sync.Mutex
+	refresh  sync.Mutex // held by a refresh from reading the products to indexing them
+	index    search.Index
+	stale    map[int]bool
+	building bool
+	written  map[int]bool // marked stale while the index was being built
+}{
+	index: search.NewMemoryIndex(productSearchWeights),
+	stale: map[int]bool{},
+}
+
+// markSearchStale is called after the transaction which wrote the products,
+// committed or not
+func markSearchStale(ids ...int) {
+	productSearch.Lock()
+	defer productSearch.Unlock()
+	for _, id := range ids {
+		if id == 0 {
+			continue
+		}
+		productSearch.stale[id] = true
+		if productSearch.building {
+			productSearch.written[id] = true
+		}
+	}
+}
+
+func productDocument(p *Product) search.Document {
+	return search.Document{ID: p.ID, Fields: map[string]string{
+		"sku":         p.SKU,
+		"name":        p.Name,
+		"description": p.Description,
+		"tags":        strings.Join(p.Tags, ", "),
+	}}
+}
+
+// the columns of the products the index needs
+var productSearchColumns = []string{"id", "sku", "name", "description", "tags", "deleted_at"}
+
+// refreshSearchIndex reads the stale products again, deleted products leave
+// the index. Refreshes run one after the other, otherwise a refresh could
+// index what it read after a later refresh indexed a newer read.
+func refreshSearchIndex() (search.Index, error) {
+	productSearch.refresh.Lock()
+	defer productSearch.refresh.Unlock()
+
+	productSearch.Lock()
+	index, stale := productSearch.index, productSearch.stale
+	productSearch.stale = map[int]bool{}
+	productSearch.Unlock()
+	if len(stale) == 0 {
+		return index, nil
+	}
+
+	ids := make([]int, 0, len(stale))
+	for id := range stale {
+		ids = append(ids, id)
+	}
+	var products []Product
+	if err := DB.Unscoped().Select(productSearchColumns).Where("id IN ?", ids).Find(&products).Error; err != nil {
+		// they are read again by the next search
+		markSearchStale(ids...)
+		return nil, err
+	}
+	for i := range products {
+		delete(stale, products[i].ID)
+		if products[i].DeletedAt.Valid {
+			index.Remove(products[i].ID)
+		} else {
+			index.Put(productDocument(&products[i]))
+		}
+	}
+	// purged products
+	for id := range stale {
+		index.Remove(id)
+	}
+	return index, nil
+}
+
+// BuildSearchIndex indexes every product in a new index which then replaces
+// the current one, it returns the number of indexed products
+func BuildSearchIndex() (int, error) {
+	productSearch.Lock()
+	stale := productSearch.stale
+	productSearch.stale = map[int]bool{}
+	productSearch.building, productSearch.written = true, map[int]bool{}
+	productSearch.Unlock()
+
+	index := search.NewMemoryIndex(productSearchWeights)
+	var batch []Product
+	err := DB.Select(productSearchColumns).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			index.Put(productDocument(&batch[i]))
+		}
+		return nil
+	}).Error
+
+	productSearch.Lock()
+	defer productSearch.Unlock()
+	// products written while the table was read may be indexed as they
+	// were before
+	for id := range productSearch.written {
+		productSearch.stale[id] = true
+	}
+	productSearch.building, productSearch.written = false, nil
+	if err != nil {
+		for id := range stale {
+			productSearch.stale[id] = true
+		}
+		return 0, err
+	}
+	productSearch.index = index
+	return index.Len(), nil
+}
+
+type ProductSearch struct {
+	Query  string
+	Offset int
+	Limit  int
+}
+
+// SearchResult is a product found by a search, Highlights holds the
+// matching fields with the matched words in <em>
+type SearchResult struct {
+	Product    Product           `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	Total      int            `json:"total"`
+	NextOffset *int           `json:"next_offset"` // nil on the last page
+}
+
+// SearchProducts finds the products matching every word of the query in
+// their SKU, name, description or tags, best matches first
+func SearchProducts(q ProductSearch) (*SearchPage, error) {
+	index, err := refreshSearchIndex()
+	if err != nil {
+		return nil, err
+	}
+	hits, total := index.Search(q.Query, q.Offset, q.Limit)
+	page := &SearchPage{Results: []SearchResult{}, Total: total}
+	if next := q.Offset + q.Limit; next < total {
+		page.NextOffset = &next
+	}
+	if len(hits) == 0 {
+		return page, nil
+	}
+
+	ids := make([]int, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	var products []Product
+	if err := DB.Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	if err := resolveEffectivePrices(products); err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Product, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+	// a product deleted since the index was refreshed is left out
+	for _, hit := range hits {
+		if product, ok := byID[hit.ID]; ok {
+			score := math.Round(hit.Score*1000) / 1000
+			page.Results = append(page.Results, SearchResult{Product: product, Score: score, Highlights: hit.Highlights})
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,109 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestSearchProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	columns := []string{"id", "sku", "name", "description", "tags", "deleted_at"}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`sku`,`name`,`description`,`tags`,`deleted_at` FROM `products` WHERE `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ?")).
+		WithArgs(500).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "APP-001", "Apple", "A crisp red apple", "fruit", nil).
+			AddRow(2, "BAN-001", "Banana", "Sweet and yellow", "fruit", nil).
+			AddRow(3, "PIE-001", "Apple Pie", "Baked with Crème fraîche", "dessert, bakery", nil))
+
+	indexed, err := models.BuildSearchIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, indexed)
+
+	// the name and the description of the apple match, only the products of
+	// the page are read
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE id IN (?) AND `products`.`deleted_at` IS NULL")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).
+			AddRow(1, "APP-001", "Apple", "simple", "0.5", "USD", 1))
+	expectDuePrices(mock, 1)
+
+	page, err := models.SearchProducts(models.ProductSearch{Query: "APPLE", Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, 1, *page.NextOffset)
+	if assert.Len(t, page.Results, 1) {
+		assert.Equal(t, 1, page.Results[0].Product.ID)
+		assert.Equal(t, map[string]string{"name": "<em>Apple</em>", "description": "A crisp red <em>apple</em>"}, page.Results[0].Highlights)
+	}
+
+	// accents are ignored
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE id IN (?) AND `products`.`deleted_at` IS NULL")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).
+			AddRow(3, "PIE-001", "Apple Pie", "simple", "4.5", "USD", 1))
+	expectDuePrices(mock, 3)
+
+	page, err = models.SearchProducts(models.ProductSearch{Query: "creme", Limit: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+	assert.Nil(t, page.NextOffset)
+	if assert.Len(t, page.Results, 1) {
+		assert.Equal(t, map[string]string{"description": "Baked with <em>Crème</em> fraîche"}, page.Results[0].Highlights)
+	}
+
+	// a deleted product leaves the index with the next search
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).AddRow(1, "APP-001", "APPLE", "simple", "10", "USD", 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET `deleted_at`=? WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL")).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAudit(mock, "delete", "product", 1, sqlmock.AnyArg())
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`sku`,`name`,`description`,`tags`,`deleted_at` FROM `products` WHERE id IN (?)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "APP-001", "Apple", "A crisp red apple", "fruit", time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE id IN (?) AND `products`.`deleted_at` IS NULL")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "type", "price", "currency", "version"}).
+			AddRow(3, "PIE-001", "Apple Pie", "simple", "4.5", "USD", 1))
+	expectDuePrices(mock, 3)
+
+	_, err = models.DeleteProduct(alice, 1, 0)
+	assert.NoError(t, err)
+	page, err = models.SearchProducts(models.ProductSearch{Query: "apple", Limit: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+	if assert.Len(t, page.Results, 1) {
+		assert.Equal(t, 3, page.Results[0].Product.ID)
+	}
+
+	// no match doesn't read the products
+	page, err = models.SearchProducts(models.ProductSearch{Query: "cherry", Limit: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, page.Total)
+	assert.Empty(t, page.Results)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,412 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+type MovementType string
+
+const (
+	MovementReceipt    MovementType = "receipt"
+	MovementIssue      MovementType = "issue"
+	MovementAdjustment MovementType = "adjustment"
+	MovementTransfer   MovementType = "transfer"
+)
+
+// StockLevel is the on hand quantity of a product in a warehouse, it is kept
+// in step with the stock movement ledger
+type StockLevel struct {
+	ID          int       `json:"-" gorm:"column:id;primaryKey;autoIncrement"`
+	ProductID   int       `json:"product_id" gorm:"column:product_id;not null;uniqueIndex:idx_stock_levels_product_warehouse,priority:1"`
+	WarehouseID int       `json:"warehouse_id" gorm:"column:warehouse_id;not null;uniqueIndex:idx_stock_levels_product_warehouse,priority:2;index"`
+	OnHand      int       `json:"on_hand" gorm:"column:on_hand;not null;default:0"`
+	Reserved    int       `json:"reserved" gorm:"column:reserved;not null;default:0"`
+	Available   int       `json:"available" gorm:"-"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (l *StockLevel) AfterFind(tx *gorm.DB) error {
+	l.Available = l.OnHand - l.Reserved
+	return nil
+}
+
+// StockMovement is an entry of the ledger. Quantity is the signed change of
+// the on hand quantity in the warehouse, a transfer is written as two entries
+// sharing the same BatchID.
+type StockMovement struct {
+	ID          int          `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	BatchID     string       `json:"batch_id" gorm:"column:batch_id;size:32;index;not null"`
+	ProductID   int          `json:"product_id" gorm:"column:product_id;index;not null"`
+	WarehouseID int          `json:"warehouse_id" gorm:"column:warehouse_id;index;not null"`
+	Type        MovementType `json:"type" gorm:"column:type;size:16;not null"`
+	Quantity    int          `json:"quantity" gorm:"column:quantity;not null"`
+	Reference   string       `json:"reference" gorm:"column:reference;size:64"`
+	Note        string       `json:"note" gorm:"column:note;size:255"`
+	KitID       *int         `json:"kit_id,omitempty" gorm:"column:kit_id"` // the kit sold, for issues of its components
+	CreatedBy   string       `json:"created_by" gorm:"column:created_by;size:64"`
+	CreatedAt   time.Time    `json:"created_at" gorm:"column:created_at"`
+}
+
+// MovementInput is a movement requested by a client
+type MovementInput struct {
+	Type          MovementType `json:"type"`
+	ProductID     int          `json:"product_id"`
+	WarehouseID   int          `json:"warehouse_id"`
+	ToWarehouseID int          `json:"to_warehouse_id"` // destination of a transfer
+	Quantity      int          `json:"quantity"`        // signed for adjustments, positive otherwise
+	Reference     string       `json:"reference"`
+	Note          string       `json:"note"`
+}
+
+// StockDiscrepancy is a stock level that doesn't match the sum of its ledger entries
+type StockDiscrepancy struct {
+	ProductID   int `json:"product_id"`
+	WarehouseID int `json:"warehouse_id"`
+	OnHand      int `json:"on_hand"`
+	Ledger      int `json:"ledger"`
+}
+
+type stockLeg struct {
+	warehouseID int
+	delta       int
+}
+
+// Validate checks the movement, it returns ValidationErrors
+func (in *MovementInput) Validate() error {
+	errs := ValidationErrors{}
+	if in.ProductID <= 0 {
+		errs["product_id"] = "is required"
+	}
+	if in.WarehouseID <= 0 {
+		errs["warehouse_id"] = "is required"
+	}
+	switch in.Type {
+	case MovementReceipt, MovementIssue, MovementTransfer:
+		if in.Quantity <= 0 {
+			errs["quantity"] = "must be greater than 0"
+		}
+	case MovementAdjustment:
+		if in.Quantity == 0 {
+			errs["quantity"] = "must not be 0"
+		}
+	default:
+		errs["type"] = "must be one of receipt, issue, adjustment, transfer"
+	}
+	if in.Type == MovementTransfer {
+		if in.ToWarehouseID <= 0 {
+			errs["to_warehouse_id"] = "is required for a transfer"
+		} else if in.ToWarehouseID == in.WarehouseID {
+			errs["to_warehouse_id"] = "must differ from warehouse_id"
+		}
+	} else if in.ToWarehouseID != 0 {
+		errs["to_warehouse_id"] = "is only allowed for a transfer"
+	}
+	if len(in.Reference) > 64 {
+		errs["reference"] = "must be at most 64 characters"
+	}
+	if len(in.Note) > 255 {
+		errs["note"] = "must be at most 255 characters"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (in *MovementInput) legs() []stockLeg {
+	switch in.Type {
+	case MovementReceipt, MovementAdjustment:
+		return []stockLeg{{in.WarehouseID, in.Quantity}}
+	case MovementIssue:
+		return []stockLeg{{in.WarehouseID, -in.Quantity}}
+	case MovementTransfer:
+		return []stockLeg{{in.WarehouseID, -in.Quantity}, {in.ToWarehouseID, in.Quantity}}
+	}
+	return nil
+}
+
+// PostMovement writes the movement to the ledger and updates the stock levels
+// in one transaction. It fails with ErrInsufficientStock when the stock of a
+// warehouse would go negative and the warehouse doesn't allow it. Issues and
+// transfers can't take stock that is held by reservations.
+func PostMovement(ctx context.Context, input *MovementInput) ([]StockMovement, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	batchID, err := newBatchID()
+	if err != nil {
+		return nil, err
+	}
+
+	var movements []StockMovement
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		movements, err = postMovementTx(tx, input, batchID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// postMovementTx writes the movement, the actor comes from the context of tx
+func postMovementTx(tx *gorm.DB, input *MovementInput, batchID string) ([]StockMovement, error) {
+	var product Product
+	if err := tx.Select("id", "type").First(&product, input.ProductID).Error; err != nil {
+		return nil, err
+	}
+	if product.Type == ProductTypeKit {
+		return postKitIssueTx(tx, input, batchID)
+	}
+
+	// lock the rows in a fixed order so that opposite transfers can't deadlock
+	legs := input.legs()
+	locked := append([]stockLeg(nil), legs...)
+	sort.Slice(locked, func(i, j int) bool { return locked[i].warehouseID < locked[j].warehouseID })
+	respectReservations := input.Type != MovementAdjustment
+	for _, leg := range locked {
+		if err := applyStockDelta(tx, input.ProductID, leg.warehouseID, leg.delta, respectReservations); err != nil {
+			return nil, err
+		}
+	}
+
+	var movements []StockMovement
+	for _, leg := range legs {
+		movements = append(movements, StockMovement{
+			BatchID:     batchID,
+			ProductID:   input.ProductID,
+			WarehouseID: leg.warehouseID,
+			Type:        input.Type,
+			Quantity:    leg.delta,
+			Reference:   input.Reference,
+			Note:        input.Note,
+			CreatedBy:   actorFrom(tx.Statement.Context).Username,
+		})
+	}
+	if err := tx.Create(&movements).Error; err != nil {
+		return nil, err
+	}
+	for i := range movements {
+		if err := recordAudit(tx, "create", AuditStockMovement, movements[i].ID, nil, &movements[i]); err != nil {
+			return nil, err
+		}
+	}
+	return movements, nil
+}
+
+// postKitIssueTx sells kits: it issues the parts of the kits, all or none.
+// Kits have no stock of their own, so they can only be issued.
+func postKitIssueTx(tx *gorm.DB, input *MovementInput, batchID string) ([]StockMovement, error) {
+	if input.Type != MovementIssue {
+		return nil, ValidationErrors{"type": "must be issue for a kit, move its components instead"}
+	}
+	parts, err := kitParts(tx, []int{input.ProductID})
+	if err != nil {
+		return nil, err
+	}
+	partIDs := make([]int, 0, len(parts[input.ProductID]))
+	for id := range parts[input.ProductID] {
+		partIDs = append(partIDs, id)
+	}
+	if len(partIDs) == 0 {
+		return nil, ValidationErrors{"product_id": "is a kit without components"}
+	}
+	// lock the rows in a fixed order, like postMovementTx
+	sort.Ints(partIDs)
+	var emptyKits []int
+	if err := tx.Model(&Product{}).Where("id IN ? AND type = ?", partIDs, ProductTypeKit).Pluck("id", &emptyKits).Error; err != nil {
+		return nil, err
+	}
+	if len(emptyKits) > 0 {
+		return nil, ValidationErrors{"product_id": fmt.Sprintf("contains kit %d which has no components", emptyKits[0])}
+	}
+
+	var movements []StockMovement
+	for _, id := range partIDs {
+		quantity := parts[input.ProductID][id] * input.Quantity
+		if err := applyStockDelta(tx, id, input.WarehouseID, -quantity, true); err != nil {
+			return nil, err
+		}
+		movements = append(movements, StockMovement{
+			BatchID:     batchID,
+			ProductID:   id,
+			WarehouseID: input.WarehouseID,
+			Type:        MovementIssue,
+			Quantity:    -quantity,
+			Reference:   input.Reference,
+			Note:        input.Note,
+			KitID:       &input.ProductID,
+			CreatedBy:   actorFrom(tx.Statement.Context).Username,
+		})
+	}
+	if err := tx.Create(&movements).Error; err != nil {
+		return nil, err
+	}
+	for i := range movements {
+		if err := recordAudit(tx, "create", AuditStockMovement, movements[i].ID, nil, &movements[i]); err != nil {
+			return nil, err
+		}
+	}
+	return movements, nil
+}
+
+// applyStockDelta locks the stock level row and changes its on hand quantity
+func applyStockDelta(tx *gorm.DB, productID, warehouseID, delta int, respectReservations bool) error {
+	var warehouse Warehouse
+	if err := tx.First(&warehouse, warehouseID).Error; err != nil {
+		return err
+	}
+
+	// make sure the row exists so that it can be locked
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&StockLevel{ProductID: productID, WarehouseID: warehouseID}).Error
+	if err != nil {
+		return err
+	}
+
+	var level StockLevel
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND warehouse_id = ?", productID, warehouseID).
+		First(&level).Error
+	if err != nil {
+		return err
+	}
+
+	onHand := level.OnHand + delta
+	if delta < 0 && !warehouse.AllowNegative {
+		if onHand < 0 {
+			return fmt.Errorf("%w: warehouse %s has %d on hand", ErrInsufficientStock, warehouse.Code, level.OnHand)
+		}
+		if respectReservations && onHand < level.Reserved {
+			return fmt.Errorf("%w: warehouse %s has %d available", ErrInsufficientStock, warehouse.Code, level.Available)
+		}
+	}
+	return tx.Model(&level).Update("on_hand", onHand).Error
+}
+
+// GetStockByProduct returns the stock levels of the product in every warehouse
+func GetStockByProduct(productID int) ([]StockLevel, error) {
+	levels := []StockLevel{}
+	if err := DB.Where("product_id = ?", productID).Order("warehouse_id").Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// GetStockByWarehouse returns the stock levels of every product in the warehouse
+func GetStockByWarehouse(warehouseID int) ([]StockLevel, error) {
+	levels := []StockLevel{}
+	if err := DB.Where("warehouse_id = ?", warehouseID).Order("product_id").Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// GetMovements returns the newest ledger entries, filtered when the ids aren't 0
+func GetMovements(productID, warehouseID, limit int) ([]StockMovement, error) {
+	movements := []StockMovement{}
+	query := DB.Order("id DESC").Limit(limit)
+	if productID != 0 {
+		query = query.Where("product_id = ?", productID)
+	}
+	if warehouseID != 0 {
+		query = query.Where("warehouse_id = ?", warehouseID)
+	}
+	if err := query.Find(&movements).Error; err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// ReconcileStock compares every stock level with the sum of its ledger
+// entries, with fix the stock levels are reset to the ledger
+func ReconcileStock(ctx context.Context, fix bool) ([]StockDiscrepancy, error) {
+	discrepancies := []StockDiscrepancy{}
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ledger []StockDiscrepancy
+		err := tx.Model(&StockMovement{}).
+			Select("product_id, warehouse_id, SUM(quantity) AS ledger").
+			Group("product_id, warehouse_id").
+			Scan(&ledger).Error
+		if err != nil {
+			return err
+		}
+
+		var levels []StockLevel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Find(&levels).Error; err != nil {
+			return err
+		}
+
+		type key struct{ product, warehouse int }
+		expected := map[key]int{}
+		for _, l := range ledger {
+			expected[key{l.ProductID, l.WarehouseID}] = l.Ledger
+		}
+		actual := map[key]int{}
+		for _, l := range levels {
+			actual[key{l.ProductID, l.WarehouseID}] = l.OnHand
+		}
+		for k, onHand := range actual {
+			if onHand != expected[k] {
+				discrepancies = append(discrepancies, StockDiscrepancy{k.product, k.warehouse, onHand, expected[k]})
+			}
+		}
+		for k, sum := range expected {
+			if _, ok := actual[k]; !ok && sum != 0 {
+				discrepancies = append(discrepancies, StockDiscrepancy{k.product, k.warehouse, 0, sum})
+			}
+		}
+		sort.Slice(discrepancies, func(i, j int) bool {
+			if discrepancies[i].ProductID != discrepancies[j].ProductID {
+				return discrepancies[i].ProductID < discrepancies[j].ProductID
+			}
+			return discrepancies[i].WarehouseID < discrepancies[j].WarehouseID
+		})
+
+		if !fix {
+			return nil
+		}
+		for _, d := range discrepancies {
+			level := StockLevel{ProductID: d.ProductID, WarehouseID: d.WarehouseID, OnHand: d.Ledger}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "product_id"}, {Name: "warehouse_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"on_hand", "updated_at"}),
+			}).Create(&level).Error
+			if err != nil {
+				return err
+			}
+			// the stock levels aren't exposed, the fix is recorded on the product
+			field := fmt.Sprintf("stock[%d].on_hand", d.WarehouseID)
+			before := map[string]int{field: d.OnHand}
+			after := map[string]int{field: d.Ledger}
+			if err := recordAudit(tx, "reconcile", AuditProduct, d.ProductID, before, after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return discrepancies, nil
+}
+
+func newBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,117 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestPostMovementReceipt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`type` FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(5, "simple"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `warehouses` WHERE `warehouses`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "allow_negative"}).AddRow(2, "TPE", false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `stock_levels`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `stock_levels` WHERE product_id = ? AND warehouse_id = ? ORDER BY `stock_levels`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(5, 2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "warehouse_id", "on_hand"}).AddRow(11, 5, 2, 3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_levels` SET `on_hand`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs(13, sqlmock.AnyArg(), 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `stock_movements`")).
+		WithArgs(sqlmock.AnyArg(), 5, 2, "receipt", 10, "PO-1", "", nil, "alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAudit(mock, "create", "stock_movement", 1, sqlmock.AnyArg())
+	mock.ExpectCommit()
+
+	movements, err := models.PostMovement(alice, &models.MovementInput{
+		Type:        models.MovementReceipt,
+		ProductID:   5,
+		WarehouseID: 2,
+		Quantity:    10,
+		Reference:   "PO-1",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, movements, 1)
+	assert.Equal(t, 10, movements[0].Quantity)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestPostMovementInsufficientStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`type` FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(5, "simple"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `warehouses` WHERE `warehouses`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "allow_negative"}).AddRow(2, "TPE", false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `stock_levels`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `stock_levels` WHERE product_id = ? AND warehouse_id = ?")).
+		WithArgs(5, 2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "warehouse_id", "on_hand"}).AddRow(11, 5, 2, 3))
+	mock.ExpectRollback()
+
+	movements, err := models.PostMovement(alice, &models.MovementInput{
+		Type:        models.MovementIssue,
+		ProductID:   5,
+		WarehouseID: 2,
+		Quantity:    4,
+	})
+	assert.ErrorIs(t, err, models.ErrInsufficientStock)
+	assert.Nil(t, movements)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestMovementInputValidate(t *testing.T) {
+	transfer := &models.MovementInput{Type: models.MovementTransfer, ProductID: 1, WarehouseID: 2, ToWarehouseID: 2, Quantity: 1}
+	assert.Equal(t, models.ValidationErrors{"to_warehouse_id": "must differ from warehouse_id"}, transfer.Validate())
+
+	adjustment := &models.MovementInput{Type: models.MovementAdjustment, ProductID: 1, WarehouseID: 2, Quantity: -3}
+	assert.NoError(t, adjustment.Validate())
+
+	issue := &models.MovementInput{Type: models.MovementIssue, ProductID: 1, WarehouseID: 2, Quantity: -3}
+	assert.Equal(t, models.ValidationErrors{"quantity": "must be greater than 0"}, issue.Validate())
+
+	unknown := &models.MovementInput{Type: "sale", ProductID: 1, WarehouseID: 2, Quantity: 3}
+	assert.Equal(t, models.ValidationErrors{"type": "must be one of receipt, issue, adjustment, transfer"}, unknown.Validate())
+}
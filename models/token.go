@@ -0,0 +1,265 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// lifetime of a refresh token, every refresh issues a new one
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// how long a "not revoked" answer is cached before the table is asked again
+var RevocationCacheTTL = 30 * time.Second
+
+// RefreshToken is a single use token, all tokens rotated from the same login
+// share a family which is revoked as a whole on logout or on reuse
+type RefreshToken struct {
+	ID        int        `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	TokenHash string     `json:"-" gorm:"column:token_hash;size:64;uniqueIndex;not null"`
+	FamilyID  string     `json:"family_id" gorm:"column:family_id;size:32;index;not null"`
+	UserID    int        `json:"user_id" gorm:"column:user_id;index;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"column:expires_at;not null"`
+	UsedAt    *time.Time `json:"used_at" gorm:"column:used_at"`
+	RevokedAt *time.Time `json:"revoked_at" gorm:"column:revoked_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"column:created_at"`
+}
+
+// RevokedToken is an access token (by jti) that must no longer be accepted
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"column:jti;primaryKey;size:32"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"column:expires_at;index;not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken stores a new refresh token of the family and returns the raw token
+func IssueRefreshToken(tx *gorm.DB, userID int, familyID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(b)
+
+	token := &RefreshToken{
+		TokenHash: hashToken(raw),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := tx.Create(token).Error; err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// RotateRefreshToken consumes the refresh token and issues the next one of the
+// same family. Presenting an already used token revokes the whole family.
+func RotateRefreshToken(raw string) (*User, string, string, error) {
+	var user User
+	var familyID, next string
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var token RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(raw)).First(&token).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		familyID = token.FamilyID
+		if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if token.UsedAt != nil {
+			return ErrRefreshTokenReused
+		}
+
+		if err := tx.First(&user, token.UserID).Error; err != nil {
+			return err
+		}
+		if user.Disabled {
+			return ErrUserDisabled
+		}
+
+		if err := tx.Model(&token).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		next, err = IssueRefreshToken(tx, user.ID, familyID)
+		return err
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		// the token was stolen or replayed, nobody may keep using this family
+		if revokeErr := RevokeTokenFamily(familyID); revokeErr != nil {
+			return nil, "", "", revokeErr
+		}
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	return &user, familyID, next, nil
+}
+
+// RevokeTokenFamily revokes every refresh token of the family and with them
+// the access tokens issued for it
+func RevokeTokenFamily(familyID string) error {
+	err := DB.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	revocations.revoke(func(entry revocationEntry) bool { return entry.familyID == familyID })
+	return nil
+}
+
+// RevokeUserTokens revokes every refresh token of the user and with them the
+// access tokens issued for them
+func RevokeUserTokens(userID int) error {
+	err := DB.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	revocations.revoke(func(entry revocationEntry) bool { return entry.userID == userID })
+	return nil
+}
+
+// RevokeToken stores the jti of an access token in the revocation table
+func RevokeToken(jti string, expiresAt time.Time) error {
+	revoked := &RevokedToken{JTI: jti, ExpiresAt: expiresAt}
+	if err := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(revoked).Error; err != nil {
+		return err
+	}
+	revocations.set(jti, revocationEntry{revoked: true, until: expiresAt})
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token was revoked: by logout, with
+// its refresh token family or because its user was disabled. Answers are kept
+// in an in-process cache in front of the tables
+func IsTokenRevoked(jti, familyID string, userID int) (bool, error) {
+	if revoked, ok := revocations.get(jti); ok {
+		return revoked, nil
+	}
+
+	var revoked RevokedToken
+	err := DB.Where("jti = ?", jti).Limit(1).Find(&revoked).Error
+	if err != nil {
+		return false, err
+	}
+	if revoked.JTI != "" {
+		revocations.set(jti, revocationEntry{revoked: true, until: revoked.ExpiresAt})
+		return true, nil
+	}
+
+	// logout only stores the jti of the token it was called with, the other
+	// access tokens of the login are revoked with the family
+	var familyRevoked int64
+	if familyID != "" {
+		err = DB.Model(&RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NOT NULL", familyID).
+			Count(&familyRevoked).Error
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var user User
+	result := DB.Select("id", "disabled").Where("id = ?", userID).Limit(1).Find(&user)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	entry := revocationEntry{
+		revoked:  familyRevoked > 0 || result.RowsAffected == 0 || user.Disabled,
+		until:    time.Now().Add(RevocationCacheTTL),
+		familyID: familyID,
+		userID:   userID,
+	}
+	revocations.set(jti, entry)
+	return entry.revoked, nil
+}
+
+// PurgeExpiredTokens removes tokens which can't be used anymore anyway
+func PurgeExpiredTokens() error {
+	now := time.Now()
+	if err := DB.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	revocations.purge(now)
+	return DB.Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+}
+
+type revocationEntry struct {
+	revoked  bool
+	until    time.Time
+	familyID string
+	userID   int
+}
+
+type revocationCache struct {
+	mu      sync.RWMutex
+	entries map[string]revocationEntry
+}
+
+var revocations = &revocationCache{entries: map[string]revocationEntry{}}
+
+func (c *revocationCache) get(jti string) (bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[jti]
+	if !ok || time.Now().After(entry.until) {
+		return false, false
+	}
+	return entry.revoked, true
+}
+
+func (c *revocationCache) set(jti string, entry revocationEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[jti] = entry
+}
+
+// revoke marks the cached tokens matching the filter as revoked
+func (c *revocationCache) revoke(match func(revocationEntry) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for jti, entry := range c.entries {
+		if !entry.revoked && match(entry) {
+			entry.revoked = true
+			c.entries[jti] = entry
+		}
+	}
+}
+
+func (c *revocationCache) purge(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for jti, entry := range c.entries {
+		if now.After(entry.until) {
+			delete(c.entries, jti)
+		}
+	}
+}
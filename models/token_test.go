@@ -0,0 +1,137 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestIsTokenRevokedIsCached(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	expectedQuery := "SELECT * FROM `revoked_tokens` WHERE jti = ? LIMIT ?"
+	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
+		WithArgs("revoked-jti", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"jti", "expires_at"}).
+			AddRow("revoked-jti", time.Now().Add(time.Hour)))
+
+	// the second lookup must be answered by the cache
+	for i := 0; i < 2; i++ {
+		revoked, err := models.IsTokenRevoked("revoked-jti", "family-1", 7)
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestRotateRefreshTokenReuseRevokesFamily(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `refresh_tokens` WHERE token_hash = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "family_id", "user_id", "expires_at", "used_at"}).
+			AddRow(1, "family-1", 7, time.Now().Add(time.Hour), time.Now().Add(-time.Minute)))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `refresh_tokens` SET `revoked_at`=? WHERE family_id = ? AND revoked_at IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "family-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	user, _, _, err := models.RotateRefreshToken("already-used")
+	assert.ErrorIs(t, err, models.ErrRefreshTokenReused)
+	assert.Nil(t, user)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestIsTokenRevokedWithItsFamily(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	expectLookup := func(jti string, familyRevoked int, disabled bool) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `revoked_tokens` WHERE jti = ? LIMIT ?")).
+			WithArgs(jti, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"jti", "expires_at"}))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `refresh_tokens` WHERE family_id = ? AND revoked_at IS NOT NULL")).
+			WithArgs("family-2").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(familyRevoked))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`disabled` FROM `users` WHERE id = ? LIMIT ?")).
+			WithArgs(8, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "disabled"}).AddRow(8, disabled))
+	}
+
+	// another access token of a login that was logged out
+	expectLookup("other-jti", 1, false)
+	revoked, err := models.IsTokenRevoked("other-jti", "family-2", 8)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// a token of a disabled user
+	expectLookup("disabled-jti", 0, true)
+	revoked, err = models.IsTokenRevoked("disabled-jti", "family-2", 8)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// a valid token stays cached until its family is revoked
+	expectLookup("valid-jti", 0, false)
+	revoked, err = models.IsTokenRevoked("valid-jti", "family-2", 8)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `refresh_tokens` SET `revoked_at`=? WHERE family_id = ? AND revoked_at IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "family-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, models.RevokeTokenFamily("family-2"))
+
+	revoked, err = models.IsTokenRevoked("valid-jti", "family-2", 8)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserDisabled       = errors.New("user is disabled")
+	ErrInvalidPassword    = errors.New("password must be 8-72 characters")
+)
+
+type User struct {
+	ID           int       `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	Username     string    `json:"username" gorm:"column:username;size:64;uniqueIndex;not null"`
+	PasswordHash string    `json:"-" gorm:"column:password_hash;size:255"`
+	Role         Role      `json:"role" gorm:"column:role;size:16;not null;default:viewer"`
+	Service      bool      `json:"service" gorm:"column:service;not null;default:false"` // service accounts use api keys
+	Disabled     bool      `json:"disabled" gorm:"column:disabled;not null;default:false"`
+	CreatedAt    time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// hash the password with bcrypt, the plain password is never stored
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// ValidatePassword applies the length rule of new passwords, the same as the
+// binding of CreateUserInput
+func ValidatePassword(password string) error {
+	if n := utf8.RuneCountInString(password); n < 8 || n > 72 {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// CreateUser creates a user who logs in with the password. The password hash
+// isn't part of the JSON of a user and so never reaches the audit trail.
+func CreateUser(ctx context.Context, username, password string, role Role) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+	}
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditUser, user.ID, nil, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func GetUserByID(id int) (*User, error) {
+	var user User
+	if err := DB.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// AuthenticateUser verifies the credentials and returns the matching user
+func AuthenticateUser(username, password string) (*User, error) {
+	var user User
+	if err := DB.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if user.Service {
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return &user, nil
+}
+
+// SetUserDisabled enables or disables the user, a disabled user can't login
+// and its refresh tokens are revoked
+func SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	action := "enable"
+	if disabled {
+		action = "disable"
+	}
+	err := updateUser(ctx, id, action, func(user *User) {
+		user.Disabled = disabled
+	})
+	if err != nil {
+		return err
+	}
+	if disabled {
+		return RevokeUserTokens(id)
+	}
+	return nil
+}
+
+// SetUserRole replaces the role of the user
+func SetUserRole(ctx context.Context, id int, role Role) error {
+	return updateUser(ctx, id, "update", func(user *User) {
+		user.Role = role
+	})
+}
+
+// updateUser applies the change to the user and records it, it fails with
+// gorm.ErrRecordNotFound when there is no such user
+func updateUser(ctx context.Context, id int, action string, change func(*User)) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return err
+		}
+		before := user
+		change(&user)
+		err := tx.Model(&user).Select("role", "disabled", "updated_at").Updates(&user).Error
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, action, AuditUser, id, &before, &user)
+	})
+}
+
+// EnsureUser creates the user when no user with this name exists yet, the
+// password must be valid either way so that a bad configuration is noticed
+func EnsureUser(username, password string, role Role) error {
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+	var count int64
+	if err := DB.Model(&User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := CreateUser(context.Background(), username, password, role)
+	return err
+}
@@ -0,0 +1,203 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestAuthenticateUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	expectedQuery := "SELECT * FROM `users` WHERE username = ? ORDER BY `users`.`id` LIMIT ?"
+	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
+		WithArgs("alice", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "disabled"}).
+			AddRow(7, "alice", string(hash), false))
+
+	user, err := models.AuthenticateUser("alice", "secret-password")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, user.ID)
+	assert.Equal(t, "alice", user.Username)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestAuthenticateUserWithWrongPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	expectedQuery := "SELECT * FROM `users` WHERE username = ? ORDER BY `users`.`id` LIMIT ?"
+	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
+		WithArgs("alice", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "disabled"}).
+			AddRow(7, "alice", string(hash), false))
+
+	user, err := models.AuthenticateUser("alice", "wrong-password")
+	assert.ErrorIs(t, err, models.ErrInvalidCredentials)
+	assert.Nil(t, user)
+}
+
+func TestAuthenticateDisabledUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	expectedQuery := "SELECT * FROM `users` WHERE username = ? ORDER BY `users`.`id` LIMIT ?"
+	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
+		WithArgs("alice", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "disabled"}).
+			AddRow(7, "alice", string(hash), true))
+
+	user, err := models.AuthenticateUser("alice", "secret-password")
+	assert.ErrorIs(t, err, models.ErrUserDisabled)
+	assert.Nil(t, user)
+}
+
+func TestAuthenticateUnknownUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	expectedQuery := "SELECT * FROM `users` WHERE username = ? ORDER BY `users`.`id` LIMIT ?"
+	mock.ExpectQuery(regexp.QuoteMeta(expectedQuery)).
+		WithArgs("nobody", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "disabled"}))
+
+	user, err := models.AuthenticateUser("nobody", "secret-password")
+	assert.ErrorIs(t, err, models.ErrInvalidCredentials)
+	assert.Nil(t, user)
+}
+
+func TestCreateUserRecordsAudit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users`")).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	// the password hash stays out of the trail
+	expectAudit(mock, "create", "user", 9, `{"disabled":{"from":null,"to":false},"id":{"from":null,"to":9},"role":{"from":null,"to":"clerk"},"service":{"from":null,"to":false},"username":{"from":null,"to":"bob"}}`)
+	mock.ExpectCommit()
+
+	user, err := models.CreateUser(alice, "bob", "secret-password", models.RoleClerk)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, user.ID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestSetUserRoleRecordsAudit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users` WHERE `users`.`id` = ? ORDER BY `users`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password_hash", "role"}).AddRow(9, "bob", "hash", "viewer"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `role`=?,`disabled`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs("manager", false, sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "update", "user", 9, `{"role":{"from":"viewer","to":"manager"}}`)
+	mock.ExpectCommit()
+
+	assert.NoError(t, models.SetUserRole(alice, 9, models.RoleManager))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestEnsureUserRejectsShortPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// even when the user exists already, nothing is queried
+	err = models.EnsureUser("admin", "admin", models.RoleAdmin)
+	assert.ErrorIs(t, err, models.ErrInvalidPassword)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
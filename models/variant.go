@@ -0,0 +1,303 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// upper limit of the variants a single generation creates
+const MaxVariantCombinations = 500
+
+// ErrProductIsVariant is returned when variants are generated for a variant
+var ErrProductIsVariant = errors.New("product is a variant")
+
+// VariantAxis is an attribute the variants of a product differ in and the
+// values it takes, e.g. size S, M and L
+type VariantAxis struct {
+	Attribute string        `json:"attribute"`
+	Values    []interface{} `json:"values"`
+}
+
+type GeneratedVariants struct {
+	Created  []Product `json:"created"`
+	Existing int       `json:"existing"` // combinations which already had a variant
+}
+
+var skuUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// validateAxes checks the shape of the axes and the number of combinations
+func validateAxes(axes []VariantAxis) error {
+	if len(axes) == 0 {
+		return ValidationErrors{"axes": "is required"}
+	}
+	combinations := 1
+	seen := map[string]bool{}
+	for _, axis := range axes {
+		if seen[axis.Attribute] {
+			return ValidationErrors{"axes": fmt.Sprintf("contain %q twice", axis.Attribute)}
+		}
+		seen[axis.Attribute] = true
+		if len(axis.Values) == 0 {
+			return ValidationErrors{"axes." + axis.Attribute: "needs at least one value"}
+		}
+		values := map[string]bool{}
+		for _, value := range axis.Values {
+			if values[attributeValue(value)] {
+				return ValidationErrors{"axes." + axis.Attribute: fmt.Sprintf("contains %v twice", value)}
+			}
+			values[attributeValue(value)] = true
+		}
+		combinations *= len(axis.Values)
+		if combinations > MaxVariantCombinations {
+			return ValidationErrors{"axes": fmt.Sprintf("must make at most %d combinations", MaxVariantCombinations)}
+		}
+	}
+	return nil
+}
+
+// GenerateVariants creates a variant of the base product for every
+// combination of the axis values which has none yet. Variants are products
+// with their own SKU and stock, they copy the fields of the base product.
+// The first generation fixes the axes of the product, later ones may add
+// values but must use the same attributes.
+func GenerateVariants(ctx context.Context, baseID int, axes []VariantAxis) (*GeneratedVariants, error) {
+	if err := validateAxes(axes); err != nil {
+		return nil, err
+	}
+	result := &GeneratedVariants{Created: []Product{}}
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var base Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&base, baseID).Error; err != nil {
+			return err
+		}
+		if base.ParentID != nil {
+			return ErrProductIsVariant
+		}
+
+		codes := make([]string, len(axes))
+		for i, axis := range axes {
+			codes[i] = axis.Attribute
+		}
+		if len(base.VariantAxes) > 0 && !sameCodes(base.VariantAxes, codes) {
+			return ValidationErrors{"axes": "must be the axes of the existing variants: " + strings.Join(base.VariantAxes, ", ")}
+		}
+		definitions, err := loadAttributes(tx, codes)
+		if err != nil {
+			return err
+		}
+		errs := ValidationErrors{}
+		for _, axis := range axes {
+			definition, ok := definitions[axis.Attribute]
+			if !ok {
+				errs["axes."+axis.Attribute] = "is not a defined attribute"
+				continue
+			}
+			for _, value := range axis.Values {
+				if reason := definition.checkValue(value); reason != "" {
+					errs["axes."+axis.Attribute] = reason
+				}
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+
+		// variants in the trash keep their combination, they can be restored
+		var variants []Product
+		if err := tx.Unscoped().Where("parent_id = ?", base.ID).Find(&variants).Error; err != nil {
+			return err
+		}
+		existing := map[string]bool{}
+		for _, variant := range variants {
+			existing[combinationKey(codes, variant.Attributes)] = true
+		}
+
+		for _, combination := range combinations(axes) {
+			if existing[combinationKey(codes, combination)] {
+				result.Existing++
+				continue
+			}
+			variant := newVariant(&base, codes, combination)
+			if err := variant.Validate(); err != nil {
+				return err
+			}
+			if err := createProduct(tx, variant); err != nil {
+				return err
+			}
+			result.Created = append(result.Created, *variant)
+		}
+
+		if len(base.VariantAxes) == 0 {
+			before := base
+			base.VariantAxes = codes
+			return writeProduct(tx, &before, &base)
+		}
+		return nil
+	})
+	for _, variant := range result.Created {
+		markSearchStale(variant.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func sameCodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// combinations returns every combination of the axis values, the last axis
+// changes fastest
+func combinations(axes []VariantAxis) []AttributeValues {
+	result := []AttributeValues{{}}
+	for _, axis := range axes {
+		var next []AttributeValues
+		for _, partial := range result {
+			for _, value := range axis.Values {
+				combination := AttributeValues{}
+				for code, v := range partial {
+					combination[code] = v
+				}
+				combination[axis.Attribute] = value
+				next = append(next, combination)
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+func combinationKey(codes []string, values AttributeValues) string {
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = attributeValue(values[code])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// newVariant copies the base product for the combination, the SKU and the
+// name get the option values appended
+func newVariant(base *Product, codes []string, combination AttributeValues) *Product {
+	variant := &Product{
+		ParentID:    &base.ID,
+		SKU:         base.SKU,
+		Description: base.Description,
+		Unit:        base.Unit,
+		Status:      base.Status,
+		Price:       base.Price,
+		Currency:    base.Currency,
+		CategoryID:  base.CategoryID,
+		Attributes:  AttributeValues{},
+		Tags:        append(StringList(nil), base.Tags...),
+	}
+	for code, value := range base.Attributes {
+		variant.Attributes[code] = value
+	}
+	options := make([]string, len(codes))
+	for i, code := range codes {
+		variant.Attributes[code] = combination[code]
+		options[i] = attributeValue(combination[code])
+		variant.SKU += "-" + skuUnsafeChars.ReplaceAllString(options[i], "_")
+	}
+	variant.Name = base.Name + " (" + strings.Join(options, " / ") + ")"
+	variant.Normalize()
+	return variant
+}
+
+// followBasePrice gives the new price of a base product to its variants
+// which still had the old price, variants with a price of their own keep it
+func followBasePrice(tx *gorm.DB, before, base *Product) error {
+	if len(base.VariantAxes) == 0 || (base.Price == before.Price && base.Currency == before.Currency) {
+		return nil
+	}
+	var variants []Product
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("parent_id = ? AND price = ? AND currency = ?", base.ID, before.Price, before.Currency).
+		Find(&variants).Error
+	if err != nil {
+		return err
+	}
+	for i := range variants {
+		variant := variants[i]
+		variant.Price, variant.Currency = base.Price, base.Currency
+		if err := writeProduct(tx, &variants[i], &variant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVariantOptions rejects changes to the option values of a variant, they
+// tell the variants of a product apart
+func checkVariantOptions(tx *gorm.DB, before, product *Product) error {
+	if product.ParentID == nil {
+		return nil
+	}
+	var base Product
+	if err := tx.Unscoped().Select("id", "variant_axes").First(&base, *product.ParentID).Error; err != nil {
+		return err
+	}
+	errs := ValidationErrors{}
+	for _, code := range base.VariantAxes {
+		if attributeValue(before.Attributes[code]) != attributeValue(product.Attributes[code]) {
+			errs["attributes."+code] = "is an option of the variant and can't be changed"
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// AttachVariants fills in the variants of the base products, with their
+// stock. Products without variants are left alone.
+func AttachVariants(products []Product) error {
+	var ids []int
+	for i := range products {
+		if len(products[i].VariantAxes) > 0 {
+			ids = append(ids, products[i].ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var variants []Product
+	if err := DB.Where("parent_id IN ?", ids).Order("id").Find(&variants).Error; err != nil {
+		return err
+	}
+	if err := resolveEffectivePrices(variants); err != nil {
+		return err
+	}
+	if err := AttachStock(variants); err != nil {
+		return err
+	}
+	byParent := map[int][]Product{}
+	for _, variant := range variants {
+		byParent[*variant.ParentID] = append(byParent[*variant.ParentID], variant)
+	}
+	for i := range products {
+		if len(products[i].VariantAxes) > 0 {
+			products[i].Variants = byParent[products[i].ID]
+			if products[i].Variants == nil {
+				products[i].Variants = []Product{}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package models_test
+
+import (
+	"myapp/models"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func sizeAttributeRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "code", "name", "type", "options"}).
+		AddRow(1, "size", "Size", "enum", "S,M,L")
+}
+
+func TestGenerateVariants(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the S shirt exists already, M is created and the shirt gets its axes
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ? AND `products`.`deleted_at` IS NULL ORDER BY `products`.`id` LIMIT ? FOR UPDATE")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "unit", "status", "type", "price", "currency", "attributes", "version"}).
+			AddRow(1, "TEE", "T-shirt", "pcs", "active", "simple", "20", "USD", `{"material":"cotton"}`, 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `attributes` WHERE code IN (?)")).
+		WithArgs("size").
+		WillReturnRows(sizeAttributeRows())
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE parent_id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "sku", "attributes"}).
+			AddRow(2, 1, "TEE-S", `{"material":"cotton","size":"S"}`))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `attributes` WHERE code IN (?,?)")).
+		WithArgs("material", "size").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "type", "options"}).
+			AddRow(2, "material", "Material", "text", nil).
+			AddRow(1, "size", "Size", "enum", "S,M,L"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `products`")).
+		WithArgs("TEE-M", "T-shirt (M)", "", "pcs", "active", "simple", "20", "USD", nil, 1, `{"material":"cotton","size":"M"}`, "", "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	expectPrice(mock, 3, "20")
+	expectAudit(mock, "create", "product", 3, sqlmock.AnyArg())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET")).
+		WithArgs("TEE", "T-shirt", "", "pcs", "active", "simple", "20", "USD", nil, `{"material":"cotton"}`, "", "size", 3, sqlmock.AnyArg(), 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "update", "product", 1, `{"variant_axes":{"from":null,"to":["size"]},"version":{"from":2,"to":3}}`)
+	mock.ExpectCommit()
+
+	result, err := models.GenerateVariants(alice, 1, []models.VariantAxis{{Attribute: "size", Values: []interface{}{"S", "M"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Existing)
+	if assert.Len(t, result.Created, 1) {
+		assert.Equal(t, "TEE-M", result.Created[0].SKU)
+		assert.Equal(t, 1, *result.Created[0].ParentID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestGenerateVariantsInvalid(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// checked before the base is loaded
+	_, err = models.GenerateVariants(alice, 1, []models.VariantAxis{{Attribute: "size", Values: []interface{}{"S", "S"}}})
+	assert.Equal(t, models.ValidationErrors{"axes.size": "contains S twice"}, err)
+
+	// XL is no option of size
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "price", "version"}).AddRow(1, "TEE", "T-shirt", "20", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `attributes` WHERE code IN (?)")).
+		WithArgs("size").
+		WillReturnRows(sizeAttributeRows())
+	mock.ExpectRollback()
+
+	_, err = models.GenerateVariants(alice, 1, []models.VariantAxis{{Attribute: "size", Values: []interface{}{"XL"}}})
+	assert.Equal(t, models.ValidationErrors{"axes.size": "must be one of S, M, L"}, err)
+
+	// variants have no variants of their own
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "sku", "version"}).AddRow(2, 1, "TEE-S", 1))
+	mock.ExpectRollback()
+
+	_, err = models.GenerateVariants(alice, 2, []models.VariantAxis{{Attribute: "size", Values: []interface{}{"M"}}})
+	assert.ErrorIs(t, err, models.ErrProductIsVariant)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestUpdateBasePriceFollowedByVariants(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	models.DB = gormDB
+
+	// the variants with the old price follow, one with its own price doesn't
+	// match the query and keeps it
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE `products`.`id` = ?")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "unit", "status", "type", "price", "currency", "variant_axes", "version"}).
+			AddRow(1, "TEE", "T-shirt", "pcs", "active", "simple", "20", "USD", "size", 3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET")).
+		WithArgs("TEE", "T-shirt", "", "pcs", "active", "simple", "25", "USD", nil, nil, "", "size", 4, sqlmock.AnyArg(), 3, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectPrice(mock, 1, "25")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `products` WHERE (parent_id = ? AND price = ? AND currency = ?) AND `products`.`deleted_at` IS NULL FOR UPDATE")).
+		WithArgs(1, "20", "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "sku", "name", "unit", "status", "type", "price", "currency", "attributes", "version"}).
+			AddRow(2, 1, "TEE-S", "T-shirt (S)", "pcs", "active", "simple", "20", "USD", `{"size":"S"}`, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `products` SET")).
+		WithArgs("TEE-S", "T-shirt (S)", "", "pcs", "active", "simple", "25", "USD", nil, `{"size":"S"}`, "", "", 2, sqlmock.AnyArg(), 1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectPrice(mock, 2, "25")
+	expectAudit(mock, "update", "product", 2, `{"price":{"from":20,"to":25},"version":{"from":1,"to":2}}`)
+	expectAudit(mock, "update", "product", 1, `{"price":{"from":20,"to":25},"version":{"from":3,"to":4}}`)
+	mock.ExpectCommit()
+
+	price, _ := models.ParseDecimal("25")
+	_, err = models.UpdateProduct(alice, 1, &models.Product{SKU: "TEE", Name: "T-shirt", Price: price}, 3)
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
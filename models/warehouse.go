@@ -0,0 +1,46 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Warehouse struct {
+	ID            int       `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	Code          string    `json:"code" gorm:"column:code;size:32;uniqueIndex;not null"`
+	Name          string    `json:"name" gorm:"column:name;size:255;not null"`
+	AllowNegative bool      `json:"allow_negative" gorm:"column:allow_negative;not null;default:false"` // stock may go below zero
+	CreatedAt     time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func GetAllWarehouses() ([]Warehouse, error) {
+	warehouses := []Warehouse{}
+	if err := DB.Order("id").Find(&warehouses).Error; err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+func GetWarehouseByID(id int) (*Warehouse, error) {
+	var warehouse Warehouse
+	if err := DB.First(&warehouse, id).Error; err != nil {
+		return nil, err
+	}
+	return &warehouse, nil
+}
+
+func CreateWarehouse(ctx context.Context, warehouse *Warehouse) (int, error) {
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(warehouse).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, "create", AuditWarehouse, warehouse.ID, nil, warehouse)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return warehouse.ID, nil
+}
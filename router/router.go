@@ -3,24 +3,110 @@ package router
 import (
 	"myapp/controllers"
 	"myapp/middlewares"
+	"myapp/models"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
+	r.Use(middlewares.RequestID())
 
-	r.POST("/login/:user", controllers.Login)
-	// the APIs protect by using JWT
+	r.GET("/.well-known/jwks.json", controllers.JWKS)
+	r.POST("/login", controllers.Login)
+	r.POST("/token/refresh", controllers.RefreshToken)
+	r.POST("/logout", middlewares.JWTAuthMiddleware(), controllers.Logout)
+	// the APIs protect by using JWT or an API key
 	authorized := r.Group("/protected")
-	authorized.Use(middlewares.JWTAuthMiddleware())
+	authorized.Use(middlewares.AuthMiddleware())
 	{
 		authorized.GET("/", controllers.HomeHandler)
-		authorized.POST("/products", controllers.CreateProduct)
-		authorized.PUT("/products/:id", controllers.UpdateProduct)
-		authorized.DELETE("/products/:id", controllers.DeleteProduct)
-		authorized.GET("/products/:id", controllers.GetProductByID)
-		authorized.GET("/products", controllers.GetAllProducts)
+		authorized.POST("/products", middlewares.RequirePermission(models.PermProductsWrite), middlewares.Idempotency(), controllers.CreateProduct)
+		authorized.PUT("/products/:id", middlewares.RequirePermission(models.PermProductsWrite), controllers.UpdateProduct)
+		authorized.PATCH("/products/:id", middlewares.RequirePermission(models.PermProductsWrite), controllers.PatchProduct)
+		authorized.DELETE("/products/:id", middlewares.RequirePermission(models.PermProductsDelete), controllers.DeleteProduct)
+		authorized.POST("/products/:id/restore", middlewares.RequirePermission(models.PermProductsDelete), controllers.RestoreProduct)
+		authorized.POST("/products/:id/purge", middlewares.RequirePermission(models.PermProductsPurge), controllers.PurgeProduct)
+		authorized.GET("/products/:id", middlewares.RequirePermission(models.PermProductsRead), controllers.GetProductByID)
+		authorized.GET("/products", middlewares.RequirePermission(models.PermProductsRead), controllers.GetAllProducts)
+		authorized.GET("/products/:id/stock", middlewares.RequirePermission(models.PermStockRead), controllers.GetProductStock)
+		authorized.GET("/products/:id/prices", middlewares.RequirePermission(models.PermProductsRead), controllers.GetProductPrices)
+		authorized.POST("/products/:id/prices", middlewares.RequirePermission(models.PermProductsWrite), controllers.ScheduleProductPrice)
+		authorized.DELETE("/products/:id/prices/:price_id", middlewares.RequirePermission(models.PermProductsWrite), controllers.CancelProductPrice)
+		authorized.PUT("/products/:id/components", middlewares.RequirePermission(models.PermProductsWrite), controllers.SetKitComponents)
+		authorized.POST("/products/:id/barcodes", middlewares.RequirePermission(models.PermProductsWrite), controllers.AddBarcode)
+		authorized.DELETE("/products/:id/barcodes/:code", middlewares.RequirePermission(models.PermProductsWrite), controllers.RemoveBarcode)
+		authorized.GET("/products/:id/barcodes/:code/image", middlewares.RequirePermission(models.PermProductsRead), controllers.GetBarcodeImage)
+		authorized.GET("/products/search", middlewares.RequirePermission(models.PermProductsRead), controllers.SearchProducts)
+		authorized.GET("/products/lookup", middlewares.RequirePermission(models.PermProductsRead), controllers.LookupProduct)
+		authorized.POST("/products/:id/variants", middlewares.RequirePermission(models.PermProductsWrite), controllers.GenerateVariants)
+		authorized.POST("/products/import", middlewares.RequirePermission(models.PermProductsWrite), controllers.ImportProducts)
+		authorized.GET("/products/export", middlewares.RequirePermission(models.PermProductsRead), controllers.ExportProducts)
+		// gin treats the ':' of a custom method like /products:batch as a
+		// parameter, so the method is matched here
+		authorized.POST("/products:method", customMethod(":batch"), middlewares.RequirePermission(models.PermProductsWrite), controllers.BatchProducts)
+
+		// the category tree, products are assigned by their category_id
+		authorized.GET("/categories", middlewares.RequirePermission(models.PermProductsRead), controllers.GetCategories)
+		authorized.POST("/categories", middlewares.RequirePermission(models.PermCategoriesManage), controllers.CreateCategory)
+		authorized.GET("/categories/:id", middlewares.RequirePermission(models.PermProductsRead), controllers.GetCategory)
+		authorized.PUT("/categories/:id", middlewares.RequirePermission(models.PermCategoriesManage), controllers.UpdateCategory)
+		authorized.POST("/categories/:id/move", middlewares.RequirePermission(models.PermCategoriesManage), controllers.MoveCategory)
+		authorized.DELETE("/categories/:id", middlewares.RequirePermission(models.PermCategoriesManage), controllers.DeleteCategory)
+
+		// attribute definitions, products hold their values in attributes
+		authorized.GET("/attributes", middlewares.RequirePermission(models.PermProductsRead), controllers.GetAttributes)
+		authorized.POST("/attributes", middlewares.RequirePermission(models.PermAttributesManage), controllers.CreateAttribute)
+		authorized.PUT("/attributes/:code", middlewares.RequirePermission(models.PermAttributesManage), controllers.UpdateAttribute)
+
+		// price lists and the exchange rates between their currencies
+		authorized.GET("/price-lists", middlewares.RequirePermission(models.PermProductsRead), controllers.GetPriceLists)
+		authorized.POST("/price-lists", middlewares.RequirePermission(models.PermPriceListsManage), controllers.CreatePriceList)
+		authorized.GET("/price-lists/:code", middlewares.RequirePermission(models.PermProductsRead), controllers.GetPriceList)
+		authorized.PUT("/price-lists/:code/prices/:product_id", middlewares.RequirePermission(models.PermPriceListsManage), controllers.SetListPrice)
+		authorized.DELETE("/price-lists/:code/prices/:product_id", middlewares.RequirePermission(models.PermPriceListsManage), controllers.DeleteListPrice)
+		authorized.GET("/exchange-rates", middlewares.RequirePermission(models.PermProductsRead), controllers.GetExchangeRates)
+		authorized.PUT("/exchange-rates/:from/:to", middlewares.RequirePermission(models.PermExchangeRatesManage), controllers.SetExchangeRate)
+
+		// warehouses and the stock ledger
+		authorized.GET("/warehouses", middlewares.RequirePermission(models.PermStockRead), controllers.GetAllWarehouses)
+		authorized.POST("/warehouses", middlewares.RequirePermission(models.PermWarehousesManage), controllers.CreateWarehouse)
+		authorized.GET("/warehouses/:id/stock", middlewares.RequirePermission(models.PermStockRead), controllers.GetWarehouseStock)
+		authorized.GET("/stock/movements", middlewares.RequirePermission(models.PermStockRead), controllers.GetStockMovements)
+		authorized.POST("/stock/movements", middlewares.RequirePermission(models.PermStockWrite), middlewares.Idempotency(), controllers.PostStockMovement)
+		authorized.POST("/stock/reconcile", middlewares.RequirePermission(models.PermWarehousesManage), controllers.ReconcileStock)
+
+		// reservations hold stock for pending orders
+		authorized.GET("/reservations", middlewares.RequirePermission(models.PermStockRead), controllers.GetReservations)
+		authorized.POST("/reservations", middlewares.RequirePermission(models.PermStockWrite), controllers.CreateReservation)
+		authorized.GET("/reservations/:id", middlewares.RequirePermission(models.PermStockRead), controllers.GetReservationByID)
+		authorized.DELETE("/reservations/:id", middlewares.RequirePermission(models.PermStockWrite), controllers.ReleaseReservation)
+		authorized.POST("/reservations/:id/fulfill", middlewares.RequirePermission(models.PermStockWrite), controllers.FulfillReservation)
+
+		// who changed what, see models.AuditEntry
+		authorized.GET("/audit", middlewares.RequirePermission(models.PermAuditRead), controllers.GetAuditEntries)
+
+		// user administration
+		authorized.POST("/users", middlewares.RequirePermission(models.PermUsersManage), controllers.CreateUser)
+		authorized.POST("/users/:id/disable", middlewares.RequirePermission(models.PermUsersManage), controllers.DisableUser)
+		authorized.POST("/users/:id/enable", middlewares.RequirePermission(models.PermUsersManage), controllers.EnableUser)
+		authorized.PUT("/users/:id/role", middlewares.RequirePermission(models.PermUsersManage), controllers.SetUserRole)
+
+		// service accounts and their API keys
+		authorized.POST("/service-accounts", middlewares.RequirePermission(models.PermUsersManage), controllers.CreateServiceAccount)
+		authorized.POST("/api-keys", middlewares.RequirePermission(models.PermUsersManage), controllers.CreateAPIKey)
+		authorized.GET("/api-keys", middlewares.RequirePermission(models.PermUsersManage), controllers.GetAPIKeys)
+		authorized.DELETE("/api-keys/:id", middlewares.RequirePermission(models.PermUsersManage), controllers.RevokeAPIKey)
 	}
 	return r
 }
+
+// customMethod answers 404 unless the :method parameter is the method
+func customMethod(method string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("method") != method {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		}
+	}
+}
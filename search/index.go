@@ -0,0 +1,332 @@
+// Package search finds documents by the words of a query. Matching is
+// case-insensitive and ignores accents, words may be typed partially or with
+// a typo. The index is kept in memory and works on top of any database.
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Document is the text of an entity by field
+type Document struct {
+	ID     int
+	Fields map[string]string
+}
+
+// Hit is a document matching a query. Highlights holds the matching fields
+// with the matched words in <em>, the rest of the text is HTML-escaped.
+type Hit struct {
+	ID         int
+	Score      float64
+	Highlights map[string]string
+}
+
+// Index finds documents by the words of a query
+type Index interface {
+	// Put adds the document or replaces the document with its ID
+	Put(doc Document)
+	Remove(id int)
+	// Search returns the page of the documents matching every word of the
+	// query, best first, and the number of matching documents
+	Search(query string, offset, limit int) ([]Hit, int)
+	Len() int
+}
+
+// how a query word is matched against the terms of the index
+const (
+	exactMatch  = 1.0
+	prefixMatch = 0.6 // "app" for "apple", scaled by the typed part
+	typoMatch   = 0.5 // one edit, two edits count half of it
+	// BM25 parameters
+	k1 = 1.2
+	b  = 0.75
+	// fields longer than this are cut to the part around the first match
+	snippetLength = 160
+)
+
+type indexedDoc struct {
+	fields  map[string]string
+	terms   map[string]map[string]int // field, term, frequency
+	lengths map[string]int            // the number of terms of a field
+}
+
+// MemoryIndex is an Index in memory ranking the documents by BM25, the fields
+// count by their weight
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	weights  map[string]float64
+	docs     map[int]*indexedDoc
+	postings map[string]map[int]bool // term, documents
+	lengths  map[string]int          // the number of terms of a field in every document
+	// the sorted terms, nil after a change. Searches share the read lock and
+	// build it under vocabMu.
+	vocabMu sync.Mutex
+	vocab   []string
+}
+
+// NewMemoryIndex creates an empty index, only the fields with a weight are
+// indexed
+func NewMemoryIndex(weights map[string]float64) *MemoryIndex {
+	return &MemoryIndex{
+		weights:  weights,
+		docs:     map[int]*indexedDoc{},
+		postings: map[string]map[int]bool{},
+		lengths:  map[string]int{},
+	}
+}
+
+func (x *MemoryIndex) Put(doc Document) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(doc.ID)
+
+	indexed := &indexedDoc{fields: map[string]string{}, terms: map[string]map[string]int{}, lengths: map[string]int{}}
+	for field, text := range doc.Fields {
+		if _, ok := x.weights[field]; !ok || text == "" {
+			continue
+		}
+		tokens := Tokenize(text)
+		if len(tokens) == 0 {
+			continue
+		}
+		indexed.fields[field] = text
+		indexed.terms[field] = map[string]int{}
+		indexed.lengths[field] = len(tokens)
+		x.lengths[field] += len(tokens)
+		for _, token := range tokens {
+			indexed.terms[field][token.Term]++
+			if x.postings[token.Term] == nil {
+				x.postings[token.Term] = map[int]bool{}
+				x.vocab = nil
+			}
+			x.postings[token.Term][doc.ID] = true
+		}
+	}
+	x.docs[doc.ID] = indexed
+}
+
+func (x *MemoryIndex) Remove(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+func (x *MemoryIndex) remove(id int) {
+	doc, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for field, terms := range doc.terms {
+		x.lengths[field] -= doc.lengths[field]
+		for term := range terms {
+			delete(x.postings[term], id)
+			if len(x.postings[term]) == 0 {
+				delete(x.postings, term)
+				x.vocab = nil
+			}
+		}
+	}
+	delete(x.docs, id)
+}
+
+func (x *MemoryIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// expansion is a term of the index a query word matches
+type expansion struct {
+	term   string
+	factor float64
+}
+
+// expand finds the terms the word matches: itself, the terms it is a prefix
+// of and, for words of 4 runes or more, the terms one edit away, or two for
+// words of 8 runes or more
+func (x *MemoryIndex) expand(word string) []expansion {
+	var expansions []expansion
+	if x.postings[word] != nil {
+		expansions = append(expansions, expansion{word, exactMatch})
+	}
+
+	vocab := x.sortedTerms()
+	wordLength := utf8.RuneCountInString(word)
+	if wordLength >= 2 {
+		for i := sort.SearchStrings(vocab, word); i < len(vocab) && strings.HasPrefix(vocab[i], word); i++ {
+			if vocab[i] != word {
+				typed := float64(wordLength) / float64(utf8.RuneCountInString(vocab[i]))
+				expansions = append(expansions, expansion{vocab[i], prefixMatch * (0.5 + 0.5*typed)})
+			}
+		}
+	}
+
+	maxEdits := 0
+	switch {
+	case wordLength >= 8:
+		maxEdits = 2
+	case wordLength >= 4:
+		maxEdits = 1
+	}
+	if maxEdits > 0 {
+		runes := []rune(word)
+		for _, term := range vocab {
+			if term == word || strings.HasPrefix(term, word) {
+				continue
+			}
+			if edits := editDistance(runes, []rune(term), maxEdits); edits <= maxEdits {
+				expansions = append(expansions, expansion{term, typoMatch / float64(edits)})
+			}
+		}
+	}
+	return expansions
+}
+
+func (x *MemoryIndex) sortedTerms() []string {
+	x.vocabMu.Lock()
+	defer x.vocabMu.Unlock()
+	if x.vocab == nil {
+		x.vocab = make([]string, 0, len(x.postings))
+		for term := range x.postings {
+			x.vocab = append(x.vocab, term)
+		}
+		sort.Strings(x.vocab)
+	}
+	return x.vocab
+}
+
+// Search implements Index. A document must match every word of the query in
+// any of its fields, the score adds up the best matching term of every word.
+func (x *MemoryIndex) Search(query string, offset, limit int) ([]Hit, int) {
+	words := Terms(query)
+	if len(words) == 0 {
+		return []Hit{}, 0
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	scores := map[int]float64{}
+	matched := map[int]map[string]bool{} // the terms to highlight per document
+	for i, word := range words {
+		best := map[int]float64{}
+		for _, exp := range x.expand(word) {
+			idf := x.idf(exp.term)
+			for id := range x.postings[exp.term] {
+				score := exp.factor * x.termScore(x.docs[id], exp.term, idf)
+				if score > best[id] {
+					best[id] = score
+				}
+				if matched[id] == nil {
+					matched[id] = map[string]bool{}
+				}
+				matched[id][exp.term] = true
+			}
+		}
+		// only the documents which matched the words before stay
+		for id, score := range best {
+			if i == 0 {
+				scores[id] = score
+			} else if _, ok := scores[id]; ok {
+				scores[id] += score
+			}
+		}
+		for id := range scores {
+			if _, ok := best[id]; !ok {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	total := len(hits)
+	if offset >= total {
+		return []Hit{}, total
+	}
+	hits = hits[offset:min(offset+limit, total)]
+	for i := range hits {
+		hits[i].Highlights = highlight(x.docs[hits[i].ID], matched[hits[i].ID])
+	}
+	return hits, total
+}
+
+func (x *MemoryIndex) idf(term string) float64 {
+	n, df := float64(len(x.docs)), float64(len(x.postings[term]))
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+// termScore is the BM25 score of the term summed over the weighted fields
+func (x *MemoryIndex) termScore(doc *indexedDoc, term string, idf float64) float64 {
+	score := 0.0
+	for field, terms := range doc.terms {
+		tf := float64(terms[term])
+		if tf == 0 {
+			continue
+		}
+		average := float64(x.lengths[field]) / float64(len(x.docs))
+		norm := 1 - b + b*float64(doc.lengths[field])/average
+		score += x.weights[field] * idf * tf * (k1 + 1) / (tf + k1*norm)
+	}
+	return score
+}
+
+// highlight marks the matched terms in the fields which contain one
+func highlight(doc *indexedDoc, terms map[string]bool) map[string]string {
+	highlights := map[string]string{}
+	for field, text := range doc.fields {
+		var marks []Token
+		for _, token := range Tokenize(text) {
+			if terms[token.Term] {
+				marks = append(marks, token)
+			}
+		}
+		if len(marks) == 0 {
+			continue
+		}
+
+		from, to := 0, len(text)
+		if len(text) > snippetLength {
+			from = max(marks[0].Start-snippetLength/4, 0)
+			to = min(from+snippetLength, len(text))
+			for from > 0 && !utf8.RuneStart(text[from]) {
+				from--
+			}
+			for to < len(text) && !utf8.RuneStart(text[to]) {
+				to++
+			}
+		}
+
+		var s strings.Builder
+		if from > 0 {
+			s.WriteString("…")
+		}
+		at := from
+		for _, mark := range marks {
+			if mark.Start < from || mark.End > to {
+				continue
+			}
+			s.WriteString(html.EscapeString(text[at:mark.Start]))
+			s.WriteString("<em>" + html.EscapeString(text[mark.Start:mark.End]) + "</em>")
+			at = mark.End
+		}
+		s.WriteString(html.EscapeString(text[at:to]))
+		if to < len(text) {
+			s.WriteString("…")
+		}
+		highlights[field] = s.String()
+	}
+	return highlights
+}
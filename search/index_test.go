@@ -0,0 +1,103 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []Token{{"creme", 0, 6}, {"brulee", 7, 15}, {"app", 17, 20}, {"001", 21, 24}},
+		Tokenize("Crème Brûlée (APP-001)"))
+	// a decomposed é and the letters folding to two
+	assert.Equal(t, []string{"cafe", "strasse", "aeble"}, Terms("café Straße Æble café"))
+	assert.Empty(t, Tokenize(" -- "))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 1, editDistance([]rune("appel"), []rune("apple"), 2))
+	assert.Equal(t, 1, editDistance([]rune("banana"), []rune("bananas"), 2))
+	assert.Equal(t, 2, editDistance([]rune("chocolate"), []rune("chokolade"), 2))
+	assert.Equal(t, 2, editDistance([]rune("kiwi"), []rune("apple"), 1))
+}
+
+func newTestIndex() *MemoryIndex {
+	index := NewMemoryIndex(map[string]float64{"sku": 3, "name": 2, "tags": 1.5, "description": 1})
+	index.Put(Document{ID: 1, Fields: map[string]string{"sku": "APP-001", "name": "Apple", "description": "Red apples from Aomori"}})
+	index.Put(Document{ID: 2, Fields: map[string]string{"sku": "PIE-002", "name": "Apple pie", "tags": "bakery, dessert"}})
+	index.Put(Document{ID: 3, Fields: map[string]string{"sku": "CRB-003", "name": "Crème brûlée", "tags": "dessert"}})
+	index.Put(Document{ID: 4, Fields: map[string]string{"sku": "BAN-004", "name": "Banana", "description": "Not an <apple>"}})
+	return index
+}
+
+func TestMemoryIndexSearch(t *testing.T) {
+	index := newTestIndex()
+
+	// the name counts more than the description
+	hits, total := index.Search("apple", 0, 10)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []int{1, 2, 4}, hitIDs(hits))
+	assert.Equal(t, map[string]string{"name": "<em>Apple</em>", "description": "Red <em>apples</em> from Aomori"}, hits[0].Highlights)
+	assert.Equal(t, map[string]string{"description": "Not an &lt;<em>apple</em>&gt;"}, hits[2].Highlights)
+
+	// every word must match, partially, without accents or with a typo
+	hits, _ = index.Search("appel PIE", 0, 10)
+	assert.Equal(t, []int{2}, hitIDs(hits))
+	hits, _ = index.Search("creme brul", 0, 10)
+	assert.Equal(t, []int{3}, hitIDs(hits))
+	assert.Equal(t, "<em>Crème</em> <em>brûlée</em>", hits[0].Highlights["name"])
+	hits, _ = index.Search("app-001", 0, 10)
+	assert.Equal(t, []int{1}, hitIDs(hits))
+	// the shorter tags of 3 make the match count more
+	hits, _ = index.Search("Dessert", 0, 10)
+	assert.Equal(t, []int{3, 2}, hitIDs(hits))
+	hits, total = index.Search("kiwi", 0, 10)
+	assert.Empty(t, hits)
+	assert.Equal(t, 0, total)
+
+	// pages
+	hits, total = index.Search("apple", 1, 1)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []int{2}, hitIDs(hits))
+	hits, _ = index.Search("apple", 5, 1)
+	assert.Empty(t, hits)
+}
+
+func TestMemoryIndexPutReplaces(t *testing.T) {
+	index := newTestIndex()
+
+	index.Put(Document{ID: 2, Fields: map[string]string{"sku": "PIE-002", "name": "Cherry pie"}})
+	hits, _ := index.Search("apple", 0, 10)
+	assert.Equal(t, []int{1, 4}, hitIDs(hits))
+	hits, _ = index.Search("cherry", 0, 10)
+	assert.Equal(t, []int{2}, hitIDs(hits))
+
+	index.Remove(1)
+	index.Remove(1)
+	hits, _ = index.Search("aomori", 0, 10)
+	assert.Empty(t, hits)
+	assert.Equal(t, 3, index.Len())
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	index := NewMemoryIndex(map[string]float64{"description": 1})
+	long := "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore " +
+		"magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo " +
+		"consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur."
+	index.Put(Document{ID: 1, Fields: map[string]string{"description": long}})
+
+	hits, _ := index.Search("exercitation", 0, 10)
+	snippet := hits[0].Highlights["description"]
+	assert.Contains(t, snippet, "<em>exercitation</em>")
+	assert.True(t, len(snippet) < len(long))
+	assert.Equal(t, "…", snippet[:len("…")])
+	assert.Equal(t, "…", snippet[len(snippet)-len("…"):])
+}
+
+func hitIDs(hits []Hit) []int {
+	ids := []int{}
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
@@ -0,0 +1,150 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Token is a word of a text, Term is the word lowercased and without accents
+// and Start and End are its byte offsets in the text
+type Token struct {
+	Term       string
+	Start, End int
+}
+
+// letters which fold to more than one letter or whose base letter isn't
+// found by stripping an accent
+var specialFolds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'þ': "th", 'ð': "d", 'ø': "o", 'đ': "d", 'ħ': "h", 'ı': "i", 'ł': "l", 'ŧ': "t",
+}
+
+// the accented lowercase letters of Latin-1 and Latin Extended-A by their
+// base letter
+var accentFolds = func() map[rune]rune {
+	bases := map[rune]string{
+		'a': "àáâãäåāăą",
+		'c': "çćĉċč",
+		'd': "ď",
+		'e': "èéêëēĕėęě",
+		'g': "ĝğġģ",
+		'h': "ĥ",
+		'i': "ìíîïĩīĭį",
+		'j': "ĵ",
+		'k': "ķ",
+		'l': "ĺļľŀ",
+		'n': "ñńņňŉ",
+		'o': "òóôõöōŏő",
+		'r': "ŕŗř",
+		's': "śŝşšș",
+		't': "ţťț",
+		'u': "ùúûüũūŭůűų",
+		'w': "ŵ",
+		'y': "ýÿŷ",
+		'z': "źżž",
+	}
+	folds := map[rune]rune{}
+	for base, accented := range bases {
+		for _, r := range accented {
+			folds[r] = base
+		}
+	}
+	return folds
+}()
+
+// fold writes the rune lowercased and without its accent
+func fold(b *strings.Builder, r rune) {
+	r = unicode.ToLower(r)
+	if s, ok := specialFolds[r]; ok {
+		b.WriteString(s)
+		return
+	}
+	if base, ok := accentFolds[r]; ok {
+		r = base
+	}
+	b.WriteRune(r)
+}
+
+// Tokenize splits the text into words of letters and digits. Combining
+// accents, as in a decomposed "é", belong to the word but not to its term.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	var term strings.Builder
+	start := -1
+	end := func(at int) {
+		if start >= 0 && term.Len() > 0 {
+			tokens = append(tokens, Token{Term: term.String(), Start: start, End: at})
+		}
+		term.Reset()
+		start = -1
+	}
+	for i, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+			fold(&term, r)
+		case unicode.Is(unicode.Mn, r) && start >= 0:
+		default:
+			end(i)
+		}
+	}
+	end(len(text))
+	return tokens
+}
+
+// Terms returns the distinct terms of the text in their order
+func Terms(text string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, token := range Tokenize(text) {
+		if !seen[token.Term] {
+			seen[token.Term] = true
+			terms = append(terms, token.Term)
+		}
+	}
+	return terms
+}
+
+// editDistance is the number of inserted, deleted, replaced or swapped
+// neighbouring runes which turn a into b, it gives up above max and then
+// returns max+1
+func editDistance(a, b []rune, max int) int {
+	if abs(len(a)-len(b)) > max {
+		return max + 1
+	}
+	// three rows of the optimal string alignment distance
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	row := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		row[0] = i
+		smallest := row[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				row[j] = min(row[j], prev2[j-2]+1)
+			}
+			smallest = min(smallest, row[j])
+		}
+		if smallest > max {
+			return max + 1
+		}
+		prev2, prev, row = prev, row, prev2
+	}
+	return min(prev[len(b)], max+1)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+var ErrInvalidBarcodeDigits = errors.New("barcode must be digits only")
+
+// GTINCheckDigit computes the GS1 check digit of the digits before it: from
+// the right, the digits are weighted 3, 1, 3, ... and the check digit fills
+// the sum up to a multiple of 10
+func GTINCheckDigit(digits string) int {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10 - sum%10) % 10
+}
+
+// LinearBarcode is a barcode of bars and spaces of one module each, true is
+// a bar. Quiet is the number of blank modules needed on either side.
+type LinearBarcode struct {
+	Modules []bool
+	Quiet   int
+	Text    string
+}
+
+// the left-hand odd (L) and right-hand (R) patterns of the EAN digits, the
+// even (G) patterns are the R patterns reversed
+var (
+	eanLeft  = []string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	eanRight = []string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+	// the first digit of an EAN-13 is encoded in the parity of the next six
+	eanParity = []string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+)
+
+// EAN13 encodes 13 digits, a UPC-A is encoded as an EAN-13 starting with 0.
+// The check digit isn't verified.
+func EAN13(digits string) (*LinearBarcode, error) {
+	if len(digits) != 13 || !onlyDigits(digits) {
+		return nil, fmt.Errorf("EAN-13 needs 13 digits: %w", ErrInvalidBarcodeDigits)
+	}
+	var b strings.Builder
+	b.WriteString("101")
+	parity := eanParity[digits[0]-'0']
+	for i := 1; i <= 6; i++ {
+		pattern := eanLeft[digits[i]-'0']
+		if parity[i-1] == 'G' {
+			pattern = reverse(eanRight[digits[i]-'0'])
+		}
+		b.WriteString(pattern)
+	}
+	b.WriteString("01010")
+	for i := 7; i <= 12; i++ {
+		b.WriteString(eanRight[digits[i]-'0'])
+	}
+	b.WriteString("101")
+	return &LinearBarcode{Modules: modules(b.String()), Quiet: 11, Text: digits}, nil
+}
+
+// the widths of the bars or spaces of the ITF digits, n is narrow and w wide
+var itfWidths = []string{"nnwwn", "wnnnw", "nwnnw", "wwnnn", "nnwnw", "wnwnn", "nwwnn", "nnnww", "wnnwn", "nwnwn"}
+
+// ITF14 encodes 14 digits as Interleaved 2 of 5, the symbology printed on
+// cartons. The first digit of each pair is in the bars, the second in the
+// spaces, wide elements are three modules. The check digit isn't verified.
+func ITF14(digits string) (*LinearBarcode, error) {
+	if len(digits) != 14 || !onlyDigits(digits) {
+		return nil, fmt.Errorf("ITF-14 needs 14 digits: %w", ErrInvalidBarcodeDigits)
+	}
+	var b strings.Builder
+	b.WriteString("1010")
+	for i := 0; i < len(digits); i += 2 {
+		bars, spaces := itfWidths[digits[i]-'0'], itfWidths[digits[i+1]-'0']
+		for j := 0; j < 5; j++ {
+			b.WriteString(itfElement("1", bars[j]))
+			b.WriteString(itfElement("0", spaces[j]))
+		}
+	}
+	b.WriteString("11101")
+	return &LinearBarcode{Modules: modules(b.String()), Quiet: 10, Text: digits}, nil
+}
+
+func itfElement(module string, width byte) string {
+	if width == 'w' {
+		return strings.Repeat(module, 3)
+	}
+	return module
+}
+
+// PNG draws the barcode in black on white, every module is scale pixels wide
+// and the bars are height pixels high. The digits aren't drawn.
+func (b *LinearBarcode) PNG(w io.Writer, scale, height int) error {
+	width := (len(b.Modules) + 2*b.Quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for i, bar := range b.Modules {
+		if !bar {
+			continue
+		}
+		x := (b.Quiet + i) * scale
+		for dx := 0; dx < scale; dx++ {
+			for y := 0; y < height; y++ {
+				img.SetGray(x+dx, y, color.Gray{})
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// SVG draws the barcode like PNG with the digits below the bars
+func (b *LinearBarcode) SVG(w io.Writer, scale, height int) error {
+	width := (len(b.Modules) + 2*b.Quiet) * scale
+	fontSize := 9 * scale
+	total := height + fontSize + scale
+	var s strings.Builder
+	fmt.Fprintf(&s, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, total, width, total)
+	fmt.Fprintf(&s, `<rect width="%d" height="%d" fill="#fff"/>`, width, total)
+	// adjacent bar modules are drawn as one rectangle
+	for i := 0; i < len(b.Modules); {
+		if !b.Modules[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(b.Modules) && b.Modules[i] {
+			i++
+		}
+		fmt.Fprintf(&s, `<rect x="%d" y="0" width="%d" height="%d" fill="#000"/>`, (b.Quiet+start)*scale, (i-start)*scale, height)
+	}
+	fmt.Fprintf(&s, `<text x="%d" y="%d" font-family="monospace" font-size="%d" text-anchor="middle">%s</text>`, width/2, height+fontSize, fontSize, b.Text)
+	s.WriteString("</svg>")
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+func onlyDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func modules(pattern string) []bool {
+	result := make([]bool, len(pattern))
+	for i := range pattern {
+		result[i] = pattern[i] == '1'
+	}
+	return result
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGTINCheckDigit(t *testing.T) {
+	assert.Equal(t, 1, GTINCheckDigit("400638133393"))  // EAN-13 4006381333931
+	assert.Equal(t, 2, GTINCheckDigit("03600029145"))   // UPC-A 036000291452
+	assert.Equal(t, 7, GTINCheckDigit("1001234500001")) // GTIN-14 10012345000017
+	assert.Equal(t, 2, GTINCheckDigit("0003600029145")) // leading zeros don't change it
+}
+
+func pattern(modules []bool) string {
+	var b strings.Builder
+	for _, bar := range modules {
+		if bar {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func TestEAN13(t *testing.T) {
+	barcode, err := EAN13("4006381333931")
+	assert.NoError(t, err)
+	modules := pattern(barcode.Modules)
+	assert.Len(t, modules, 95)
+	assert.Equal(t, "101", modules[:3])
+	// the first digit 4 makes the second digit 0 odd (L) and the third digit
+	// 0 even (G)
+	assert.Equal(t, "0001101", modules[3:10])
+	assert.Equal(t, "0100111", modules[10:17])
+	assert.Equal(t, "01010", modules[45:50])
+	// the check digit 1 in the right half
+	assert.Equal(t, "1100110", modules[85:92])
+	assert.Equal(t, "101", modules[92:])
+
+	_, err = EAN13("400638133393")
+	assert.ErrorIs(t, err, ErrInvalidBarcodeDigits)
+	_, err = EAN13("40063813339A1")
+	assert.ErrorIs(t, err, ErrInvalidBarcodeDigits)
+}
+
+func TestITF14(t *testing.T) {
+	barcode, err := ITF14("10012345000017")
+	assert.NoError(t, err)
+	modules := pattern(barcode.Modules)
+	// start, 7 pairs of 18 modules and stop
+	assert.Len(t, modules, 4+7*18+5)
+	assert.Equal(t, "1010", modules[:4])
+	// the pair 1 (bars wnnnw) and 0 (spaces nnwwn)
+	assert.Equal(t, "111010100010001110", modules[4:22])
+	assert.Equal(t, "11101", modules[len(modules)-5:])
+}
+
+func TestLinearBarcodeImages(t *testing.T) {
+	barcode, err := EAN13("4006381333931")
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, barcode.PNG(&buf, 2, 50))
+	img, err := png.Decode(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, (95+2*11)*2, img.Bounds().Dx())
+	assert.Equal(t, 50, img.Bounds().Dy())
+	// the quiet zone is white, the start guard black
+	r, _, _, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+	r, _, _, _ = img.At(11*2, 0).RGBA()
+	assert.Equal(t, uint32(0), r)
+
+	buf.Reset()
+	assert.NoError(t, barcode.SVG(&buf, 1, 50))
+	svg := buf.String()
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="117"`))
+	assert.Contains(t, svg, `<rect x="11" y="0" width="1" height="50" fill="#000"/>`)
+	assert.Contains(t, svg, ">4006381333931</text>")
+}
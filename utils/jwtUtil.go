@@ -1,32 +1,78 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var JwtKey = []byte("my_secret_key") // Define a key used to encrypt and decrypt the JWT
+// lifetime of the access tokens, renew them through POST /token/refresh
+var AccessTokenTTL = 15 * time.Minute
 
 // Claims JWT
 type Claims struct {
+	UserID   int    `json:"uid"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	FamilyID string `json:"fid"` // refresh token family the token was issued for
 	jwt.RegisteredClaims
 }
 
 var GenerateJWT = realGenerateJWT
 
-// Generate JWT token
-func realGenerateJWT(username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Set JWT expired time
+// Generate JWT token for a verified user
+func realGenerateJWT(userID int, username, role, familyID string) (string, error) {
+	jti, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(AccessTokenTTL) // Set JWT expired time
 	claims := &Claims{
+		UserID:   userID,
 		Username: username,
+		Role:     role,
+		FamilyID: familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	// create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JwtKey)
+	// create JWT token, the kid header tells the verifier which key to use
+	key := Keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// ParseJWT verifies the token and returns its claims
+func ParseJWT(tokenString string) (*Claims, error) {
+	keys := Keys
+	claims := &Claims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(keys.Methods()))
+	token, err := parser.ParseWithClaims(tokenString, claims, keys.keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// NewTokenID returns a random identifier for a jti or a token family
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,288 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a key used to sign or verify JWT tokens. Private is nil for
+// keys which are only kept to verify tokens issued before a rotation.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{} // []byte, *rsa.PrivateKey or ed25519.PrivateKey
+	Public  interface{} // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// KeySet holds every key that is accepted for verification and the active
+// key that signs new tokens
+type KeySet struct {
+	mu     sync.RWMutex
+	active string
+	keys   map[string]*SigningKey
+}
+
+// JWK is the public part of a key as published by the JWKS endpoint
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Keys is used by GenerateJWT and ParseJWT, it starts with a random key
+// until LoadKeysFromEnv is called
+var Keys = mustEphemeralKeySet()
+
+var supportedMethods = map[string]jwt.SigningMethod{
+	"HS256": jwt.SigningMethodHS256,
+	"HS384": jwt.SigningMethodHS384,
+	"HS512": jwt.SigningMethodHS512,
+	"RS256": jwt.SigningMethodRS256,
+	"RS384": jwt.SigningMethodRS384,
+	"RS512": jwt.SigningMethodRS512,
+	"EdDSA": jwt.SigningMethodEdDSA,
+}
+
+func NewKeySet(active string, keys ...*SigningKey) (*KeySet, error) {
+	ks := &KeySet{active: active, keys: map[string]*SigningKey{}}
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	activeKey, ok := ks.keys[active]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not configured", active)
+	}
+	if activeKey.Private == nil {
+		return nil, fmt.Errorf("active key %q has no private key", active)
+	}
+	return ks, nil
+}
+
+// Active returns the key that signs new tokens
+func (ks *KeySet) Active() *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[ks.active]
+}
+
+func (ks *KeySet) Lookup(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// Methods returns the algorithms of the configured keys
+func (ks *KeySet) Methods() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	seen := map[string]bool{}
+	var methods []string
+	for _, key := range ks.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// JWKS returns the public asymmetric keys, HMAC secrets are never published
+func (ks *KeySet) JWKS() []JWK {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	jwks := []JWK{}
+	for _, key := range ks.keys {
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return jwks
+}
+
+// keyfunc picks the verification key by the kid header and refuses tokens
+// whose alg header doesn't match the algorithm of that key
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// LoadKeysFromEnv replaces Keys with the keys configured by
+//
+//	JWT_KEYS="kid:alg:path,kid:alg:path"  key files, PEM for RS*/EdDSA or the raw secret for HS*
+//	JWT_ACTIVE_KEY="kid"                  signing key, defaults to the first key with a private key
+//	JWT_SECRET="..."                      single HS256 secret when JWT_KEYS is not set
+//
+// It returns false when nothing is configured and the random key is kept.
+func LoadKeysFromEnv() (bool, error) {
+	var keys []*SigningKey
+	if spec := os.Getenv("JWT_KEYS"); spec != "" {
+		for _, entry := range strings.Split(spec, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+			if len(parts) != 3 {
+				return false, fmt.Errorf("invalid JWT_KEYS entry %q, expected kid:alg:path", entry)
+			}
+			key, err := LoadSigningKey(parts[0], parts[1], parts[2])
+			if err != nil {
+				return false, err
+			}
+			keys = append(keys, key)
+		}
+	} else if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			return false, errors.New("JWT_SECRET must be at least 32 bytes")
+		}
+		keys = append(keys, &SigningKey{
+			ID:      "default",
+			Method:  jwt.SigningMethodHS256,
+			Private: []byte(secret),
+			Public:  []byte(secret),
+		})
+	} else {
+		return false, nil
+	}
+
+	active := os.Getenv("JWT_ACTIVE_KEY")
+	if active == "" {
+		for _, key := range keys {
+			if key.Private != nil {
+				active = key.ID
+				break
+			}
+		}
+	}
+
+	ks, err := NewKeySet(active, keys...)
+	if err != nil {
+		return false, err
+	}
+	Keys = ks
+	return true, nil
+}
+
+// LoadSigningKey reads a key file for the algorithm
+func LoadSigningKey(kid, alg, path string) (*SigningKey, error) {
+	method, ok := supportedMethods[alg]
+	if !ok {
+		return nil, fmt.Errorf("key %q: unsupported algorithm %q", kid, alg)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", kid, err)
+	}
+
+	key := &SigningKey{ID: kid, Method: method}
+	if strings.HasPrefix(alg, "HS") {
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("key %q: HMAC secret must be at least 32 bytes", kid)
+		}
+		key.Private, key.Public = secret, secret
+		return key, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q: no PEM data in %s", kid, path)
+	}
+	parsed, err := parsePEMKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", kid, err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Private, key.Public = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Public = k
+	case ed25519.PrivateKey:
+		key.Private, key.Public = k, k.Public()
+	case ed25519.PublicKey:
+		key.Public = k
+	default:
+		return nil, fmt.Errorf("key %q: unsupported key type %T", kid, parsed)
+	}
+
+	_, isRSA := key.Public.(*rsa.PublicKey)
+	if isRSA != strings.HasPrefix(alg, "RS") {
+		return nil, fmt.Errorf("key %q: key type doesn't match algorithm %s", kid, alg)
+	}
+	return key, nil
+}
+
+func parsePEMKey(block *pem.Block) (crypto.PublicKey, error) {
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return nil, errors.New("unsupported PEM block " + block.Type)
+}
+
+func mustEphemeralKeySet() *KeySet {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	ks, err := NewKeySet("ephemeral", &SigningKey{
+		ID:      "ephemeral",
+		Method:  jwt.SigningMethodHS256,
+		Private: secret,
+		Public:  secret,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}
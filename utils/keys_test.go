@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	assert.NoError(t, err)
+	return path
+}
+
+func useKeys(t *testing.T, ks *KeySet) {
+	previous := Keys
+	Keys = ks
+	t.Cleanup(func() { Keys = previous })
+}
+
+func TestGenerateAndParseJWTWithAsymmetricKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+
+	rsaSigning, err := LoadSigningKey("rsa-1", "RS256", writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)))
+	assert.NoError(t, err)
+	edSigning, err := LoadSigningKey("ed-1", "EdDSA", writePEM(t, "ed.pem", "PRIVATE KEY", edDER))
+	assert.NoError(t, err)
+
+	for _, active := range []string{"rsa-1", "ed-1"} {
+		ks, err := NewKeySet(active, rsaSigning, edSigning)
+		assert.NoError(t, err)
+		useKeys(t, ks)
+
+		token, err := realGenerateJWT(7, "alice", "clerk", "family")
+		assert.NoError(t, err)
+
+		claims, err := ParseJWT(token)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, claims.UserID)
+		assert.Equal(t, "alice", claims.Username)
+		assert.NotEmpty(t, claims.ID)
+	}
+}
+
+func TestParseJWTAcceptsRetiredKeys(t *testing.T) {
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	old := &SigningKey{ID: "old", Method: jwt.SigningMethodEdDSA, Private: oldKey, Public: oldKey.Public()}
+	ks, err := NewKeySet("old", old)
+	assert.NoError(t, err)
+	useKeys(t, ks)
+
+	token, err := realGenerateJWT(1, "alice", "viewer", "family")
+	assert.NoError(t, err)
+
+	// after the rotation the old key only verifies
+	retired := &SigningKey{ID: "old", Method: jwt.SigningMethodEdDSA, Public: oldKey.Public()}
+	current := &SigningKey{ID: "new", Method: jwt.SigningMethodEdDSA, Private: newKey, Public: newKey.Public()}
+	ks, err = NewKeySet("new", current, retired)
+	assert.NoError(t, err)
+	useKeys(t, ks)
+
+	_, err = ParseJWT(token)
+	assert.NoError(t, err)
+}
+
+func TestParseJWTRejectsUnexpectedAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	ks, err := NewKeySet("rsa-1", &SigningKey{ID: "rsa-1", Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey})
+	assert.NoError(t, err)
+	useKeys(t, ks)
+
+	// the classic confusion attack: sign with HS256 using the public key as secret
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "mallory"})
+	forged.Header["kid"] = "rsa-1"
+	tokenString, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	assert.NoError(t, err)
+
+	_, err = ParseJWT(tokenString)
+	assert.Error(t, err)
+
+	// unknown kid
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, &Claims{Username: "mallory"})
+	unknown.Header["kid"] = "other"
+	tokenString, err = unknown.SignedString(rsaKey)
+	assert.NoError(t, err)
+
+	_, err = ParseJWT(tokenString)
+	assert.Error(t, err)
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	ks, err := NewKeySet("hs",
+		&SigningKey{ID: "hs", Method: jwt.SigningMethodHS256, Private: []byte("secret"), Public: []byte("secret")},
+		&SigningKey{ID: "rsa-1", Method: jwt.SigningMethodRS256, Public: &rsaKey.PublicKey},
+		&SigningKey{ID: "ed-1", Method: jwt.SigningMethodEdDSA, Public: edPub},
+	)
+	assert.NoError(t, err)
+
+	jwks := ks.JWKS()
+	assert.Len(t, jwks, 2)
+	for _, jwk := range jwks {
+		assert.NotEqual(t, "hs", jwk.Kid)
+		switch jwk.Kid {
+		case "rsa-1":
+			assert.Equal(t, "RSA", jwk.Kty)
+			assert.Equal(t, "AQAB", jwk.E)
+		case "ed-1":
+			assert.Equal(t, "OKP", jwk.Kty)
+			assert.Equal(t, "Ed25519", jwk.Crv)
+		}
+	}
+}
+
+func TestLoadSigningKeyRejectsMismatchedAlgorithm(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+
+	_, err = LoadSigningKey("ed-1", "RS256", writePEM(t, "ed.pem", "PRIVATE KEY", der))
+	assert.Error(t, err)
+}
+
+func TestLoadKeysFromEnvRejectsShortSecret(t *testing.T) {
+	useKeys(t, Keys)
+	t.Setenv("JWT_KEYS", "")
+	t.Setenv("JWT_SECRET", "my_secret_key")
+
+	_, err := LoadKeysFromEnv()
+	assert.EqualError(t, err, "JWT_SECRET must be at least 32 bytes")
+
+	t.Setenv("JWT_SECRET", "a-secret-of-at-least-thirty-two-bytes")
+	configured, err := LoadKeysFromEnv()
+	assert.NoError(t, err)
+	assert.True(t, configured)
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var ErrInvalidMergePatch = errors.New("merge patch must be a JSON document")
+
+// MergePatch applies a JSON Merge Patch (RFC 7396) to the target document.
+// Members of the patch replace the members of the target, null removes them
+// and nested objects are merged recursively. Numbers keep their exact digits.
+func MergePatch(target, patch []byte) ([]byte, error) {
+	var patchValue interface{}
+	if err := unmarshalNumbers(patch, &patchValue); err != nil {
+		return nil, ErrInvalidMergePatch
+	}
+	var targetValue interface{}
+	if len(target) > 0 {
+		if err := unmarshalNumbers(target, &targetValue); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(mergeValue(targetValue, patchValue))
+}
+
+// unmarshalNumbers is json.Unmarshal with numbers decoded as json.Number
+// instead of float64
+func unmarshalNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after the JSON document")
+	}
+	return nil
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergeValue(targetObject[name], value)
+	}
+	return targetObject
+}